@@ -0,0 +1,79 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// AdminToken protects the /api/admin endpoints and those changing data, passed as
+// "Authorization: Bearer <token>". These endpoints are disabled while it is empty.
+var AdminToken string
+
+// requireAdmin wraps handler so it only runs for requests carrying AdminToken.
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if AdminToken == "" {
+			http.Error(w, "admin endpoints are disabled, start with -admin-token", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="arpmonitor"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func handleAuditLog(store db.Store, w http.ResponseWriter) {
+	entries, err := store.GetAuditLog()
+	if !checkResult(w, err, "audit log") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+func handleBackup(r *http.Request, store db.Store, w http.ResponseWriter) {
+	backuper, ok := store.(db.Backuper)
+	if !ok {
+		http.Error(w, "storage does not support backups", http.StatusNotImplemented)
+		return
+	}
+	compress := false
+	if gz := r.URL.Query().Get("gzip"); gz != "" {
+		parsed, err := strconv.ParseBool(gz)
+		if err != nil {
+			http.Error(w, "invalid gzip parameter", http.StatusBadRequest)
+			return
+		}
+		compress = parsed
+	}
+
+	name := "arpmonitor-" + time.Now().UTC().Format("20060102T150405Z") + ".db"
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	if compress {
+		name += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	// nothing is written before the snapshot exists, so a failed snapshot still gets an error status
+	if err := db.WriteBackup(backuper, w, compress); err != nil {
+		log.Printf("backup failed: %v", err)
+		http.Error(w, "backup failed", http.StatusInternalServerError)
+	}
+}
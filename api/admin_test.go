@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+func getBackup(t *testing.T, store db.Store, query, token string) (*http.Response, []byte) {
+	t.Helper()
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/admin/backup"+query, nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /api/admin/backup failed: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return resp, body
+}
+
+func TestAPI_BackupEndpoint(t *testing.T) {
+	store, err := db.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+
+	orig := AdminToken
+	defer func() { AdminToken = orig }()
+
+	AdminToken = ""
+	if resp, _ := getBackup(t, store, "", "secret"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("without admin token: status %d, want 403", resp.StatusCode)
+	}
+
+	AdminToken = "secret"
+	if resp, _ := getBackup(t, store, "", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong token: status %d, want 401", resp.StatusCode)
+	}
+	if resp, _ := getBackup(t, store, "?gzip=maybe", "secret"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid gzip: status %d, want 400", resp.StatusCode)
+	}
+
+	resp, body := getBackup(t, store, "?gzip=true", "secret")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/gzip" {
+		t.Fatalf("backup: status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("not gzip: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || !bytes.HasPrefix(data, []byte("SQLite format 3\x00")) {
+		t.Errorf("expected an SQLite database, got %d bytes, %v", len(data), err)
+	}
+
+	if resp, _ := getBackup(t, db.NewMemoryStore(), "", "secret"); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("memory store: status %d, want 501", resp.StatusCode)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// maxAnnotationBody bounds the request body of an annotation update.
+const maxAnnotationBody = 64 << 10
+
+// pathMAC returns the {mac} path value in canonical notation, or answers with 400.
+func pathMAC(w http.ResponseWriter, r *http.Request) (string, bool) {
+	mac, err := db.ParseMAC(r.PathValue("mac"))
+	if err != nil {
+		http.Error(w, "invalid MAC address", http.StatusBadRequest)
+		return "", false
+	}
+	return mac, true
+}
+
+func handleGetAnnotation(r *http.Request, store db.Store, w http.ResponseWriter) {
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+	annotation, err := store.GetAnnotation(mac)
+	if err != nil {
+		http.Error(w, "error on reading annotation", http.StatusInternalServerError)
+		return
+	}
+	if annotation == nil {
+		http.Error(w, "no annotation for "+mac, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(annotation); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+// handlePutAnnotation replaces the annotation of a MAC with the JSON body, fields left out are cleared.
+func handlePutAnnotation(r *http.Request, store db.Store, w http.ResponseWriter) {
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+	var annotation db.Annotation
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&annotation); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "annotation too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid annotation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if annotation.MAC != "" {
+		if bodyMAC, err := db.ParseMAC(annotation.MAC); err != nil || bodyMAC != mac {
+			http.Error(w, "mac in the body does not match the path", http.StatusBadRequest)
+			return
+		}
+	}
+	annotation.MAC = mac
+	if err := annotation.Normalize(); err != nil {
+		http.Error(w, "invalid annotation: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := store.SetAnnotation(annotation)
+	if err != nil {
+		http.Error(w, "error on storing annotation", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(stored); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+func handleDeleteAnnotation(r *http.Request, store db.Store, w http.ResponseWriter) {
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+	deleted, err := store.DeleteAnnotation(mac)
+	if err != nil {
+		http.Error(w, "error on deleting annotation", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "no annotation for "+mac, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// etherName makes a hostname fit the whitespace separated columns of /api/ethers, annotated
+// names may contain spaces.
+func etherName(name string) string {
+	return strings.Join(strings.Fields(name), "_")
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+func serveAnnotation(mux *http.ServeMux, method, mac, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/devices/"+mac+"/annotations", strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPI_AnnotationsEndpoint(t *testing.T) {
+	orig := AdminToken
+	defer func() { AdminToken = orig }()
+	AdminToken = "secret"
+
+	store := db.NewMemoryStore()
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	body := `{"name": "Living room TV", "owner": "alice", "tags": ["media"]}`
+	if rec := serveAnnotation(mux, http.MethodPut, "00:11:22:33:44:55", body, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("PUT without token: status %d, want 401", rec.Code)
+	}
+	for _, invalid := range []string{`{"name": `, `{"nickname": "tv"}`, `{"mac": "66:77:88:99:aa:bb"}`, `{"name": "a\u0000b"}`} {
+		if rec := serveAnnotation(mux, http.MethodPut, "00:11:22:33:44:55", invalid, "secret"); rec.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: status %d, want 400", invalid, rec.Code)
+		}
+	}
+
+	rec := serveAnnotation(mux, http.MethodPut, "00-11-22-33-44-55", body, "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var stored db.Annotation
+	if err := json.NewDecoder(rec.Body).Decode(&stored); err != nil {
+		t.Fatalf("decode annotation: %v", err)
+	}
+	if stored.MAC != "00:11:22:33:44:55" || stored.Name != "Living room TV" || stored.UpdatedAt.IsZero() {
+		t.Errorf("unexpected stored annotation: %+v", stored)
+	}
+
+	// reading needs no token
+	rec = serveAnnotation(mux, http.MethodGet, "00:11:22:33:44:55", "", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"owner":"alice"`) {
+		t.Errorf("GET: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// the manual name shows up in /api/current and /api/ethers
+	origLookup := lookupEntry
+	defer func() { lookupEntry = origLookup }()
+	lookupEntry = lookupEntryFunc
+	netLookupAddr = func(addr string) ([]string, error) { return []string{"tv.lan."}, nil }
+	defer func() { netLookupAddr = func(addr string) ([]string, error) { return mockLookupAddr(addr) } }()
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/current", nil))
+	var entries []db.ArpEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatalf("decode entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Hostname != "Living room TV" || entries[0].Annotation == nil ||
+		entries[0].Annotation.Tags[0] != "media" {
+		t.Errorf("expected the annotation inline, got %+v", entries)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ethers", nil))
+	if !strings.Contains(rec.Body.String(), "00:11:22:33:44:55    Living_room_TV") {
+		t.Errorf("expected the name in /api/ethers, got %s", rec.Body.String())
+	}
+
+	if rec := serveAnnotation(mux, http.MethodDelete, "00:11:22:33:44:55", "", "secret"); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE: status %d, want 204", rec.Code)
+	}
+	if rec := serveAnnotation(mux, http.MethodDelete, "00:11:22:33:44:55", "", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE: status %d, want 404", rec.Code)
+	}
+	if rec := serveAnnotation(mux, http.MethodGet, "00:11:22:33:44:55", "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE: status %d, want 404", rec.Code)
+	}
+	if rec := serveAnnotation(mux, http.MethodGet, "nonsense", "", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET invalid MAC: status %d, want 400", rec.Code)
+	}
+}
+
+func TestLookupEntryFunc_AnnotationName(t *testing.T) {
+	defer func() { netLookupAddr = func(addr string) ([]string, error) { return mockLookupAddr(addr) } }()
+	netLookupAddr = func(addr string) ([]string, error) { return []string{"host4.local."}, nil }
+
+	entry := db.ArpEntry{IPv4: []string{"1.2.3.4"}, Annotation: &db.Annotation{Name: "printer"}}
+	lookupEntryFunc(&entry, false, "", false)
+	if entry.Hostname != "printer" || entry.HostnameSource != hostnameAnnotation {
+		t.Errorf("expected the annotated name, got %q from %q", entry.Hostname, entry.HostnameSource)
+	}
+
+	entry = db.ArpEntry{IPv4: []string{"1.2.3.4"}, Annotation: &db.Annotation{Owner: "bob"}}
+	lookupEntryFunc(&entry, false, "", false)
+	if entry.Hostname != "host4.local." || entry.HostnameSource != hostnameDNS {
+		t.Errorf("an annotation without name must not hide DNS, got %q from %q", entry.Hostname, entry.HostnameSource)
+	}
+}
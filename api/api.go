@@ -1,23 +1,24 @@
 package api
 
 import (
-	"database/sql"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/vgropp/arpmonitor/internal/db"
 )
 
-var getRecentEntries = db.GetRecentEntries
 var lookupEntry = lookupEntryFunc
 var netLookupAddr = net.LookupAddr
+var netLookupHost = net.LookupHost
 
 var leasesFiles = []string{
 	"/var/lib/kea/kea-leases4.csv",
@@ -28,29 +29,105 @@ var ListenAndServe = func(addr string, handler http.Handler) error {
 	return http.ListenAndServe(addr, handler)
 }
 
-func RegisterHandlers(mux *http.ServeMux, database *sql.DB, resolveIpv6 bool, preferIpv4Net string, filterZeroIps bool, resolveKeaLeases bool) {
-	mux.HandleFunc("/api/current", func(w http.ResponseWriter, r *http.Request) {
-		handleJson(r, database, w, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+func RegisterHandlers(mux *http.ServeMux, store db.Store, resolveIpv6 bool, preferIpv4Net string, filterZeroIps bool, resolveKeaLeases bool) {
+	handle := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, instrument(pattern, h))
+	}
+	handle("/api/current", func(w http.ResponseWriter, r *http.Request) {
+		handleJson(r, store, w, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+	})
+	handle("/api/ethers", func(w http.ResponseWriter, r *http.Request) {
+		handleEthers(r, store, w, resolveIpv6, preferIpv4Net, filterZeroIps, resolveKeaLeases)
+	})
+	handle("/api/dhcp-servers", func(w http.ResponseWriter, r *http.Request) {
+		handleDHCPServers(store, w)
+	})
+	handle("/api/counters", func(w http.ResponseWriter, r *http.Request) {
+		handleCounters(r, store, w)
+	})
+	handle("/api/devices/{mac}/sessions", func(w http.ResponseWriter, r *http.Request) {
+		handleSessions(r, store, w)
+	})
+	handle("GET /api/devices", func(w http.ResponseWriter, r *http.Request) {
+		handleDevices(r, store, w)
+	})
+	handle("GET /api/devices/{mac}", func(w http.ResponseWriter, r *http.Request) {
+		handleDevice(r, store, w, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+	})
+	handle("DELETE /api/devices/{mac}", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		handleForget(r, store, w)
+	}))
+	handle("POST /api/devices/{mac}/approve", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		handleDecision(r, store, w, db.DeviceApproved)
+	}))
+	handle("POST /api/devices/{mac}/reject", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		handleDecision(r, store, w, db.DeviceRejected)
+	}))
+	handle("GET /api/devices/{mac}/annotations", func(w http.ResponseWriter, r *http.Request) {
+		handleGetAnnotation(r, store, w)
 	})
-	mux.HandleFunc("/api/ethers", func(w http.ResponseWriter, r *http.Request) {
-		handleEthers(r, database, w, resolveIpv6, preferIpv4Net, filterZeroIps, resolveKeaLeases)
+	handle("PUT /api/devices/{mac}/annotations", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		handlePutAnnotation(r, store, w)
+	}))
+	handle("DELETE /api/devices/{mac}/annotations", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		handleDeleteAnnotation(r, store, w)
+	}))
+	handle("/api/ips/{ip}/history", func(w http.ResponseWriter, r *http.Request) {
+		handleIPHistory(r, store, w)
+	})
+	// CIDR queries with an unescaped slash, e.g. /api/ips/192.168.1.0/24/history
+	handle("/api/ips/{ip}/{bits}/history", func(w http.ResponseWriter, r *http.Request) {
+		handleIPHistory(r, store, w)
+	})
+	handle("GET /api/lookup", func(w http.ResponseWriter, r *http.Request) {
+		handleLookup(r, store, w, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+	})
+	handle("POST /api/lookup", func(w http.ResponseWriter, r *http.Request) {
+		handleBulkLookup(r, store, w, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+	})
+	handle("/api/changes", func(w http.ResponseWriter, r *http.Request) {
+		handleChanges(r, store, w)
+	})
+	handle("/api/health", func(w http.ResponseWriter, r *http.Request) {
+		handleHealth(store, w)
+	})
+	handle("/api/admin/audit", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		handleAuditLog(store, w)
+	}))
+	handle("/api/admin/backup", requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		handleBackup(r, store, w)
+	}))
+	handle("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		handleMetrics(store, w)
 	})
 }
 
-func handleEthers(r *http.Request, database *sql.DB, w http.ResponseWriter, resolveIpv6 bool, preferIpv4Net string, filterZeroIps bool, resolveKeaLeases bool) {
-	daysStr := r.URL.Query().Get("days")
-	days := 7
-	if daysStr != "" {
-		if parsed, err := strconv.Atoi(daysStr); err == nil {
-			days = parsed
-		}
+func handleEthers(r *http.Request, store db.Store, w http.ResponseWriter, resolveIpv6 bool, preferIpv4Net string, filterZeroIps bool, resolveKeaLeases bool) {
+	since, until, err := parseTimeRange(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	entries, err := getRecentEntries(database, days)
+	query, err := parseListQuery(r)
 	if err != nil {
-		http.Error(w, "error on reading entries", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := store.GetEntries(since, until)
+	if !checkResult(w, err, "entries") {
 		return
 	}
+	if filterZeroIps {
+		// before paging, so that a page holds limit lines and the cursor follows the listed devices
+		entries = withoutZeroIPs(entries, preferIpv4Net)
+	}
+	entries, next := query.apply(entries, func(entry *db.ArpEntry) {
+		lookupEntry(entry, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+	}, preferIpv4Net)
+	if next != "" {
+		w.Header().Set(NextCursorHeader, next)
+	}
 
 	w.Header().Set("Content-Type", "text/plain")
 	if _, err := w.Write([]byte("# MAC-Address          Hostname             IPv4-Address      IPv6-Address\n")); err != nil {
@@ -59,50 +136,231 @@ func handleEthers(r *http.Request, database *sql.DB, w http.ResponseWriter, reso
 	}
 
 	for _, entry := range entries {
+		if _, err := fmt.Fprintf(w, "%-20s %-20s %-15s %-15s\n", entry.MAC, etherName(entry.Hostname),
+			firstMatchOrEmpty(entry.IPv4, preferIpv4Net), firstMatchOrEmpty(entry.IPv6, "")); err != nil {
+			http.Error(w, "error writing header", http.StatusInternalServerError)
+			return
+		}
+	}
+}
 
-		lookupEntry(&entry, resolveIpv6, preferIpv4Net, resolveKeaLeases)
-
-		ipv4 := firstMatchOrEmpty(entry.IPv4, preferIpv4Net)
-		if filterZeroIps && ipv4 == "0.0.0.0" && len(entry.IPv6) == 0 {
+// withoutZeroIPs drops the entries whose only address is 0.0.0.0, e.g. of DHCP clients probing.
+func withoutZeroIPs(entries []db.ArpEntry, preferIpv4Net string) []db.ArpEntry {
+	kept := entries[:0]
+	for _, entry := range entries {
+		if firstMatchOrEmpty(entry.IPv4, preferIpv4Net) == "0.0.0.0" && len(entry.IPv6) == 0 {
 			continue
 		}
-		if _, err := fmt.Fprintf(w, "%-20s %-20s %-15s %-15s\n", entry.MAC, entry.Hostname,
-			ipv4, firstMatchOrEmpty(entry.IPv6, "")); err != nil {
-			http.Error(w, "error writing header", http.StatusInternalServerError)
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+func handleJson(r *http.Request, store db.Store, w http.ResponseWriter, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
+	since, until, err := parseTimeRange(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := store.GetEntries(since, until)
+	if !checkResult(w, err, "entries") {
+		return
+	}
+	entries, next := query.apply(entries, func(entry *db.ArpEntry) {
+		lookupEntry(entry, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+	}, preferIpv4Net)
+	if next != "" {
+		w.Header().Set(NextCursorHeader, next)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+func handleDHCPServers(store db.Store, w http.ResponseWriter) {
+	servers, err := store.GetDHCPServers()
+	if !checkResult(w, err, "dhcp servers") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(servers); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+func handleCounters(r *http.Request, store db.Store, w http.ResponseWriter) {
+	hours := 24
+	if hoursStr := r.URL.Query().Get("hours"); hoursStr != "" {
+		var err error
+		if hours, err = strconv.Atoi(hoursStr); err != nil || hours <= 0 {
+			http.Error(w, fmt.Sprintf("invalid hours %q", hoursStr), http.StatusBadRequest)
 			return
 		}
 	}
+
+	counters, err := store.GetPacketCounts(hours)
+	if !checkResult(w, err, "counters") {
+		return
+	}
+
+	if mac := r.URL.Query().Get("mac"); mac != "" {
+		mac = strings.ToLower(mac)
+		filtered := counters[:0]
+		for _, c := range counters {
+			if strings.ToLower(c.MAC) == mac {
+				filtered = append(filtered, c)
+			}
+		}
+		counters = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(counters); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
 }
 
-func handleJson(r *http.Request, database *sql.DB, w http.ResponseWriter, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
-	daysStr := r.URL.Query().Get("days")
+func handleSessions(r *http.Request, store db.Store, w http.ResponseWriter) {
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
 	days := 7
-	if daysStr != "" {
-		if parsed, err := strconv.Atoi(daysStr); err == nil {
-			days = parsed
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		var err error
+		if days, err = strconv.Atoi(daysStr); err != nil || days < 0 {
+			http.Error(w, fmt.Sprintf("invalid days %q", daysStr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sessions, err := store.GetSessions(mac, days)
+	if !checkResult(w, err, "sessions") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+// parseTimeRange reads the range of /api/current and /api/ethers: since and until as RFC 3339
+// timestamps or durations back from now (e.g. since=2h), or the last days (default 7) without
+// since. A zero until is open ended.
+func parseTimeRange(r *http.Request, now time.Time) (since, until time.Time, err error) {
+	q := r.URL.Query()
+	parse := func(name string) (time.Time, error) {
+		v := q.Get(name)
+		if v == "" {
+			return time.Time{}, nil
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return now.Add(-d), nil
+		}
+		return time.Time{}, fmt.Errorf("invalid %s %q, use an RFC 3339 timestamp or a duration like 2h", name, v)
+	}
+
+	if since, err = parse("since"); err != nil {
+		return
+	}
+	if until, err = parse("until"); err != nil {
+		return
+	}
+	if since.IsZero() {
+		days := 7
+		if daysStr := q.Get("days"); daysStr != "" {
+			if days, err = strconv.Atoi(daysStr); err != nil || days < 0 {
+				return since, until, fmt.Errorf("invalid days %q", daysStr)
+			}
 		}
+		since = now.Add(-time.Duration(days) * 24 * time.Hour)
+	}
+	if !until.IsZero() && until.Before(since) {
+		return since, until, fmt.Errorf("until is before since")
 	}
+	return since, until, nil
+}
 
-	entries, err := getRecentEntries(database, days)
+// parsePrefix parses an address or a CIDR prefix, a single address becomes a /32 or /128.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix, nil
+	}
+	addr, err := netip.ParseAddr(s)
 	if err != nil {
-		http.Error(w, "error on reading entries", http.StatusInternalServerError)
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func handleIPHistory(r *http.Request, store db.Store, w http.ResponseWriter) {
+	query := r.PathValue("ip")
+	if bits := r.PathValue("bits"); bits != "" {
+		query += "/" + bits
+	}
+	prefix, err := parsePrefix(query)
+	if err != nil || !prefix.IsValid() {
+		http.Error(w, "invalid IP address or CIDR", http.StatusBadRequest)
 		return
 	}
+	var at time.Time
+	if atStr := r.URL.Query().Get("at"); atStr != "" {
+		if at, err = time.Parse(time.RFC3339, atStr); err != nil {
+			http.Error(w, "invalid at, use RFC 3339", http.StatusBadRequest)
+			return
+		}
+	}
 
-	for _, entry := range entries {
-		lookupEntry(&entry, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+	bindings, err := store.GetIPHistory(prefix)
+	if !checkResult(w, err, "ip history") {
+		return
+	}
+	if !at.IsZero() {
+		filtered := bindings[:0]
+		for _, b := range bindings {
+			if !at.Before(b.FirstSeen) && !at.After(b.LastSeen) {
+				filtered = append(filtered, b)
+			}
+		}
+		bindings = filtered
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
-	if err := json.NewEncoder(w).Encode(entries); err != nil {
+	if err := json.NewEncoder(w).Encode(bindings); err != nil {
 		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
 	}
 }
 
-func StartAPI(port int, database *sql.DB, resolveIpv6 bool, preferIpv4Net string, filterZeroIps bool, resolveKeaLeases bool) *http.ServeMux {
+func StartAPI(port int, store db.Store, resolveIpv6 bool, preferIpv4Net string, filterZeroIps bool, resolveKeaLeases bool) *http.ServeMux {
 	mux := http.NewServeMux()
-	RegisterHandlers(mux, database, resolveIpv6, preferIpv4Net, filterZeroIps, resolveKeaLeases)
+	RegisterHandlers(mux, store, resolveIpv6, preferIpv4Net, filterZeroIps, resolveKeaLeases)
 	addr := fmt.Sprintf(":%d", port)
 	fmt.Printf("API: http://localhost%s/api/current\n", addr)
 	if err := ListenAndServe(addr, mux); err != nil {
@@ -158,17 +416,32 @@ func lookupHostnameFromLeases(mac string, path string) string {
 	return ""
 }
 
+// Sources of a resolved hostname, see db.ArpEntry.HostnameSource.
+const (
+	hostnameAnnotation = "annotation"
+	hostnameDNS        = "dns"
+	hostnameKeaLease   = "kea-lease"
+)
+
 func lookupEntryFunc(entry *db.ArpEntry, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
+	defer observeHostname(entry)
+
+	// a name given by an admin beats whatever DNS or the leases say
+	if entry.Annotation != nil && entry.Annotation.Name != "" {
+		entry.Hostname, entry.HostnameSource = entry.Annotation.Name, hostnameAnnotation
+		return
+	}
+
 	names, err := netLookupAddr(firstMatchOrEmpty(entry.IPv4, preferIpv4Net))
 	if err == nil && len(names) > 0 && names[0] != "" {
-		entry.Hostname = names[0]
+		entry.Hostname, entry.HostnameSource = names[0], hostnameDNS
 		return
 	}
 
 	if resolveIpv6 {
 		names, err = netLookupAddr(firstMatchOrEmpty(entry.IPv6, ""))
 		if err == nil && len(names) > 0 && names[0] != "" {
-			entry.Hostname = names[0]
+			entry.Hostname, entry.HostnameSource = names[0], hostnameDNS
 			return
 		}
 	}
@@ -177,7 +450,7 @@ func lookupEntryFunc(entry *db.ArpEntry, resolveIpv6 bool, preferIpv4Net string,
 		for _, file := range leasesFiles {
 			leaseName := lookupHostnameFromLeases(entry.MAC, file)
 			if leaseName != "" {
-				entry.Hostname = leaseName
+				entry.Hostname, entry.HostnameSource = leaseName, hostnameKeaLease
 				return
 			}
 		}
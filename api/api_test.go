@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/vgropp/arpmonitor/internal/db"
 )
@@ -164,6 +166,9 @@ func init() {
 	netLookupAddr = func(addr string) ([]string, error) {
 		return mockLookupAddr(addr)
 	}
+	netLookupHost = func(host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
 }
 
 var testEntries = []db.ArpEntry{
@@ -181,21 +186,35 @@ var testEntries = []db.ArpEntry{
 	},
 }
 
-func setupTestAPI() (func(), string) {
-	origGetRecentEntries := getRecentEntries
-	getRecentEntries = func(database *sql.DB, days int) ([]db.ArpEntry, error) {
-		return testEntries, nil
+// newTestStore returns an in-memory store containing the addresses of testEntries.
+func newTestStore() *db.MemoryStore {
+	store := db.NewMemoryStore()
+	for _, e := range testEntries {
+		for _, ip := range append(append([]string{}, e.IPv4...), e.IPv6...) {
+			if err := store.InsertARPEvent(ip, e.MAC); err != nil {
+				panic(err)
+			}
+		}
 	}
+	return store
+}
+
+func setupTestAPI() (func(), string) {
 	origLookupEntry := lookupEntry
-	lookupEntry = func(entry *db.ArpEntry, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {}
+	lookupEntry = func(entry *db.ArpEntry, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
+		for _, e := range testEntries {
+			if e.MAC == entry.MAC {
+				entry.Hostname = e.Hostname
+			}
+		}
+	}
 
 	mux := http.NewServeMux()
-	RegisterHandlers(mux, nil, false, "", false, false)
+	RegisterHandlers(mux, newTestStore(), false, "", false, false)
 	server := httptest.NewServer(mux)
 
 	// Save server for cleanup
 	cleanup := func() {
-		getRecentEntries = origGetRecentEntries
 		lookupEntry = origLookupEntry
 		server.Close()
 	}
@@ -276,7 +295,7 @@ func TestStartAPI(t *testing.T) {
 	if err := db.CreateTable(database); err != nil {
 		log.Fatalf("failed to create table: %v", err)
 	}
-	mux := StartAPI(8080, database, false, "", false, false)
+	mux := StartAPI(8080, &db.SQLiteStore{DB: database}, false, "", false, false)
 	testServer := httptest.NewServer(mux)
 
 	resp, err := http.Get(testServer.URL + "/api/current")
@@ -312,3 +331,246 @@ func TestStartAPI(t *testing.T) {
 	}
 
 }
+
+func TestAPI_DHCPServersEndpoint(t *testing.T) {
+	store := db.NewMemoryStore()
+	for i := 0; i < 3; i++ {
+		if err := store.RecordDHCPServer(db.DHCPServer{ServerID: "192.168.0.1", MAC: "66:77:88:99:aa:bb"}); err != nil {
+			t.Fatalf("RecordDHCPServer failed: %v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/dhcp-servers")
+	if err != nil {
+		t.Fatalf("GET /api/dhcp-servers failed: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+	var servers []db.DHCPServer
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		t.Fatalf("decode /api/dhcp-servers: %v", err)
+	}
+	if len(servers) != 1 || servers[0].ServerID != "192.168.0.1" || servers[0].Count != 3 {
+		t.Errorf("unexpected servers: %+v", servers)
+	}
+}
+
+func TestAPI_CountersEndpoint(t *testing.T) {
+	store := db.NewMemoryStore()
+	hour := time.Now().UTC().Truncate(time.Hour)
+	if err := store.AddPacketCounts(map[db.CounterKey]db.PacketCounts{
+		{MAC: "66:77:88:99:aa:bb", Hour: hour}:                      {ARPPackets: 500},
+		{MAC: "66:77:88:99:aa:bb", Hour: hour.Add(-12 * time.Hour)}: {ARPPackets: 1000},
+		{MAC: "00:11:22:33:44:55", Hour: hour}:                      {ARPPackets: 2},
+	}); err != nil {
+		t.Fatalf("AddPacketCounts failed: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/counters?hours=6&mac=66:77:88:99:AA:BB")
+	if err != nil {
+		t.Fatalf("GET /api/counters failed: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+	var counters []db.MACCounters
+	if err := json.NewDecoder(resp.Body).Decode(&counters); err != nil {
+		t.Fatalf("decode /api/counters: %v", err)
+	}
+	if len(counters) != 1 || counters[0].ARPPackets != 500 {
+		t.Errorf("unexpected counters: %+v", counters)
+	}
+
+	for _, hours := range []string{"abc", "0", "-1"} {
+		resp, err := http.Get(server.URL + "/api/counters?hours=" + hours)
+		if err != nil {
+			t.Fatalf("GET /api/counters failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("hours=%s: status %d, want 400", hours, resp.StatusCode)
+		}
+	}
+}
+
+func TestAPI_SessionsEndpoint(t *testing.T) {
+	store := db.NewMemoryStore()
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/devices/00-11-22-33-44-55/sessions?days=1")
+	if err != nil {
+		t.Fatalf("GET /api/devices/{mac}/sessions failed: %v", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("failed to close response body: %v", err)
+		}
+	}()
+	var sessions []db.Session
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		t.Fatalf("decode sessions: %v", err)
+	}
+	if len(sessions) != 1 || !sessions[0].Active || sessions[0].MAC != "00:11:22:33:44:55" {
+		t.Errorf("unexpected sessions: %+v", sessions)
+	}
+
+	resp, err = http.Get(server.URL + "/api/devices/nonsense/sessions")
+	if err != nil {
+		t.Fatalf("GET /api/devices/{mac}/sessions failed: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid MAC: status %d, want 400", resp.StatusCode)
+	}
+
+	for _, days := range []string{"abc", "-1"} {
+		resp, err = http.Get(server.URL + "/api/devices/00-11-22-33-44-55/sessions?days=" + days)
+		if err != nil {
+			t.Fatalf("GET /api/devices/{mac}/sessions failed: %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("days=%s: status %d, want 400", days, resp.StatusCode)
+		}
+	}
+}
+
+func TestAPI_IPHistoryEndpoint(t *testing.T) {
+	store := db.NewMemoryStore()
+	for _, e := range []struct{ ip, mac string }{
+		{"192.168.1.57", "00:11:22:33:44:55"},
+		{"192.168.1.58", "66:77:88:99:aa:bb"},
+		{"fe80::1", "00:11:22:33:44:55"},
+	} {
+		if err := store.InsertARPEvent(e.ip, e.mac); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	get := func(path string) (int, []db.IPBinding) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				log.Printf("failed to close response body: %v", err)
+			}
+		}()
+		var bindings []db.IPBinding
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&bindings); err != nil {
+				t.Fatalf("decode %s: %v", path, err)
+			}
+		}
+		return resp.StatusCode, bindings
+	}
+
+	if status, bindings := get("/api/ips/192.168.1.57/history"); status != http.StatusOK ||
+		len(bindings) != 1 || bindings[0].MAC != "00:11:22:33:44:55" {
+		t.Errorf("single address: %d %+v", status, bindings)
+	}
+	for _, path := range []string{"/api/ips/192.168.1.0/24/history", "/api/ips/192.168.1.0%2F24/history"} {
+		if status, bindings := get(path); status != http.StatusOK || len(bindings) != 2 {
+			t.Errorf("%s: %d %+v", path, status, bindings)
+		}
+	}
+	if status, bindings := get("/api/ips/fe80::/10/history"); status != http.StatusOK || len(bindings) != 1 {
+		t.Errorf("IPv6 CIDR: %d %+v", status, bindings)
+	}
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if status, bindings := get("/api/ips/192.168.1.57/history?at=" + past); status != http.StatusOK || len(bindings) != 0 {
+		t.Errorf("at before the first sighting: %d %+v", status, bindings)
+	}
+	for _, path := range []string{"/api/ips/nonsense/history", "/api/ips/192.168.1.0/33/history", "/api/ips/192.168.1.57/history?at=yesterday"} {
+		if status, _ := get(path); status != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", path, status)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Date(2025, 5, 30, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query        string
+		since, until time.Time
+		wantErr      bool
+	}{
+		{query: "", since: now.Add(-7 * 24 * time.Hour)},
+		{query: "days=2", since: now.Add(-48 * time.Hour)},
+		{query: "since=2h", since: now.Add(-2 * time.Hour)},
+		{query: "since=2h&days=30", since: now.Add(-2 * time.Hour)},
+		{query: "since=2025-05-27T14:00:00%2B02:00&until=2025-05-27T13:00:00Z",
+			since: time.Date(2025, 5, 27, 12, 0, 0, 0, time.UTC), until: time.Date(2025, 5, 27, 13, 0, 0, 0, time.UTC)},
+		{query: "days=1&until=30m", since: now.Add(-24 * time.Hour), until: now.Add(-30 * time.Minute)},
+		{query: "days=abc", wantErr: true},
+		{query: "since=yesterday", wantErr: true},
+		{query: "since=-2h", wantErr: true},
+		{query: "until=2025-13-01T00:00:00Z", wantErr: true},
+		{query: "since=1h&until=2h", wantErr: true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/current?"+tt.query, nil)
+		since, until, err := parseTimeRange(r, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (!since.Equal(tt.since) || !until.Equal(tt.until)) {
+			t.Errorf("%q: got %v - %v, want %v - %v", tt.query, since, until, tt.since, tt.until)
+		}
+	}
+}
+
+func TestAPI_CurrentEndpoint_TimeRange(t *testing.T) {
+	cleanup, url := setupTestAPI()
+	defer cleanup()
+
+	resp, err := http.Get(url + "/api/current?since=1h&until=30m")
+	if err != nil {
+		t.Fatalf("GET /api/current failed: %v", err)
+	}
+	var entries []db.ArpEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil || len(entries) != 0 {
+		t.Errorf("expected no entries seen between 1h and 30m ago, got %+v, %v", entries, err)
+	}
+	_ = resp.Body.Close()
+
+	for _, path := range []string{"/api/current?since=soon", "/api/ethers?days=abc", "/api/ethers?until=1h&since=10m"} {
+		resp, err := http.Get(url + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// changeFeed is the answer of /api/changes. Polling again with since set to Until misses nothing.
+type changeFeed struct {
+	Since   time.Time   `json:"since"`
+	Until   time.Time   `json:"until"`
+	Changes []db.Change `json:"changes"`
+}
+
+// handleChanges lists the devices that are new, changed their address or hostname, or went silent
+// since the required since parameter.
+func handleChanges(r *http.Request, store db.Store, w http.ResponseWriter) {
+	if r.URL.Query().Get("since") == "" {
+		http.Error(w, "since is required", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("until") != "" {
+		http.Error(w, "until is not supported, the feed always ends now", http.StatusBadRequest)
+		return
+	}
+	since, _, err := parseTimeRange(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// later changes belong to the next poll
+	until := time.Now()
+	changes, err := store.GetChanges(since)
+	if !checkResult(w, err, "changes") {
+		return
+	}
+	feed := changeFeed{Since: since, Until: until, Changes: []db.Change{}}
+	for _, c := range changes {
+		if c.At.Before(until) {
+			feed.Changes = append(feed.Changes, c)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(feed); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+// RunHostnameRecorder resolves the hostnames of the devices seen within the last interval right
+// away and then every interval, and records them for the change feed. A rename is detected
+// within one interval. An interval of 0 or less records nothing.
+func RunHostnameRecorder(store db.Store, interval time.Duration, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
+	if interval <= 0 {
+		return
+	}
+	for {
+		recordHostnames(store, time.Now().Add(-interval), func(entry *db.ArpEntry) {
+			lookupEntry(entry, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+		})
+		time.Sleep(interval)
+	}
+}
+
+// recordHostnames resolves the devices seen since since and records their hostnames.
+func recordHostnames(store db.Store, since time.Time, resolve func(*db.ArpEntry)) {
+	entries, err := store.GetEntries(since, time.Time{})
+	if err != nil && !db.IsPartial(err) {
+		log.Printf("hostnames: failed to read devices: %v", err)
+		return
+	}
+	all := make([]*db.ArpEntry, len(entries))
+	for i := range entries {
+		all[i] = &entries[i]
+	}
+	resolveConcurrently(all, resolve)
+
+	for _, entry := range all {
+		if entry.Hostname == "" {
+			// a failed lookup is no rename
+			continue
+		}
+		if err := store.RecordHostname(entry.MAC, entry.Hostname, entry.HostnameSource); err != nil {
+			log.Printf("hostnames: failed to record %s of %s: %v", entry.Hostname, entry.MAC, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+func TestAPI_ChangesEndpoint(t *testing.T) {
+	name := "printer.lan."
+	netLookupAddr = func(addr string) ([]string, error) { return []string{name}, nil }
+	defer func() { netLookupAddr = func(addr string) ([]string, error) { return mockLookupAddr(addr) } }()
+
+	store := db.NewMemoryStore()
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+	resolve := func(entry *db.ArpEntry) { lookupEntry(entry, false, "", false) }
+	recordHostnames(store, time.Now().Add(-time.Minute), resolve)
+
+	poll := func(since string) changeFeed {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/changes?since="+url.QueryEscape(since), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/changes?since=%s: status %d: %s", since, rec.Code, rec.Body.String())
+		}
+		var feed changeFeed
+		if err := json.NewDecoder(rec.Body).Decode(&feed); err != nil {
+			t.Fatalf("decode changes: %v", err)
+		}
+		return feed
+	}
+
+	feed := poll("1h")
+	if len(feed.Changes) != 1 || feed.Changes[0].Type != db.ChangeNew || feed.Changes[0].IP != "192.168.1.10" ||
+		feed.Changes[0].Hostname != "printer.lan." {
+		t.Fatalf("unexpected first poll: %+v", feed)
+	}
+
+	// the next poll continues where the first ended and sees the rename, recorded before it
+	name = "laser.lan."
+	recordHostnames(store, time.Now().Add(-time.Minute), resolve)
+	feed = poll(feed.Until.Format(time.RFC3339Nano))
+	if len(feed.Changes) != 1 || feed.Changes[0].Type != db.ChangeHostname || feed.Changes[0].Hostname != "laser.lan." ||
+		feed.Changes[0].PreviousHostname != "printer.lan." {
+		t.Fatalf("unexpected second poll: %+v", feed)
+	}
+	if feed = poll(feed.Until.Format(time.RFC3339Nano)); len(feed.Changes) != 0 {
+		t.Errorf("expected no changes, got %+v", feed)
+	}
+
+	// polling alone resolves nothing
+	name = "printer.lan."
+	if feed = poll("1h"); len(feed.Changes) != 2 || feed.Changes[1].Hostname != "laser.lan." {
+		t.Errorf("expected no rename without recording, got %+v", feed)
+	}
+
+	for _, query := range []string{"", "?since=nonsense", "?since=1h&until=now"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/changes"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /api/changes%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
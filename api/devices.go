@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// handleDevices lists the devices for review, e.g. ?status=unknown for the ones nobody decided on yet.
+func handleDevices(r *http.Request, store db.Store, w http.ResponseWriter) {
+	status := r.URL.Query().Get("status")
+	if err := db.CheckDeviceStatus(status); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devices, err := store.GetDevices(status)
+	if !checkResult(w, err, "devices") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(devices); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+// recentActivityDays is how far back GET /api/devices/{mac} lists presence sessions.
+const recentActivityDays = 7
+
+// deviceDetail is everything known about one device.
+type deviceDetail struct {
+	db.ArpEntry
+	// Status and Comment are the review state, see db.Device
+	Status  string `json:"status"`
+	Comment string `json:"comment,omitempty"`
+	// RecentSessions are the presence sessions of the last recentActivityDays, newest first
+	RecentSessions []db.Session `json:"recent_sessions"`
+}
+
+// handleDevice returns one device with all addresses it ever used, its hostname and recent activity.
+func handleDevice(r *http.Request, store db.Store, w http.ResponseWriter, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := store.GetEntry(mac)
+	if !checkResult(w, err, "device") {
+		return
+	}
+	if entry == nil {
+		http.Error(w, "device "+mac+" was never seen", http.StatusNotFound)
+		return
+	}
+	lookupEntry(entry, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+
+	detail := deviceDetail{ArpEntry: *entry, Status: db.DeviceUnknown}
+	device, err := store.GetDevice(entry.MAC)
+	if !checkResult(w, err, "device") {
+		return
+	}
+	if device != nil {
+		detail.Status, detail.Comment = device.Status, device.Comment
+	}
+	if detail.RecentSessions, err = store.GetSessions(entry.MAC, recentActivityDays); !checkResult(w, err, "sessions") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(detail); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+// ForgetSniffer drops the in-memory state the sniffer keeps about a MAC, so nothing of a forgotten
+// device is written back later. main connects it to the running sniffer.
+var ForgetSniffer = func(mac string) {}
+
+// handleForget deletes all data about a device, the optional reason parameter is kept in the audit log.
+func handleForget(r *http.Request, store db.Store, w http.ResponseWriter) {
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if _, _, err := db.NormalizeForget(mac, reason); err != nil {
+		http.Error(w, "invalid reason: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ForgetSniffer(mac)
+	entry, err := store.ForgetDevice(mac, "api", reason)
+	if err != nil {
+		http.Error(w, "error on deleting device", http.StatusInternalServerError)
+		return
+	}
+	if entry == nil {
+		http.Error(w, "nothing stored about "+mac, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(entry); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+// handleDecision approves or rejects the device, with an optional JSON body {"comment": "..."}.
+func handleDecision(r *http.Request, store db.Store, w http.ResponseWriter, status string) {
+	mac, ok := pathMAC(w, r)
+	if !ok {
+		return
+	}
+	var decision struct {
+		Comment string `json:"comment"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&decision); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid decision: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, _, err := db.NormalizeDecision(mac, status, decision.Comment); err != nil {
+		http.Error(w, "invalid decision: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	device, err := store.SetDeviceStatus(mac, status, decision.Comment)
+	if err != nil {
+		http.Error(w, "error on storing decision", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(device); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+func getDevices(t *testing.T, mux *http.ServeMux, query string) []db.Device {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/devices"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/devices%s: status %d: %s", query, rec.Code, rec.Body.String())
+	}
+	var devices []db.Device
+	if err := json.NewDecoder(rec.Body).Decode(&devices); err != nil {
+		t.Fatalf("decode devices: %v", err)
+	}
+	return devices
+}
+
+func TestAPI_DeviceReview(t *testing.T) {
+	orig := AdminToken
+	defer func() { AdminToken = orig }()
+	AdminToken = "secret"
+
+	store := db.NewMemoryStore()
+	for _, mac := range []string{"00:11:22:33:44:55", "66:77:88:99:aa:bb"} {
+		if err := store.InsertARPEvent("192.168.1.10", mac); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	if devices := getDevices(t, mux, "?status=unknown"); len(devices) != 2 {
+		t.Fatalf("expected 2 unknown devices, got %+v", devices)
+	}
+
+	decide := func(mac, action, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/devices/"+mac+"/"+action, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := decide("00:11:22:33:44:55", "approve", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("approve without token: status %d, want 401", rec.Code)
+	}
+	if rec := decide("nonsense", "approve", "", "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("approve invalid MAC: status %d, want 400", rec.Code)
+	}
+	if rec := decide("00:11:22:33:44:55", "approve", `{"reason": "x"}`, "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("approve with unknown field: status %d, want 400", rec.Code)
+	}
+
+	rec := decide("00-11-22-33-44-55", "approve", `{"comment": "alice's laptop"}`, "secret")
+	var device db.Device
+	if err := json.NewDecoder(rec.Body).Decode(&device); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("approve: status %d, %v", rec.Code, err)
+	}
+	if device.MAC != "00:11:22:33:44:55" || device.Status != db.DeviceApproved || device.Comment != "alice's laptop" {
+		t.Errorf("unexpected approved device: %+v", device)
+	}
+	if rec := decide("66:77:88:99:aa:bb", "reject", "", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("reject without body: status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if devices := getDevices(t, mux, "?status=unknown"); len(devices) != 0 {
+		t.Errorf("expected no unknown devices left, got %+v", devices)
+	}
+	if devices := getDevices(t, mux, "?status=rejected"); len(devices) != 1 || devices[0].MAC != "66:77:88:99:aa:bb" {
+		t.Errorf("expected the rejected device, got %+v", devices)
+	}
+	if devices := getDevices(t, mux, ""); len(devices) != 2 {
+		t.Errorf("expected all devices without filter, got %+v", devices)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/devices?status=maybe", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid status: status %d, want 400", rec.Code)
+	}
+}
+
+func TestAPI_ForgetDevice(t *testing.T) {
+	orig := AdminToken
+	defer func() { AdminToken = orig }()
+	AdminToken = "secret"
+
+	store := db.NewMemoryStore()
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := serve(http.MethodDelete, "/api/devices/00:11:22:33:44:55", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("DELETE without token: status %d, want 401", rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/api/devices/nonsense", "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("DELETE invalid MAC: status %d, want 400", rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/api/devices/00:11:22:33:44:55?reason=a%00b", "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("DELETE invalid reason: status %d, want 400", rec.Code)
+	}
+
+	rec := serve(http.MethodDelete, "/api/devices/00-11-22-33-44-55?reason=ticket+42", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DELETE: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var entry db.AuditEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entry); err != nil {
+		t.Fatalf("decode audit entry: %v", err)
+	}
+	if entry.Source != "api" || entry.Reason != "ticket 42" || entry.Deleted["arp_events"] != 1 {
+		t.Errorf("unexpected audit entry: %+v", entry)
+	}
+	if devices := getDevices(t, mux, ""); len(devices) != 0 {
+		t.Errorf("expected the device to be gone, got %+v", devices)
+	}
+	if rec := serve(http.MethodDelete, "/api/devices/00:11:22:33:44:55", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE: status %d, want 404", rec.Code)
+	}
+
+	if rec := serve(http.MethodGet, "/api/admin/audit", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("audit log without token: status %d, want 401", rec.Code)
+	}
+	rec = serve(http.MethodGet, "/api/admin/audit", "secret")
+	var log []db.AuditEntry
+	if err := json.NewDecoder(rec.Body).Decode(&log); err != nil {
+		t.Fatalf("decode audit log: %v", err)
+	}
+	if len(log) != 1 || log[0].ID != entry.ID || strings.Contains(rec.Body.String(), "44:55") {
+		t.Errorf("unexpected audit log: %s", rec.Body.String())
+	}
+}
+
+func TestAPI_DeviceDetail(t *testing.T) {
+	origLookup := lookupEntry
+	defer func() { lookupEntry = origLookup }()
+	lookupEntry = lookupEntryFunc
+	netLookupAddr = func(addr string) ([]string, error) { return []string{"laptop.lan."}, nil }
+	defer func() { netLookupAddr = func(addr string) ([]string, error) { return mockLookupAddr(addr) } }()
+
+	store := db.NewMemoryStore()
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:aa:bb:cc"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	if _, err := store.SetDeviceStatus("00:11:22:aa:bb:cc", db.DeviceApproved, "alice"); err != nil {
+		t.Fatalf("SetDeviceStatus failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	get := func(mac string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/devices/"+mac, nil))
+		return rec
+	}
+	for _, mac := range []string{"00:11:22:aa:bb:cc", "00-11-22-AA-BB-CC", "0011.22aa.bbcc", "001122AABBCC"} {
+		rec := get(mac)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", mac, rec.Code, rec.Body.String())
+		}
+		var detail deviceDetail
+		if err := json.NewDecoder(rec.Body).Decode(&detail); err != nil {
+			t.Fatalf("decode device: %v", err)
+		}
+		if detail.MAC != "00:11:22:aa:bb:cc" || len(detail.Addresses) != 1 || detail.Hostname != "laptop.lan." ||
+			detail.HostnameSource != hostnameDNS || detail.Status != db.DeviceApproved || detail.Comment != "alice" ||
+			len(detail.RecentSessions) != 1 {
+			t.Errorf("GET %s: unexpected device %+v", mac, detail)
+		}
+	}
+
+	if rec := get("66:77:88:99:aa:bb"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET unknown MAC: status %d, want 404", rec.Code)
+	}
+	if rec := get("nonsense"); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET invalid MAC: status %d, want 400", rec.Code)
+	}
+}
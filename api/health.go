@@ -0,0 +1,40 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// WarningHeader is set on responses built from an incomplete result, e.g. when some rows could
+// not be read from the database. The body has the usual format.
+const WarningHeader = "X-Arpmonitor-Warning"
+
+// checkResult reports whether a result read from the store can be served. Incomplete results are
+// served with WarningHeader, other errors end the request with a 500.
+func checkResult(w http.ResponseWriter, err error, what string) bool {
+	if err == nil {
+		return true
+	}
+	if db.IsPartial(err) {
+		w.Header().Set(WarningHeader, "incomplete "+what+": "+err.Error())
+		return true
+	}
+	http.Error(w, "error on reading "+what, http.StatusInternalServerError)
+	return false
+}
+
+// handleHealth reports the health of store, with a 503 while it is degraded so plain HTTP checks notice.
+func handleHealth(store db.Store, w http.ResponseWriter) {
+	health := store.Health()
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status != db.HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// partialStore returns its entries together with a partial result error.
+type partialStore struct {
+	*db.MemoryStore
+}
+
+func (s partialStore) GetEntries(since, until time.Time) ([]db.ArpEntry, error) {
+	entries, _ := s.MemoryStore.GetEntries(since, until)
+	return entries, &db.PartialError{Skipped: 1, Err: errors.New("bad row")}
+}
+
+func TestAPI_PartialResultWarning(t *testing.T) {
+	lookupEntry = func(entry *db.ArpEntry, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {}
+	defer func() { lookupEntry = lookupEntryFunc }()
+
+	store := partialStore{newTestStore()}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	for _, path := range []string{"/api/current", "/api/ethers"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, want 200", path, rec.Code)
+		}
+		if warning := rec.Header().Get(WarningHeader); !strings.Contains(warning, "skipped 1 unreadable rows") {
+			t.Errorf("%s: expected a warning about the skipped row, got %q", path, warning)
+		}
+		if !strings.Contains(rec.Body.String(), testEntries[0].MAC) {
+			t.Errorf("%s: expected the readable entries, got %s", path, rec.Body.String())
+		}
+	}
+
+	// complete results carry no warning
+	mux = http.NewServeMux()
+	RegisterHandlers(mux, newTestStore(), false, "", false, false)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/current", nil))
+	if warning := rec.Header().Get(WarningHeader); warning != "" {
+		t.Errorf("unexpected warning %q", warning)
+	}
+}
+
+func getHealth(t *testing.T, mux *http.ServeMux) (int, db.Health) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+	var health db.Health
+	if err := json.NewDecoder(rec.Body).Decode(&health); err != nil {
+		t.Fatalf("decode health: %v", err)
+	}
+	return rec.Code, health
+}
+
+func TestAPI_HealthEndpoint(t *testing.T) {
+	store, err := db.NewSQLiteStore(filepath.Join(t.TempDir(), "health.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	if code, health := getHealth(t, mux); code != http.StatusOK || health.Status != db.HealthOK || health.Inserts != 1 {
+		t.Fatalf("expected ok with one insert, got %d %+v", code, health)
+	}
+
+	if _, err := store.DB.Exec(`CREATE TRIGGER fail BEFORE INSERT ON arp_events BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err == nil {
+		t.Fatal("expected InsertARPEvent to fail")
+	}
+	code, health := getHealth(t, mux)
+	if code != http.StatusServiceUnavailable || health.Status != db.HealthDegraded || health.LastInsertError != "disk full" {
+		t.Errorf("expected degraded, got %d %+v", code, health)
+	}
+
+	if _, err := store.DB.Exec(`DROP TRIGGER fail`); err != nil {
+		t.Fatalf("drop trigger: %v", err)
+	}
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	if code, health := getHealth(t, mux); code != http.StatusOK || health.InsertFailures == 0 {
+		t.Errorf("expected ok with the past failure counted, got %d %+v", code, health)
+	}
+}
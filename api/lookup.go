@@ -0,0 +1,350 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// lookupMatch is a device found by /api/lookup.
+type lookupMatch struct {
+	MAC string `json:"mac"`
+	// IP is the looked up address, or the preferred address of a device found by hostname
+	IP             string `json:"ip,omitempty"`
+	Hostname       string `json:"hostname,omitempty"`
+	HostnameSource string `json:"hostname_source,omitempty"`
+	// Current marks the match seen last, the others used the address or name before
+	Current   bool      `json:"current"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// handleLookup answers which MACs used an address (?ip=) or carry a hostname (?hostname=),
+// the current one first.
+func handleLookup(r *http.Request, store db.Store, w http.ResponseWriter, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
+	q := r.URL.Query()
+	ip, hostname := q.Get("ip"), q.Get("hostname")
+	if (ip == "") == (hostname == "") {
+		http.Error(w, "use either ip or hostname", http.StatusBadRequest)
+		return
+	}
+	resolve := func(entry *db.ArpEntry) {
+		lookupEntry(entry, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+	}
+
+	var matches []lookupMatch
+	if ip != "" {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			http.Error(w, "invalid IP address", http.StatusBadRequest)
+			return
+		}
+		matches, err = lookupIP(store, addr.Unmap(), resolve)
+		if !checkResult(w, err, "lookup") {
+			return
+		}
+	} else {
+		if normalizeHostname(hostname) == "" {
+			http.Error(w, "invalid hostname", http.StatusBadRequest)
+			return
+		}
+		var err error
+		matches, err = lookupHostname(store, hostname, resolve, preferIpv4Net)
+		if !checkResult(w, err, "lookup") {
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(matches); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+// partialErrors keeps the first partial error of the store calls of one lookup.
+type partialErrors struct {
+	first error
+}
+
+// keep reports whether the result of a store call is usable, which partial results are.
+func (p *partialErrors) keep(err error) bool {
+	if db.IsPartial(err) && p.first == nil {
+		p.first = err
+	}
+	return err == nil || db.IsPartial(err)
+}
+
+// lookupIP returns every MAC that ever used addr with its hostname. Only the history of addr
+// is read and only its MACs are resolved. Incomplete results from the store are returned with
+// their partial error.
+func lookupIP(store db.Store, addr netip.Addr, resolve func(*db.ArpEntry)) ([]lookupMatch, error) {
+	var partial partialErrors
+	bindings, err := store.GetIPHistory(netip.PrefixFrom(addr, addr.BitLen()))
+	if !partial.keep(err) {
+		return nil, err
+	}
+	byMAC := make(map[string]*lookupMatch)
+	var macs []string
+	for _, b := range bindings {
+		m, exists := byMAC[b.MAC]
+		if !exists {
+			m = &lookupMatch{MAC: b.MAC, IP: b.IP, FirstSeen: b.FirstSeen}
+			byMAC[b.MAC] = m
+			macs = append(macs, b.MAC)
+		}
+		if b.FirstSeen.Before(m.FirstSeen) {
+			m.FirstSeen = b.FirstSeen
+		}
+		if b.LastSeen.After(m.LastSeen) {
+			m.LastSeen = b.LastSeen
+		}
+	}
+
+	entries, err := getEntries(store, macs, &partial)
+	if err != nil {
+		return nil, err
+	}
+	resolveConcurrently(entries, resolve)
+
+	matches := []lookupMatch{}
+	for _, entry := range entries {
+		if m, exists := byMAC[entry.MAC]; exists {
+			m.Hostname, m.HostnameSource = entry.Hostname, entry.HostnameSource
+		}
+	}
+	for _, mac := range macs {
+		matches = append(matches, *byMAC[mac])
+	}
+	return sortMatches(matches), partial.first
+}
+
+// lookupHostname returns the devices whose resolved hostname is name, ignoring case and a
+// trailing dot. Only candidates are resolved: devices annotated with or recorded under the name,
+// and those that used an address the name resolves to.
+func lookupHostname(store db.Store, name string, resolve func(*db.ArpEntry), preferIpv4Net string) ([]lookupMatch, error) {
+	var partial partialErrors
+	macs, err := store.FindHostname(name)
+	if !partial.keep(err) {
+		return nil, err
+	}
+	candidates := make(map[string]bool)
+	for _, mac := range macs {
+		candidates[mac] = true
+	}
+	// unknown names are no error, the recorded hostnames may still match
+	addrs, _ := netLookupHost(name)
+	for _, a := range addrs {
+		addr, err := netip.ParseAddr(a)
+		if err != nil {
+			continue
+		}
+		addr = addr.Unmap()
+		bindings, err := store.GetIPHistory(netip.PrefixFrom(addr, addr.BitLen()))
+		if !partial.keep(err) {
+			return nil, err
+		}
+		for _, b := range bindings {
+			if !candidates[b.MAC] {
+				candidates[b.MAC] = true
+				macs = append(macs, b.MAC)
+			}
+		}
+	}
+
+	entries, err := getEntries(store, macs, &partial)
+	if err != nil {
+		return nil, err
+	}
+	resolveConcurrently(entries, resolve)
+
+	name = normalizeHostname(name)
+	matches := []lookupMatch{}
+	for _, entry := range entries {
+		if entry.Hostname == "" || normalizeHostname(entry.Hostname) != name {
+			continue
+		}
+		ip := firstMatchOrEmpty(entry.IPv4, preferIpv4Net)
+		if ip == "" {
+			ip = firstMatchOrEmpty(entry.IPv6, "")
+		}
+		matches = append(matches, lookupMatch{MAC: entry.MAC, IP: ip, Hostname: entry.Hostname,
+			HostnameSource: entry.HostnameSource, FirstSeen: entry.FirstSeen, LastSeen: entry.LastSeen})
+	}
+	return sortMatches(matches), partial.first
+}
+
+// getEntries reads the entries of macs, skipping MACs that are no longer stored.
+func getEntries(store db.Store, macs []string, partial *partialErrors) ([]*db.ArpEntry, error) {
+	entries := make([]*db.ArpEntry, 0, len(macs))
+	for _, mac := range macs {
+		entry, err := store.GetEntry(mac)
+		if !partial.keep(err) {
+			return nil, err
+		}
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// lookupWorkers bounds the concurrent hostname lookups of one request.
+const lookupWorkers = 16
+
+// resolveConcurrently resolves the hostnames of entries, lookupWorkers at a time.
+func resolveConcurrently(entries []*db.ArpEntry, resolve func(*db.ArpEntry)) {
+	jobs := make(chan *db.ArpEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < min(lookupWorkers, len(entries)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range jobs {
+				resolve(entry)
+			}
+		}()
+	}
+	for _, entry := range entries {
+		jobs <- entry
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+func normalizeHostname(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// sortMatches orders matches by their last sighting, newest first, and marks the newest as current.
+func sortMatches(matches []lookupMatch) []lookupMatch {
+	sort.Slice(matches, func(i, j int) bool {
+		if !matches[i].LastSeen.Equal(matches[j].LastSeen) {
+			return matches[i].LastSeen.After(matches[j].LastSeen)
+		}
+		return matches[i].MAC < matches[j].MAC
+	})
+	if len(matches) > 0 {
+		matches[0].Current = true
+	}
+	return matches
+}
+
+// Limits of a bulk lookup.
+const (
+	maxBulkLookup     = 10000
+	maxBulkLookupBody = 1 << 20
+)
+
+// bulkResult answers one address of a bulk lookup.
+type bulkResult struct {
+	Query   string        `json:"query"`
+	Matches []lookupMatch `json:"matches"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// handleBulkLookup looks up a JSON array of MACs and IPs in one go and answers with one result
+// per element, in the same order. Unparsable elements get an error, the others are still answered.
+func handleBulkLookup(r *http.Request, store db.Store, w http.ResponseWriter, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
+	var queries []string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBulkLookupBody)).Decode(&queries); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid request, expected a JSON array of MACs and IPs: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(queries) > maxBulkLookup {
+		http.Error(w, fmt.Sprintf("more than %d addresses", maxBulkLookup), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// every device with every address it ever used, one query instead of one per address
+	entries, err := store.GetEntries(time.Time{}, time.Time{})
+	if !checkResult(w, err, "entries") {
+		return
+	}
+	byMAC := make(map[string]*db.ArpEntry)
+	byIP := make(map[netip.Addr][]ipUse)
+	for i := range entries {
+		entry := &entries[i]
+		if mac, err := db.ParseMAC(entry.MAC); err == nil {
+			byMAC[mac] = entry
+		}
+		for _, addr := range entry.Addresses {
+			if ip, err := netip.ParseAddr(addr.IP); err == nil {
+				byIP[ip.Unmap()] = append(byIP[ip.Unmap()], ipUse{entry, addr})
+			}
+		}
+	}
+
+	results := make([]bulkResult, len(queries))
+	uses := make([][]ipUse, len(queries))
+	needed := make(map[*db.ArpEntry]bool)
+	for i, query := range queries {
+		results[i] = bulkResult{Query: query, Matches: []lookupMatch{}}
+		if ip, err := netip.ParseAddr(strings.TrimSpace(query)); err == nil {
+			uses[i] = byIP[ip.Unmap()]
+		} else if mac, err := db.ParseMAC(query); err == nil {
+			if entry, exists := byMAC[mac]; exists {
+				uses[i] = []ipUse{{entry: entry}}
+			}
+		} else {
+			results[i].Error = "not an IP or MAC address"
+		}
+		for _, use := range uses[i] {
+			needed[use.entry] = true
+		}
+	}
+
+	resolve := make([]*db.ArpEntry, 0, len(needed))
+	for entry := range needed {
+		resolve = append(resolve, entry)
+	}
+	resolveConcurrently(resolve, func(entry *db.ArpEntry) {
+		lookupEntry(entry, resolveIpv6, preferIpv4Net, resolveKeaLeases)
+	})
+
+	for i := range results {
+		for _, use := range uses[i] {
+			results[i].Matches = append(results[i].Matches, use.match(preferIpv4Net))
+		}
+		results[i].Matches = sortMatches(results[i].Matches)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		http.Error(w, "internal server error, failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+// ipUse is a device found by a bulk lookup, with the address it was found by. A device found by
+// its MAC has no address.
+type ipUse struct {
+	entry *db.ArpEntry
+	addr  db.AddressInfo
+}
+
+func (u ipUse) match(preferIpv4Net string) lookupMatch {
+	m := lookupMatch{MAC: u.entry.MAC, IP: u.addr.IP, Hostname: u.entry.Hostname, HostnameSource: u.entry.HostnameSource,
+		FirstSeen: u.addr.FirstSeen, LastSeen: u.addr.LastSeen}
+	if m.IP == "" {
+		m.IP = firstMatchOrEmpty(u.entry.IPv4, preferIpv4Net)
+		if m.IP == "" {
+			m.IP = firstMatchOrEmpty(u.entry.IPv6, "")
+		}
+		m.FirstSeen, m.LastSeen = u.entry.FirstSeen, u.entry.LastSeen
+	}
+	return m
+}
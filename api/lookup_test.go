@@ -0,0 +1,270 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+func TestAPI_LookupEndpoint(t *testing.T) {
+	origLookup := lookupEntry
+	defer func() { lookupEntry = origLookup }()
+	lookupEntry = lookupEntryFunc
+	netLookupAddr = func(addr string) ([]string, error) {
+		if addr == "192.168.1.20" {
+			return []string{"NAS.lan."}, nil
+		}
+		return nil, nil
+	}
+	defer func() { netLookupAddr = func(addr string) ([]string, error) { return mockLookupAddr(addr) } }()
+	origLookupHost := netLookupHost
+	defer func() { netLookupHost = origLookupHost }()
+	netLookupHost = func(host string) ([]string, error) {
+		if host == "nas.lan" {
+			return []string{"192.168.1.20"}, nil
+		}
+		return origLookupHost(host)
+	}
+
+	store := db.NewMemoryStore()
+	// 192.168.1.10 moved from the old PC to the laptop
+	for _, s := range []struct{ ip, mac string }{
+		{"192.168.1.10", "00:11:22:33:44:01"},
+		{"192.168.1.10", "00:11:22:33:44:02"},
+		{"192.168.1.20", "00:11:22:33:44:03"},
+	} {
+		if err := store.InsertARPEvent(s.ip, s.mac); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+	if _, err := store.SetAnnotation(db.Annotation{MAC: "00:11:22:33:44:01", Name: "old-pc"}); err != nil {
+		t.Fatalf("SetAnnotation failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	lookup := func(query string) []lookupMatch {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/lookup?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/lookup?%s: status %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var matches []lookupMatch
+		if err := json.NewDecoder(rec.Body).Decode(&matches); err != nil {
+			t.Fatalf("decode matches: %v", err)
+		}
+		return matches
+	}
+
+	matches := lookup("ip=192.168.1.10")
+	if len(matches) != 2 || matches[0].MAC != "00:11:22:33:44:02" || !matches[0].Current ||
+		matches[1].MAC != "00:11:22:33:44:01" || matches[1].Current || matches[1].Hostname != "old-pc" ||
+		matches[1].HostnameSource != hostnameAnnotation || matches[1].IP != "192.168.1.10" {
+		t.Errorf("unexpected matches for ip: %+v", matches)
+	}
+	if matches := lookup("ip=::ffff:192.168.1.20"); len(matches) != 1 || matches[0].Hostname != "NAS.lan." {
+		t.Errorf("unexpected matches for a mapped ip: %+v", matches)
+	}
+	if matches := lookup("ip=10.0.0.1"); len(matches) != 0 {
+		t.Errorf("expected no matches, got %+v", matches)
+	}
+
+	matches = lookup("hostname=nas.lan")
+	if len(matches) != 1 || matches[0].MAC != "00:11:22:33:44:03" || matches[0].IP != "192.168.1.20" ||
+		matches[0].HostnameSource != hostnameDNS || !matches[0].Current {
+		t.Errorf("unexpected matches for hostname: %+v", matches)
+	}
+	if matches := lookup("hostname=OLD-PC."); len(matches) != 1 || matches[0].MAC != "00:11:22:33:44:01" {
+		t.Errorf("unexpected matches for an annotated name: %+v", matches)
+	}
+
+	for _, query := range []string{"", "ip=192.168.1.10&hostname=nas.lan", "ip=nonsense", "hostname=."} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/lookup?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /api/lookup?%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestAPI_Lookup_SearchesAllHistory(t *testing.T) {
+	origLookup := lookupEntry
+	defer func() { lookupEntry = origLookup }()
+	lookupEntry = lookupEntryFunc
+	netLookupAddr = func(addr string) ([]string, error) {
+		if addr == "192.168.1.30" {
+			return []string{"printer.lan."}, nil
+		}
+		return nil, nil
+	}
+	defer func() { netLookupAddr = func(addr string) ([]string, error) { return mockLookupAddr(addr) } }()
+
+	store, err := db.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	// last seen long before the default window of /api/current
+	seen := time.Now().Add(-90 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	dump := `{"type":"event","mac":"00:11:22:33:44:30","ip":"192.168.1.30","family":"ipv4","first_seen":"` + seen + `","last_seen":"` + seen + `"}`
+	if _, err := db.Import(store.DB, strings.NewReader(dump), db.FormatJSONL); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	// recorded by the hostname recorder back then
+	if err := store.RecordHostname("00:11:22:33:44:30", "printer.lan.", hostnameDNS); err != nil {
+		t.Fatalf("RecordHostname failed: %v", err)
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	for _, query := range []string{"ip=192.168.1.30", "hostname=printer.lan"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/lookup?"+query, nil))
+		var matches []lookupMatch
+		if err := json.NewDecoder(rec.Body).Decode(&matches); err != nil {
+			t.Fatalf("decode matches of %s: %v", query, err)
+		}
+		if len(matches) != 1 || matches[0].MAC != "00:11:22:33:44:30" || matches[0].IP != "192.168.1.30" ||
+			matches[0].Hostname != "printer.lan." {
+			t.Errorf("%s: unexpected matches %+v", query, matches)
+		}
+	}
+}
+
+func TestAPI_Lookup_ResolvesOnlyMatches(t *testing.T) {
+	var mu sync.Mutex
+	var resolved []string
+	origLookup := lookupEntry
+	defer func() { lookupEntry = origLookup }()
+	lookupEntry = func(entry *db.ArpEntry, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
+		mu.Lock()
+		resolved = append(resolved, entry.MAC)
+		mu.Unlock()
+		if entry.MAC == "00:11:22:33:44:07" {
+			entry.Hostname, entry.HostnameSource = "cam.lan.", hostnameDNS
+		}
+	}
+	origLookupHost := netLookupHost
+	defer func() { netLookupHost = origLookupHost }()
+	netLookupHost = func(host string) ([]string, error) {
+		if host == "cam.lan" {
+			return []string{"192.168.1.7"}, nil
+		}
+		return origLookupHost(host)
+	}
+
+	store := db.NewMemoryStore()
+	for i := 0; i < 100; i++ {
+		if err := store.InsertARPEvent(fmt.Sprintf("192.168.1.%d", i), fmt.Sprintf("00:11:22:33:44:%02x", i)); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	for _, query := range []string{"ip=192.168.1.7", "hostname=cam.lan"} {
+		resolved = nil
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/lookup?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/lookup?%s: status %d", query, rec.Code)
+		}
+		if len(resolved) != 1 || resolved[0] != "00:11:22:33:44:07" {
+			t.Errorf("%s resolved %v, want only the matching device", query, resolved)
+		}
+	}
+}
+
+func TestAPI_BulkLookup(t *testing.T) {
+	names := map[string]string{"192.168.1.10": "laptop.lan.", "192.168.1.20": "nas.lan."}
+	netLookupAddr = func(addr string) ([]string, error) { return []string{names[addr]}, nil }
+	defer func() { netLookupAddr = func(addr string) ([]string, error) { return mockLookupAddr(addr) } }()
+
+	store := db.NewMemoryStore()
+	for _, s := range []struct{ ip, mac string }{
+		{"192.168.1.10", "00:11:22:33:44:01"},
+		{"192.168.1.10", "00:11:22:33:44:02"},
+		{"192.168.1.20", "00:11:22:33:44:03"},
+	} {
+		if err := store.InsertARPEvent(s.ip, s.mac); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/lookup", strings.NewReader(body)))
+		return rec
+	}
+	rec := post(`["192.168.1.10", "001122334403", "nonsense", "10.0.0.1"]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /api/lookup: status %d: %s", rec.Code, rec.Body.String())
+	}
+	var results []bulkResult
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatalf("decode results: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected one result per query, got %+v", results)
+	}
+	if r := results[0]; r.Query != "192.168.1.10" || len(r.Matches) != 2 || r.Matches[0].MAC != "00:11:22:33:44:02" ||
+		!r.Matches[0].Current || r.Matches[1].Current || r.Matches[0].Hostname != "laptop.lan." {
+		t.Errorf("unexpected result for an ip: %+v", r)
+	}
+	if r := results[1]; len(r.Matches) != 1 || r.Matches[0].MAC != "00:11:22:33:44:03" || r.Matches[0].IP != "192.168.1.20" ||
+		r.Matches[0].Hostname != "nas.lan." {
+		t.Errorf("unexpected result for a MAC: %+v", r)
+	}
+	if r := results[2]; r.Error == "" || len(r.Matches) != 0 {
+		t.Errorf("expected an error for an invalid query: %+v", r)
+	}
+	if r := results[3]; r.Error != "" || len(r.Matches) != 0 {
+		t.Errorf("expected no matches for an unknown ip: %+v", r)
+	}
+
+	if rec := post(`{"ips": []}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST an object: status %d, want 400", rec.Code)
+	}
+	tooMany := `["` + strings.Repeat(`1.2.3.4", "`, maxBulkLookup) + `1.2.3.4"]`
+	if rec := post(tooMany); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST too many addresses: status %d, want 413", rec.Code)
+	}
+}
+
+func TestResolveConcurrently(t *testing.T) {
+	entries := make([]*db.ArpEntry, 100)
+	for i := range entries {
+		entries[i] = &db.ArpEntry{}
+	}
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	resolveConcurrently(entries, func(entry *db.ArpEntry) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		entry.Hostname = "resolved"
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	for i, entry := range entries {
+		if entry.Hostname != "resolved" {
+			t.Fatalf("entry %d was not resolved", i)
+		}
+	}
+	if maxRunning > lookupWorkers || maxRunning < 2 {
+		t.Errorf("expected up to %d concurrent lookups, got %d", lookupWorkers, maxRunning)
+	}
+}
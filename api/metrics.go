@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+	"github.com/vgropp/arpmonitor/internal/metrics"
+)
+
+var (
+	hostnameLookups = metrics.NewCounter("arpmonitor_hostname_lookups_total",
+		"Hostname resolutions of the API, by the source that answered: annotation, dns, kea-lease or none.", "source")
+	httpRequests = metrics.NewCounter("arpmonitor_http_requests_total",
+		"HTTP requests answered, by handler pattern, method and status code.", "handler", "method", "code")
+	httpDuration = metrics.NewHistogram("arpmonitor_http_request_duration_seconds",
+		"Time to answer HTTP requests, by handler pattern.", metrics.DefaultLatencyBuckets, "handler")
+)
+
+// deviceWindows are the windows of arpmonitor_devices, a device counts once it was seen within one.
+var deviceWindows = []struct {
+	label string
+	d     time.Duration
+}{{"5m", 5 * time.Minute}, {"1h", time.Hour}, {"24h", 24 * time.Hour}}
+
+// handleMetrics writes all metrics in the Prometheus text exposition format, followed by the
+// device counts read from the store.
+func handleMetrics(store db.Store, w http.ResponseWriter) {
+	samples, err := countDevices(store, time.Now())
+	if !checkResult(w, err, "device counts") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if err := metrics.WriteAll(w); err != nil {
+		return
+	}
+	devices := &metrics.Func{
+		Name:    "arpmonitor_devices",
+		Help:    "Devices seen within the window, by capture interface and IP family.",
+		Type:    "gauge",
+		Labels:  []string{"interface", "family", "window"},
+		Collect: func() []metrics.Sample { return samples },
+	}
+	_ = devices.Write(w)
+}
+
+// countDevices counts the devices with an address of each family seen on each interface within
+// each window. The capture interface always has all series, other interfaces once devices were
+// recorded on them.
+func countDevices(store db.Store, now time.Time) ([]metrics.Sample, error) {
+	type series struct{ iface, family string }
+	byWindow := make([]map[series]int, len(deviceWindows))
+	ifaces := map[string]bool{db.CaptureInterface: true}
+	for i, window := range deviceWindows {
+		counts, err := store.CountDevices(now.Add(-window.d))
+		if err != nil {
+			return nil, err
+		}
+		byWindow[i] = make(map[series]int)
+		for _, c := range counts {
+			byWindow[i][series{c.Interface, c.Family}] = c.Devices
+			ifaces[c.Interface] = true
+		}
+	}
+	sorted := make([]string, 0, len(ifaces))
+	for iface := range ifaces {
+		sorted = append(sorted, iface)
+	}
+	sort.Strings(sorted)
+
+	var samples []metrics.Sample
+	for _, iface := range sorted {
+		for _, family := range []string{"ipv4", "ipv6"} {
+			for i, window := range deviceWindows {
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{iface, family, window.label},
+					Value:       float64(byWindow[i][series{iface, family}]),
+				})
+			}
+		}
+	}
+	return samples, nil
+}
+
+// observeHostname counts a resolution of entry by the source that answered it.
+func observeHostname(entry *db.ArpEntry) {
+	source := entry.HostnameSource
+	if source == "" {
+		source = "none"
+	}
+	hostnameLookups.Inc(source)
+}
+
+// instrument counts the requests answered by h and their duration, labelled with the path of
+// pattern so that the label values stay bounded.
+func instrument(pattern string, h http.HandlerFunc) http.HandlerFunc {
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = pattern[i+1:]
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		httpDuration.Observe(time.Since(start).Seconds(), pattern)
+		httpRequests.Inc(pattern, r.Method, strconv.Itoa(rec.status))
+	}
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
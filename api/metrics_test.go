@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+func TestAPI_MetricsEndpoint(t *testing.T) {
+	origIface := db.CaptureInterface
+	defer func() { db.CaptureInterface = origIface }()
+	db.CaptureInterface = "br0"
+
+	store := db.NewMemoryStore()
+	for _, s := range []struct{ ip, mac string }{
+		{"192.168.1.10", "00:11:22:33:44:01"},
+		{"fe80::1", "00:11:22:33:44:01"},
+		{"192.168.1.20", "00:11:22:33:44:02"},
+	} {
+		if err := store.InsertARPEvent(s.ip, s.mac); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/devices/nonsense", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("GET /api/devices/nonsense: status %d, want 400", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`arpmonitor_devices{interface="br0",family="ipv4",window="5m"} 2`,
+		`arpmonitor_devices{interface="br0",family="ipv6",window="24h"} 1`,
+		`arpmonitor_http_requests_total{handler="/api/devices/{mac}",method="GET",code="400"} `,
+		`arpmonitor_http_request_duration_seconds_count{handler="/api/devices/{mac}"} `,
+		"# TYPE arpmonitor_hostname_lookups_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestCountDevices(t *testing.T) {
+	origIface := db.CaptureInterface
+	defer func() { db.CaptureInterface = origIface }()
+	db.CaptureInterface = "eth0"
+
+	now := time.Now()
+	store := &countingStore{MemoryStore: db.NewMemoryStore(), counts: [][]db.DeviceCount{
+		{{Interface: "eth1", Family: "ipv4", Devices: 1}},
+		{{Interface: "eth1", Family: "ipv4", Devices: 2}},
+		{{Interface: "eth1", Family: "ipv4", Devices: 2}, {Interface: "eth1", Family: "ipv6", Devices: 1}},
+	}}
+	samples, err := countDevices(store, now)
+	if err != nil {
+		t.Fatalf("countDevices failed: %v", err)
+	}
+	got := make(map[string]float64)
+	for _, s := range samples {
+		got[strings.Join(s.LabelValues, "/")] = s.Value
+	}
+	// the capture interface has its series before any device was seen on it
+	want := map[string]float64{
+		"eth0/ipv4/5m": 0, "eth0/ipv4/1h": 0, "eth0/ipv4/24h": 0, "eth0/ipv6/5m": 0, "eth0/ipv6/1h": 0, "eth0/ipv6/24h": 0,
+		"eth1/ipv4/5m": 1, "eth1/ipv4/1h": 2, "eth1/ipv4/24h": 2, "eth1/ipv6/5m": 0, "eth1/ipv6/1h": 0, "eth1/ipv6/24h": 1,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d series, want %d: %v", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if value, exists := got[k]; !exists || value != v {
+			t.Errorf("%s: got %v, want %v", k, got[k], v)
+		}
+	}
+	for i, window := range deviceWindows {
+		if !store.since[i].Equal(now.Add(-window.d)) {
+			t.Errorf("window %s counted since %v", window.label, store.since[i])
+		}
+	}
+}
+
+// countingStore answers CountDevices with counts, one per call, and records the windows asked for.
+type countingStore struct {
+	*db.MemoryStore
+	counts [][]db.DeviceCount
+	since  []time.Time
+}
+
+func (s *countingStore) CountDevices(since time.Time) ([]db.DeviceCount, error) {
+	s.since = append(s.since, since)
+	return s.counts[len(s.since)-1], nil
+}
@@ -0,0 +1,311 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// NextCursorHeader carries the cursor of the next page of a device list, it is missing on the
+// last page.
+const NextCursorHeader = "X-Arpmonitor-Next-Cursor"
+
+// Sort orders of the device lists, ties are broken by MAC.
+const (
+	sortMAC      = "mac"
+	sortLastSeen = "last_seen"
+	sortHostname = "hostname"
+	sortIP       = "ip"
+)
+
+// listQuery selects a page of /api/current or /api/ethers.
+type listQuery struct {
+	prefix   netip.Prefix // only devices with an address in prefix, if valid
+	family   string       // only devices with an address of this family
+	hostname string       // glob on the resolved hostname, lower case
+	oui      string       // first three bytes of the MAC as hex, lower case
+	iface    string       // only devices last seen on this capture interface
+	sort     string
+	desc     bool
+	limit    int // 0: no limit
+	cursor   *listCursor
+}
+
+// listCursor is the position after the last entry of a page. It holds the sort keys of that
+// entry, so a page starts at the right place even if devices came or went in between.
+type listCursor struct {
+	Sort     string    `json:"s"`
+	Desc     bool      `json:"d,omitempty"`
+	MAC      string    `json:"m"`
+	LastSeen time.Time `json:"t,omitempty"`
+	Hostname string    `json:"h,omitempty"`
+	IP       string    `json:"i,omitempty"`
+}
+
+// parseListQuery reads the filter, sort and page parameters of the device lists.
+func parseListQuery(r *http.Request) (listQuery, error) {
+	q := r.URL.Query()
+	lq := listQuery{
+		family:   q.Get("family"),
+		hostname: strings.ToLower(q.Get("hostname")),
+		iface:    q.Get("interface"),
+		sort:     q.Get("sort"),
+	}
+
+	if cidr := q.Get("cidr"); cidr != "" {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return lq, fmt.Errorf("invalid cidr %q", cidr)
+		}
+		lq.prefix = prefix.Masked()
+	}
+	switch lq.family {
+	case "", "ipv4", "ipv6":
+	default:
+		return lq, fmt.Errorf("invalid family %q, use ipv4 or ipv6", lq.family)
+	}
+	if lq.hostname != "" {
+		if _, err := path.Match(lq.hostname, ""); err != nil {
+			return lq, fmt.Errorf("invalid hostname pattern %q", lq.hostname)
+		}
+	}
+	if vendor := q.Get("vendor"); vendor != "" {
+		oui := strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.ToLower(vendor))
+		if _, err := hex.DecodeString(oui); err != nil || len(oui) != 6 {
+			return lq, fmt.Errorf("invalid vendor %q, use the OUI, e.g. 00:11:22", vendor)
+		}
+		lq.oui = oui
+	}
+
+	if strings.HasPrefix(lq.sort, "-") {
+		lq.sort, lq.desc = lq.sort[1:], true
+	}
+	switch lq.sort {
+	case "":
+		lq.sort = sortMAC
+	case sortMAC, sortLastSeen, sortHostname, sortIP:
+	default:
+		return lq, fmt.Errorf("invalid sort %q, use mac, last_seen, hostname or ip, with - for descending", lq.sort)
+	}
+	switch order := q.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		lq.desc = true
+	default:
+		return lq, fmt.Errorf("invalid order %q, use asc or desc", order)
+	}
+
+	if limitStr := q.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return lq, fmt.Errorf("invalid limit %q", limitStr)
+		}
+		lq.limit = limit
+	}
+	if cursorStr := q.Get("cursor"); cursorStr != "" {
+		cursor, err := decodeCursor(cursorStr)
+		if err != nil {
+			return lq, err
+		}
+		if cursor.Sort != lq.sort || cursor.Desc != lq.desc {
+			return lq, errors.New("the cursor belongs to another sort order")
+		}
+		lq.cursor = cursor
+	}
+	return lq, nil
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// match applies the filters that need no hostname.
+func (lq listQuery) match(entry *db.ArpEntry) bool {
+	if lq.iface != "" && entry.Interface != lq.iface {
+		return false
+	}
+	if lq.oui != "" {
+		mac, err := db.ParseMAC(entry.MAC)
+		if err != nil || !strings.HasPrefix(strings.ReplaceAll(mac, ":", ""), lq.oui) {
+			return false
+		}
+	}
+	if lq.family == "ipv4" && len(entry.IPv4) == 0 || lq.family == "ipv6" && len(entry.IPv6) == 0 {
+		return false
+	}
+	if lq.prefix.IsValid() {
+		return anyInPrefix(entry.IPv4, lq.prefix) || anyInPrefix(entry.IPv6, lq.prefix)
+	}
+	return true
+}
+
+func anyInPrefix(ips []string, prefix netip.Prefix) bool {
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply filters, sorts and pages entries and resolves the hostnames of the returned ones. next is
+// the cursor of the following page, "" on the last one.
+func (lq listQuery) apply(entries []db.ArpEntry, resolve func(*db.ArpEntry), preferIpv4Net string) (page []db.ArpEntry, next string) {
+	filtered := entries[:0]
+	for i := range entries {
+		if lq.match(&entries[i]) {
+			filtered = append(filtered, entries[i])
+		}
+	}
+	// resolving is slow, only a hostname sort needs every hostname before the page can be cut
+	if lq.sort == sortHostname {
+		resolveConcurrently(entryPointers(filtered), resolve)
+		matching := filtered[:0]
+		for i := range filtered {
+			if lq.matchHostname(&filtered[i]) {
+				matching = append(matching, filtered[i])
+			}
+		}
+		filtered = matching
+	}
+
+	keys := make([]listCursor, len(filtered))
+	for i := range filtered {
+		keys[i] = lq.key(&filtered[i], preferIpv4Net)
+	}
+	sort.Sort(byKey{filtered, keys, lq})
+
+	start := 0
+	if lq.cursor != nil {
+		start = sort.Search(len(keys), func(i int) bool { return lq.compare(keys[i], *lq.cursor) > 0 })
+	}
+	if lq.hostname != "" && lq.sort != sortHostname {
+		return lq.pageByHostname(filtered[start:], keys[start:], resolve)
+	}
+	end := len(filtered)
+	if lq.limit > 0 && start+lq.limit < end {
+		end = start + lq.limit
+		next = keys[end-1].encode()
+	}
+	page = filtered[start:end]
+	if lq.sort != sortHostname {
+		resolveConcurrently(entryPointers(page), resolve)
+	}
+	return page, next
+}
+
+// pageByHostname cuts a page of the sorted entries whose hostname matches. Entries are resolved
+// a batch at a time until the page is full and one more match shows there is a next page.
+func (lq listQuery) pageByHostname(entries []db.ArpEntry, keys []listCursor, resolve func(*db.ArpEntry)) (page []db.ArpEntry, next string) {
+	var last listCursor
+	for i := 0; i < len(entries); i += lookupWorkers {
+		batch := entries[i:min(i+lookupWorkers, len(entries))]
+		resolveConcurrently(entryPointers(batch), resolve)
+		for j := range batch {
+			if !lq.matchHostname(&batch[j]) {
+				continue
+			}
+			if lq.limit > 0 && len(page) == lq.limit {
+				return page, last.encode()
+			}
+			page, last = append(page, batch[j]), keys[i+j]
+		}
+	}
+	return page, ""
+}
+
+// matchHostname applies the hostname glob to a resolved entry.
+func (lq listQuery) matchHostname(entry *db.ArpEntry) bool {
+	if lq.hostname == "" {
+		return true
+	}
+	matched, _ := path.Match(lq.hostname, strings.ToLower(entry.Hostname))
+	return matched
+}
+
+func entryPointers(entries []db.ArpEntry) []*db.ArpEntry {
+	pointers := make([]*db.ArpEntry, len(entries))
+	for i := range entries {
+		pointers[i] = &entries[i]
+	}
+	return pointers
+}
+
+// key returns the sort keys of entry, as stored in a cursor.
+func (lq listQuery) key(entry *db.ArpEntry, preferIpv4Net string) listCursor {
+	key := listCursor{Sort: lq.sort, Desc: lq.desc, MAC: entry.MAC}
+	switch lq.sort {
+	case sortLastSeen:
+		key.LastSeen = entry.LastSeen
+	case sortHostname:
+		key.Hostname = strings.ToLower(entry.Hostname)
+	case sortIP:
+		key.IP = firstMatchOrEmpty(entry.IPv4, preferIpv4Net)
+		if key.IP == "" {
+			key.IP = firstMatchOrEmpty(entry.IPv6, "")
+		}
+	}
+	return key
+}
+
+// compare orders two keys by the sort field, then by MAC. Addresses compare numerically, IPv4
+// before IPv6, and devices without address come first.
+func (lq listQuery) compare(a, b listCursor) int {
+	c := 0
+	switch lq.sort {
+	case sortLastSeen:
+		c = a.LastSeen.Compare(b.LastSeen)
+	case sortHostname:
+		c = strings.Compare(a.Hostname, b.Hostname)
+	case sortIP:
+		// an unparsable address is the zero Addr, which sorts first
+		ipA, _ := netip.ParseAddr(a.IP)
+		ipB, _ := netip.ParseAddr(b.IP)
+		c = ipA.Unmap().Compare(ipB.Unmap())
+	}
+	if c == 0 {
+		c = strings.Compare(a.MAC, b.MAC)
+	}
+	if lq.desc {
+		return -c
+	}
+	return c
+}
+
+// byKey sorts entries together with their keys.
+type byKey struct {
+	entries []db.ArpEntry
+	keys    []listCursor
+	lq      listQuery
+}
+
+func (s byKey) Len() int           { return len(s.entries) }
+func (s byKey) Less(i, j int) bool { return s.lq.compare(s.keys[i], s.keys[j]) < 0 }
+func (s byKey) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+func TestParseListQuery_Invalid(t *testing.T) {
+	for _, query := range []string{
+		"cidr=nonsense", "family=ipx", "hostname=[", "vendor=00:11", "vendor=zz:11:22", "sort=vendor",
+		"order=up", "limit=0", "limit=x", "cursor=%21", "sort=ip&cursor=" + listCursor{Sort: sortMAC}.encode(),
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/current?"+query, nil)
+		if _, err := parseListQuery(r); err == nil {
+			t.Errorf("parseListQuery(%s): expected an error", query)
+		}
+	}
+}
+
+func TestAPI_CurrentEndpoint_Query(t *testing.T) {
+	names := map[string]string{"192.168.1.9": "printer.lan.", "192.168.1.10": "laptop.lan.", "10.0.0.2": "nas.example."}
+	netLookupAddr = func(addr string) ([]string, error) { return []string{names[addr]}, nil }
+	defer func() { netLookupAddr = func(addr string) ([]string, error) { return mockLookupAddr(addr) } }()
+
+	origIface := db.CaptureInterface
+	defer func() { db.CaptureInterface = origIface }()
+
+	store := db.NewMemoryStore()
+	for _, s := range []struct{ ip, mac, iface string }{
+		{"192.168.1.10", "00:11:22:00:00:01", "br0"},
+		{"192.168.1.9", "00:11:22:00:00:02", "br0"},
+		{"10.0.0.2", "66:77:88:00:00:03", "br0"},
+		{"fe80::1", "66:77:88:00:00:04", "eth1"},
+	} {
+		db.CaptureInterface = s.iface
+		if err := store.InsertARPEvent(s.ip, s.mac); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	list := func(query string) ([]string, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/current?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/current?%s: status %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var entries []db.ArpEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("decode entries: %v", err)
+		}
+		var macs []string
+		for _, e := range entries {
+			macs = append(macs, e.MAC[len(e.MAC)-2:])
+		}
+		return macs, rec.Header().Get(NextCursorHeader)
+	}
+
+	for query, want := range map[string]string{
+		"":                              "01 02 03 04",
+		"cidr=192.168.1.0/24":           "01 02",
+		"cidr=fe80::/10":                "04",
+		"family=ipv6":                   "04",
+		"family=ipv4&sort=-mac":         "03 02 01",
+		"vendor=00-11-22":               "01 02",
+		"vendor=667788":                 "03 04",
+		"interface=br0&vendor=66:77:88": "03",
+		"interface=eth1":                "04",
+		"interface=eth2":                "",
+		"hostname=*.LAN.":               "01 02",
+		"hostname=n?s.*":                "03",
+		"sort=hostname":                 "04 01 03 02",
+		"sort=ip":                       "03 02 01 04",
+		"sort=ip&order=desc":            "04 01 02 03",
+	} {
+		if macs, _ := list(query); strings.Join(macs, " ") != want {
+			t.Errorf("GET /api/current?%s = %v, want %s", query, macs, want)
+		}
+	}
+
+	for _, sort := range []string{"mac", "ip", "-last_seen", "hostname"} {
+		var pages []string
+		query := "limit=3&sort=" + sort
+		for {
+			macs, next := list(query)
+			pages = append(pages, strings.Join(macs, " "))
+			if next == "" {
+				break
+			}
+			query = "limit=3&sort=" + sort + "&cursor=" + url.QueryEscape(next)
+		}
+		all, _ := list("sort=" + sort)
+		if len(pages) != 2 || strings.Join(pages, " ") != strings.Join(all, " ") {
+			t.Errorf("sort=%s: pages %q do not add up to %v", sort, pages, all)
+		}
+	}
+}
+
+func TestAPI_EthersEndpoint_PagesWithoutZeroIPs(t *testing.T) {
+	store := db.NewMemoryStore()
+	for _, s := range []struct{ ip, mac string }{
+		{"192.168.1.1", "00:11:22:00:00:01"},
+		{"0.0.0.0", "00:11:22:00:00:02"},
+		{"0.0.0.0", "00:11:22:00:00:03"},
+		{"192.168.1.4", "00:11:22:00:00:04"},
+		{"0.0.0.0", "00:11:22:00:00:05"},
+		{"192.168.1.6", "00:11:22:00:00:06"},
+	} {
+		if err := store.InsertARPEvent(s.ip, s.mac); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", true, false)
+
+	var pages []string
+	query := "limit=2"
+	for len(pages) < 5 {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/ethers?"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /api/ethers?%s: status %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var macs []string
+		for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n")[1:] {
+			macs = append(macs, strings.Fields(line)[0][15:])
+		}
+		pages = append(pages, strings.Join(macs, " "))
+		next := rec.Header().Get(NextCursorHeader)
+		if next == "" {
+			break
+		}
+		query = "limit=2&cursor=" + url.QueryEscape(next)
+	}
+	if strings.Join(pages, " | ") != "01 04 | 06" {
+		t.Errorf("expected full pages without zero IPs, got %q", pages)
+	}
+}
+
+func TestAPI_CurrentEndpoint_HostnameFilterResolvesPageOnly(t *testing.T) {
+	var mu sync.Mutex
+	resolved := 0
+	origLookup := lookupEntry
+	defer func() { lookupEntry = origLookup }()
+	lookupEntry = func(entry *db.ArpEntry, resolveIpv6 bool, preferIpv4Net string, resolveKeaLeases bool) {
+		mu.Lock()
+		resolved++
+		mu.Unlock()
+		// every third device is a camera
+		if n, _ := strconv.ParseInt(entry.MAC[len(entry.MAC)-2:], 16, 0); n%3 == 0 {
+			entry.Hostname = fmt.Sprintf("cam-%d.lan.", n)
+		}
+	}
+
+	store := db.NewMemoryStore()
+	for i := 0; i < 200; i++ {
+		if err := store.InsertARPEvent(fmt.Sprintf("10.0.%d.%d", i/100, i%100+1), fmt.Sprintf("00:11:22:00:00:%02x", i)); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, store, false, "", false, false)
+
+	var pages []string
+	query := "hostname=cam-*&limit=2"
+	for len(pages) < 2 {
+		resolved = 0
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/current?"+query, nil))
+		var entries []db.ArpEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("decode entries: %v", err)
+		}
+		var macs []string
+		for _, e := range entries {
+			macs = append(macs, e.MAC[len(e.MAC)-2:])
+		}
+		pages = append(pages, strings.Join(macs, " "))
+		if resolved > lookupWorkers {
+			t.Errorf("page %d resolved %d devices, want at most one batch of %d", len(pages), resolved, lookupWorkers)
+		}
+		query = "hostname=cam-*&limit=2&cursor=" + url.QueryEscape(rec.Header().Get(NextCursorHeader))
+	}
+	if strings.Join(pages, " | ") != "00 03 | 06 09" {
+		t.Errorf("unexpected pages %q", pages)
+	}
+}
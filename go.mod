@@ -7,4 +7,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.47
 )
 
-require golang.org/x/sys v0.0.0-20220412211240-33da011f77ad // indirect
+require (
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859 // indirect
+	golang.org/x/sys v0.0.0-20220412211240-33da011f77ad // indirect
+)
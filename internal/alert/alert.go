@@ -0,0 +1,48 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Alert is the payload posted to the configured webhook.
+type Alert struct {
+	Kind    string    `json:"kind"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// WebhookURL receives every alert as JSON via POST, leave empty to only log alerts.
+var WebhookURL string
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func Notify(kind, message string) {
+	log.Printf("ALERT [%s] %s", kind, message)
+	if WebhookURL == "" {
+		return
+	}
+	go send(WebhookURL, Alert{Kind: kind, Message: message, Time: time.Now().UTC()})
+}
+
+func send(url string, a Alert) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		log.Printf("alert: failed to encode: %v", err)
+		return
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("alert: webhook failed: %v", err)
+		return
+	}
+	if err := resp.Body.Close(); err != nil {
+		log.Printf("alert: failed to close response body: %v", err)
+	}
+	if resp.StatusCode >= 300 {
+		log.Printf("alert: webhook returned status %d", resp.StatusCode)
+	}
+}
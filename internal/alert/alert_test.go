@@ -0,0 +1,35 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotify_Webhook(t *testing.T) {
+	received := make(chan Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var a Alert
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Errorf("decode alert: %v", err)
+		}
+		received <- a
+	}))
+	defer server.Close()
+
+	WebhookURL = server.URL
+	defer func() { WebhookURL = "" }()
+
+	Notify("test", "hello")
+
+	select {
+	case a := <-received:
+		if a.Kind != "test" || a.Message != "hello" {
+			t.Errorf("got alert %+v, want kind=test message=hello", a)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+}
@@ -1,58 +1,66 @@
 package arp
 
 import (
-	"database/sql"
-	"log"
-	"net"
+	"strings"
 
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 
 	"github.com/vgropp/arpmonitor/internal/db"
 )
 
-var insertARPEvent = db.InsertARPEvent
-
-func StartSniffer(iface string, database *sql.DB) {
-	handle, err := pcap.OpenLive(iface, 65536, true, pcap.BlockForever)
-	if err != nil {
-		log.Fatalf("error while opening %s: %v", iface, err)
-	}
+// StartSniffer processes the ARP, NDP and DHCP packets captured by handle until it is closed.
+func StartSniffer(handle *pcap.Handle, store db.Store) {
 	defer handle.Close()
+	setStatsHandle(handle)
+	defer setStatsHandle(nil)
 
-	if err := handle.SetBPFFilter("arp or icmp6"); err != nil {
-		log.Fatalf("BPF-Filter error: %v", err)
-	}
+	go runCounterFlusher(store, counterFlushInterval)
+
+	sniff(handle, store)
+}
 
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	for packet := range packetSource.Packets() {
-		ProcessPacket(packet, database)
+// ProcessPacket handles an already decoded packet. The sniffer itself decodes with a reusable
+// DecodingLayerParser, this entry point is kept for tests and callers working with gopacket.Packet.
+func ProcessPacket(packet gopacket.Packet, store db.Store) {
+	ci := packet.Metadata().CaptureInfo
+	if ci.Length == 0 {
+		ci.Length = len(packet.Data())
 	}
+	newDecoder().process(packet.Data(), ci, store)
 }
 
-func ProcessPacket(packet gopacket.Packet, database *sql.DB) {
-	ethLayer := packet.Layer(layers.LayerTypeEthernet)
-	var eth *layers.Ethernet
-	if ethLayer != nil {
-		eth = ethLayer.(*layers.Ethernet)
+// ForgetDevice drops what the sniffer keeps in memory about mac: its counters not written yet,
+// that it was checked for review alerts and the rogue DHCP alerts raised for it. Call it before
+// the device is deleted from the store; a flush in progress is waited for, so it cannot write the
+// counters back afterwards.
+func ForgetDevice(mac string) {
+	match := func(s string) bool { return strings.EqualFold(s, mac) }
+
+	flushMu.Lock()
+	countersMu.Lock()
+	for key := range counters {
+		if match(key.MAC) {
+			delete(counters, key)
+		}
 	}
+	countersMu.Unlock()
+	flushMu.Unlock()
 
-	if arpLayer := packet.Layer(layers.LayerTypeARP); arpLayer != nil {
-		arp := arpLayer.(*layers.ARP)
-		ip := net.IP(arp.SourceProtAddress).String()
-		mac := net.HardwareAddr(arp.SourceHwAddress).String()
-		insertARPEvent(database, ip, mac)
+	reviewMu.Lock()
+	for key := range devicesChecked {
+		if match(key) {
+			delete(devicesChecked, key)
+		}
 	}
+	reviewMu.Unlock()
 
-	if icmpLayer := packet.Layer(layers.LayerTypeICMPv6); icmpLayer != nil {
-		icmp := icmpLayer.(*layers.ICMPv6)
-		if icmp.TypeCode.Type() == 136 { // Neighbor Advertisement
-			if ndpLayer := packet.Layer(layers.LayerTypeIPv6); ndpLayer != nil && eth != nil {
-				ip6 := ndpLayer.(*layers.IPv6).SrcIP.String()
-				mac := eth.SrcMAC.String()
-				insertARPEvent(database, ip6, mac)
-			}
+	dhcpMu.Lock()
+	for key := range dhcpAlerted {
+		// keyed by server ID and MAC
+		if match(key[strings.LastIndexByte(key, '/')+1:]) {
+			delete(dhcpAlerted, key)
 		}
 	}
+	dhcpMu.Unlock()
 }
@@ -1,7 +1,6 @@
 package arp
 
 import (
-	"database/sql"
 	"fmt"
 	"testing"
 
@@ -10,20 +9,35 @@ import (
 	"github.com/vgropp/arpmonitor/internal/db"
 )
 
-// Mock InsertARPEvent
-var inserted []struct{ ip, mac string }
+// recordingStore records what the sniffer writes, on top of an in-memory store.
+type recordingStore struct {
+	*db.MemoryStore
+	inserted []struct{ ip, mac string }
+	dhcp     []db.DHCPServer
+	counts   map[db.CounterKey]db.PacketCounts
+}
 
-func mockInsertARPEvent(database *sql.DB, ip, mac string) {
-	inserted = append(inserted, struct{ ip, mac string }{ip, mac})
+func newRecordingStore() *recordingStore {
+	return &recordingStore{MemoryStore: db.NewMemoryStore()}
 }
 
-func TestProcessPacket_ARP(t *testing.T) {
-	// Patch db.InsertARPEvent
-	origInsert := db.InsertARPEvent
-	insertARPEvent = mockInsertARPEvent
-	defer func() { insertARPEvent = origInsert }()
+func (s *recordingStore) InsertARPEvent(ip, mac string) error {
+	s.inserted = append(s.inserted, struct{ ip, mac string }{ip, mac})
+	return s.MemoryStore.InsertARPEvent(ip, mac)
+}
 
-	inserted = nil
+func (s *recordingStore) RecordDHCPServer(server db.DHCPServer) error {
+	s.dhcp = append(s.dhcp, server)
+	return s.MemoryStore.RecordDHCPServer(server)
+}
+
+func (s *recordingStore) AddPacketCounts(counts map[db.CounterKey]db.PacketCounts) error {
+	s.counts = counts
+	return s.MemoryStore.AddPacketCounts(counts)
+}
+
+func TestProcessPacket_ARP(t *testing.T) {
+	store := newRecordingStore()
 
 	// Build ARP packet
 	eth := &layers.Ethernet{
@@ -49,8 +63,9 @@ func TestProcessPacket_ARP(t *testing.T) {
 	}
 	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
 
-	ProcessPacket(packet, nil)
+	ProcessPacket(packet, store)
 
+	inserted := store.inserted
 	if len(inserted) != 1 {
 		t.Fatalf("expected 1 insert, got %d", len(inserted))
 	}
@@ -60,11 +75,7 @@ func TestProcessPacket_ARP(t *testing.T) {
 }
 
 func TestProcessPacket_ICMPv6_NA(t *testing.T) {
-	origInsert := insertARPEvent
-	insertARPEvent = mockInsertARPEvent
-	defer func() { insertARPEvent = origInsert }()
-
-	inserted = nil
+	store := newRecordingStore()
 
 	eth := &layers.Ethernet{
 		SrcMAC:       []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
@@ -99,8 +110,9 @@ func TestProcessPacket_ICMPv6_NA(t *testing.T) {
 	}
 	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
 
-	ProcessPacket(packet, nil)
+	ProcessPacket(packet, store)
 
+	inserted := store.inserted
 	if len(inserted) != 1 {
 		t.Fatalf("expected 1 insert, got %d", len(inserted))
 	}
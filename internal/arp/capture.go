@@ -0,0 +1,114 @@
+package arp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+const bpfFilter = "arp or icmp6 or (udp and (port 67 or port 68))"
+
+const maxSnaplen = 262144
+
+var compileBPFFilter = pcap.CompileBPFFilter
+
+type CaptureOptions struct {
+	Promiscuous bool
+	Snaplen     int
+	// BufferSize is the kernel capture buffer in bytes, 0 keeps the libpcap default.
+	BufferSize    int
+	ImmediateMode bool
+	// ExtraFilter is a BPF expression AND-ed with the built-in filter, e.g. "vlan 20" or "host 192.168.1.10".
+	ExtraFilter string
+	// CountAllFrames drops the built-in filter, so the per-MAC frame and byte counters cover all traffic
+	// instead of only the monitored protocols.
+	CountAllFrames bool
+}
+
+func DefaultCaptureOptions() CaptureOptions {
+	return CaptureOptions{
+		Promiscuous: true,
+		Snaplen:     65536,
+	}
+}
+
+// Filter returns the BPF expression installed on the capture handle.
+func (o CaptureOptions) Filter() string {
+	base := bpfFilter
+	if o.CountAllFrames {
+		base = ""
+	}
+	extra := strings.TrimSpace(o.ExtraFilter)
+	switch {
+	case extra == "":
+		return base
+	case base == "":
+		return extra
+	default:
+		// the extra expression goes first, "vlan" shifts the offsets for everything after it
+		return fmt.Sprintf("(%s) and (%s)", extra, base)
+	}
+}
+
+// Validate checks the options, including compiling the BPF filter, without opening the interface.
+func (o CaptureOptions) Validate() error {
+	if o.Snaplen <= 0 || o.Snaplen > maxSnaplen {
+		return fmt.Errorf("invalid snaplen %d: must be between 1 and %d", o.Snaplen, maxSnaplen)
+	}
+	if o.BufferSize < 0 {
+		return fmt.Errorf("invalid buffer size %d: must not be negative", o.BufferSize)
+	}
+	if filter := o.Filter(); filter != "" {
+		if _, err := compileBPFFilter(layers.LinkTypeEthernet, o.Snaplen, filter); err != nil {
+			return fmt.Errorf("invalid BPF filter %q: %w", filter, err)
+		}
+	}
+	return nil
+}
+
+// OpenCapture validates opts and opens iface for capturing with the resulting BPF filter.
+func OpenCapture(iface string, opts CaptureOptions) (*pcap.Handle, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	inactive, err := pcap.NewInactiveHandle(iface)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening %s: %w", iface, err)
+	}
+	defer inactive.CleanUp()
+
+	if err := inactive.SetPromisc(opts.Promiscuous); err != nil {
+		return nil, fmt.Errorf("set promiscuous mode: %w", err)
+	}
+	if err := inactive.SetSnapLen(opts.Snaplen); err != nil {
+		return nil, fmt.Errorf("set snaplen: %w", err)
+	}
+	if opts.BufferSize > 0 {
+		if err := inactive.SetBufferSize(opts.BufferSize); err != nil {
+			return nil, fmt.Errorf("set buffer size: %w", err)
+		}
+	}
+	if opts.ImmediateMode {
+		if err := inactive.SetImmediateMode(true); err != nil {
+			return nil, fmt.Errorf("set immediate mode: %w", err)
+		}
+	}
+	if err := inactive.SetTimeout(pcap.BlockForever); err != nil {
+		return nil, fmt.Errorf("set timeout: %w", err)
+	}
+
+	handle, err := inactive.Activate()
+	if err != nil {
+		return nil, fmt.Errorf("error while opening %s: %w", iface, err)
+	}
+	if filter := opts.Filter(); filter != "" {
+		if err := handle.SetBPFFilter(filter); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("BPF-Filter error: %w", err)
+		}
+	}
+	return handle, nil
+}
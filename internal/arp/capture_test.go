@@ -0,0 +1,70 @@
+package arp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+func TestCaptureOptionsFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		opts CaptureOptions
+		want string
+	}{
+		{"built-in only", CaptureOptions{}, bpfFilter},
+		{"extra filter", CaptureOptions{ExtraFilter: " vlan 20 "}, "(vlan 20) and (" + bpfFilter + ")"},
+		{"all frames", CaptureOptions{CountAllFrames: true}, ""},
+		{"all frames with extra filter", CaptureOptions{CountAllFrames: true, ExtraFilter: "host 10.0.0.1"}, "host 10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.Filter(); got != tt.want {
+				t.Errorf("Filter() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptureOptionsValidate(t *testing.T) {
+	origCompile := compileBPFFilter
+	var compiled []string
+	compileBPFFilter = func(linkType layers.LinkType, captureLength int, expr string) ([]pcap.BPFInstruction, error) {
+		compiled = append(compiled, expr)
+		if strings.Contains(expr, "bogus") {
+			return nil, errors.New("syntax error")
+		}
+		return nil, nil
+	}
+	defer func() { compileBPFFilter = origCompile }()
+
+	if err := DefaultCaptureOptions().Validate(); err != nil {
+		t.Errorf("default options must be valid: %v", err)
+	}
+	if len(compiled) != 1 || compiled[0] != bpfFilter {
+		t.Errorf("expected built-in filter to be compiled, got %v", compiled)
+	}
+
+	opts := DefaultCaptureOptions()
+	opts.ExtraFilter = "bogus and"
+	err := opts.Validate()
+	if err == nil || !strings.Contains(err.Error(), "invalid BPF filter") || !strings.Contains(err.Error(), "syntax error") {
+		t.Errorf("expected invalid BPF filter error, got %v", err)
+	}
+
+	opts = DefaultCaptureOptions()
+	opts.Snaplen = 0
+	if err := opts.Validate(); err == nil {
+		t.Error("expected error for snaplen 0")
+	}
+
+	opts = DefaultCaptureOptions()
+	opts.BufferSize = -1
+	if err := opts.Validate(); err == nil {
+		t.Error("expected error for negative buffer size")
+	}
+}
@@ -0,0 +1,73 @@
+package arp
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+const counterFlushInterval = time.Minute
+
+type packetKind int
+
+const (
+	kindOther packetKind = iota
+	kindARP
+	kindNDP
+)
+
+var countersMu sync.Mutex
+var counters = make(map[db.CounterKey]db.PacketCounts)
+
+// flushMu is held while counters are written, so forgetting a MAC can wait for a running flush.
+var flushMu sync.Mutex
+
+// countPacket accounts one captured frame of the given kind to the hour bucket of mac.
+func countPacket(mac string, kind packetKind, length int, ts time.Time) {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	key := db.CounterKey{MAC: mac, Hour: ts.UTC().Truncate(time.Hour)}
+
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	c := counters[key]
+	switch kind {
+	case kindARP:
+		c.ARPPackets++
+	case kindNDP:
+		c.NDPPackets++
+	}
+	c.Frames++
+	c.Bytes += int64(length)
+	counters[key] = c
+}
+
+// FlushCounters writes the counters collected since the last flush to the database. The sniffer
+// flushes every counterFlushInterval; call it once more on shutdown, so the last counts are kept.
+func FlushCounters(store db.Store) {
+	flushMu.Lock()
+	defer flushMu.Unlock()
+
+	countersMu.Lock()
+	pending := counters
+	counters = make(map[db.CounterKey]db.PacketCounts)
+	countersMu.Unlock()
+
+	start := time.Now()
+	err := store.AddPacketCounts(pending)
+	observeStore("counters", start)
+	if err != nil {
+		log.Printf("failed to write packet counters: %v", err)
+	}
+}
+
+func runCounterFlusher(store db.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		FlushCounters(store)
+	}
+}
@@ -0,0 +1,80 @@
+package arp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+func TestCountPacketAndFlush(t *testing.T) {
+	store := newRecordingStore()
+	FlushCounters(store)
+
+	ts := time.Date(2025, 5, 30, 14, 12, 0, 0, time.UTC)
+	countPacket("00:11:22:33:44:55", kindARP, 60, ts)
+	countPacket("00:11:22:33:44:55", kindARP, 60, ts.Add(time.Minute))
+	countPacket("00:11:22:33:44:55", kindNDP, 86, ts.Add(time.Hour))
+	countPacket("66:77:88:99:aa:bb", kindOther, 1500, ts)
+
+	FlushCounters(store)
+
+	flushed := store.counts
+	if len(flushed) != 3 {
+		t.Fatalf("expected 3 buckets, got %d: %+v", len(flushed), flushed)
+	}
+	hour := ts.Truncate(time.Hour)
+	got := flushed[db.CounterKey{MAC: "00:11:22:33:44:55", Hour: hour}]
+	if got != (db.PacketCounts{ARPPackets: 2, Frames: 2, Bytes: 120}) {
+		t.Errorf("unexpected ARP bucket: %+v", got)
+	}
+	got = flushed[db.CounterKey{MAC: "00:11:22:33:44:55", Hour: hour.Add(time.Hour)}]
+	if got != (db.PacketCounts{NDPPackets: 1, Frames: 1, Bytes: 86}) {
+		t.Errorf("unexpected NDP bucket: %+v", got)
+	}
+	got = flushed[db.CounterKey{MAC: "66:77:88:99:aa:bb", Hour: hour}]
+	if got != (db.PacketCounts{Frames: 1, Bytes: 1500}) {
+		t.Errorf("unexpected frame bucket: %+v", got)
+	}
+
+	FlushCounters(store)
+	if len(store.counts) != 0 {
+		t.Errorf("expected counters to be reset after flush, got %+v", store.counts)
+	}
+}
+
+func TestForgetDevice_DropsSnifferState(t *testing.T) {
+	store := db.NewMemoryStore()
+	FlushCounters(store)
+	SetUnknownDeviceAlerts(true)
+	defer SetUnknownDeviceAlerts(false)
+
+	ts := time.Now()
+	for _, mac := range []string{"00:11:22:33:44:55", "66:77:88:99:aa:bb"} {
+		countPacket(mac, kindARP, 60, ts)
+		checkDevice(store, "192.168.1.10", mac)
+	}
+	dhcpMu.Lock()
+	dhcpAlerted["192.168.1.1/00:11:22:33:44:55"] = true
+	dhcpMu.Unlock()
+
+	ForgetDevice("00:11:22:33:44:55")
+	if _, err := store.ForgetDevice("00:11:22:33:44:55", "test", ""); err != nil {
+		t.Fatalf("ForgetDevice failed: %v", err)
+	}
+	FlushCounters(store)
+
+	counts, err := store.GetPacketCounts(24)
+	if err != nil || len(counts) != 1 || counts[0].MAC != "66:77:88:99:aa:bb" {
+		t.Errorf("expected only the other device's counters after a flush, got %+v, %v", counts, err)
+	}
+	reviewMu.Lock()
+	_, checked := devicesChecked["00:11:22:33:44:55"]
+	reviewMu.Unlock()
+	dhcpMu.Lock()
+	alerted := dhcpAlerted["192.168.1.1/00:11:22:33:44:55"]
+	dhcpMu.Unlock()
+	if checked || alerted {
+		t.Errorf("expected the forgotten device to be unchecked and unalerted: checked %v, alerted %v", checked, alerted)
+	}
+}
@@ -0,0 +1,133 @@
+package arp
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// packetReader is implemented by *pcap.Handle and by pcapgo readers for recorded captures.
+type packetReader interface {
+	ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+}
+
+// decoder decodes frames into preallocated layers. Decoding stops at the first layer we are not
+// interested in, e.g. MLD behind an IPv6 hop-by-hop header, which is the bulk of ICMPv6 multicast.
+type decoder struct {
+	parser  *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+
+	eth     layers.Ethernet
+	dot1q   layers.Dot1Q
+	arp     layers.ARP
+	ip4     layers.IPv4
+	ip6     layers.IPv6
+	icmp6   layers.ICMPv6
+	udp     layers.UDP
+	dhcp    layers.DHCPv4
+	payload gopacket.Payload
+}
+
+func newDecoder() *decoder {
+	d := &decoder{decoded: make([]gopacket.LayerType, 0, 8)}
+	d.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet,
+		&d.eth, &d.dot1q, &d.arp, &d.ip4, &d.ip6, &d.icmp6, &d.udp, &d.dhcp, &d.payload)
+	d.parser.IgnoreUnsupported = true
+	return d
+}
+
+// process handles one frame. data is only valid during the call, nothing may keep references into it.
+func (d *decoder) process(data []byte, ci gopacket.CaptureInfo, store db.Store) {
+	// on truncated or malformed frames the layers decoded so far are still usable
+	_ = d.parser.DecodeLayers(data, &d.decoded)
+
+	var hasEth, hasIPv4, hasIPv6, isDHCP bool
+	kind := kindOther
+	for _, layerType := range d.decoded {
+		switch layerType {
+		case layers.LayerTypeEthernet:
+			hasEth = true
+		case layers.LayerTypeIPv4:
+			hasIPv4 = true
+		case layers.LayerTypeIPv6:
+			hasIPv6 = true
+		case layers.LayerTypeARP:
+			kind = kindARP
+			ip := net.IP(d.arp.SourceProtAddress).String()
+			mac := net.HardwareAddr(d.arp.SourceHwAddress).String()
+			recordSighting(store, ip, mac)
+		case layers.LayerTypeICMPv6:
+			if t := d.icmp6.TypeCode.Type(); t >= 133 && t <= 137 { // Router Solicitation .. Redirect
+				kind = kindNDP
+			}
+			if d.icmp6.TypeCode.Type() == 136 && hasIPv6 && hasEth { // Neighbor Advertisement
+				recordSighting(store, d.ip6.SrcIP.String(), d.eth.SrcMAC.String())
+			}
+		case layers.LayerTypeDHCPv4:
+			isDHCP = true
+			if hasEth {
+				var srcIP net.IP
+				if hasIPv4 {
+					srcIP = d.ip4.SrcIP
+				}
+				processDHCP(&d.dhcp, srcIP, d.eth.SrcMAC, store)
+			}
+		}
+	}
+
+	if hasEth {
+		countPacket(d.eth.SrcMAC.String(), kind, ci.Length, ci.Timestamp)
+	}
+	packetsProcessed.Inc(packetType(kind, isDHCP))
+}
+
+// packetType is the type label of arpmonitor_packets_total.
+func packetType(kind packetKind, isDHCP bool) string {
+	switch {
+	case kind == kindARP:
+		return "arp"
+	case kind == kindNDP:
+		return "ndp"
+	case isDHCP:
+		return "dhcp"
+	}
+	return "other"
+}
+
+// recordSighting stores a sighting of ip at mac. A failure loses only this sighting, so sniffing goes on.
+func recordSighting(store db.Store, ip, mac string) {
+	checkDevice(store, ip, mac)
+	start := time.Now()
+	err := store.InsertARPEvent(ip, mac)
+	observeStore("sighting", start)
+	if err != nil {
+		log.Printf("failed to record %s at %s: %v", ip, mac, err)
+	}
+}
+
+// sniff processes packets of src until it is exhausted or fails.
+func sniff(src packetReader, store db.Store) {
+	d := newDecoder()
+	for {
+		data, ci, err := src.ZeroCopyReadPacketData()
+		switch {
+		case err == nil:
+			d.process(data, ci, store)
+		case errors.Is(err, pcap.NextErrorTimeoutExpired):
+			continue
+		case errors.Is(err, io.EOF), errors.Is(err, pcap.NextErrorNoMorePackets):
+			return
+		default:
+			log.Printf("error reading packet: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package arp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+type capturedPacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+}
+
+// mirrorHosts is the number of hosts on the simulated mirror port.
+const mirrorHosts = 40
+
+// mirrorCapture builds a capture shaped like a busy mirror port: mostly MLD reports behind a
+// hop-by-hop header and neighbor solicitations to IPv6 multicast, some ARP, neighbor
+// advertisements and DHCP offers.
+func mirrorCapture(tb testing.TB) []capturedPacket {
+	start := time.Date(2025, 5, 27, 12, 0, 0, 0, time.UTC)
+	var packets []capturedPacket
+	add := func(l ...gopacket.SerializableLayer) {
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, l...); err != nil {
+			tb.Fatalf("SerializeLayers failed: %v", err)
+		}
+		data := append([]byte(nil), buf.Bytes()...)
+		ts := start.Add(time.Duration(len(packets)) * 10 * time.Millisecond)
+		packets = append(packets, capturedPacket{data, gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(data), Length: len(data)}})
+	}
+
+	for i := 0; i < 1000; i++ {
+		host := byte(i % mirrorHosts)
+		mac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, host + 1}
+		ip4 := net.IP{192, 168, 1, host + 10}
+		ll := net.ParseIP(fmt.Sprintf("fe80::%x", int(host)+1))
+		switch i % 10 {
+		case 0, 1, 2, 3:
+			add(mirrorEthernet(mac, net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x16}, layers.EthernetTypeIPv6),
+				&layers.IPv6{Version: 6, HopLimit: 1, NextHeader: layers.IPProtocolIPv6HopByHop, SrcIP: ll, DstIP: net.ParseIP("ff02::16")},
+				gopacket.Payload(mldReport))
+		case 4, 5, 6:
+			target := net.ParseIP(fmt.Sprintf("fe80::%x", int(host+1)%mirrorHosts+1))
+			add(mirrorEthernet(mac, net.HardwareAddr{0x33, 0x33, 0xff, 0x00, 0x00, target[15]}, layers.EthernetTypeIPv6),
+				&layers.IPv6{Version: 6, HopLimit: 255, NextHeader: layers.IPProtocolICMPv6, SrcIP: ll, DstIP: net.ParseIP(fmt.Sprintf("ff02::1:ff00:%x", target[15]))},
+				&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0)},
+				gopacket.Payload(append(make([]byte, 4), target...)))
+		case 7:
+			add(mirrorEthernet(mac, layers.EthernetBroadcast, layers.EthernetTypeARP),
+				&layers.ARP{
+					AddrType:          layers.LinkTypeEthernet,
+					Protocol:          layers.EthernetTypeIPv4,
+					HwAddressSize:     6,
+					ProtAddressSize:   4,
+					Operation:         layers.ARPRequest,
+					SourceHwAddress:   mac,
+					SourceProtAddress: ip4,
+					DstHwAddress:      make([]byte, 6),
+					DstProtAddress:    net.IP{192, 168, 1, 1},
+				})
+		case 8:
+			add(mirrorEthernet(mac, net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x01}, layers.EthernetTypeIPv6),
+				&layers.IPv6{Version: 6, HopLimit: 255, NextHeader: layers.IPProtocolICMPv6, SrcIP: ll, DstIP: net.ParseIP("ff02::1")},
+				&layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0)},
+				gopacket.Payload(append([]byte{0x20, 0, 0, 0}, ll...)))
+		case 9:
+			if i%100 != 99 {
+				add(mirrorEthernet(mac, layers.EthernetBroadcast, layers.EthernetTypeIPv4),
+					&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: ip4, DstIP: net.IPv4bcast},
+					&layers.UDP{SrcPort: 5353, DstPort: 5353},
+					gopacket.Payload(make([]byte, 32)))
+				continue
+			}
+			server := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x01, 0x01}
+			add(mirrorEthernet(server, layers.EthernetBroadcast, layers.EthernetTypeIPv4),
+				&layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{192, 168, 1, 1}, DstIP: net.IPv4bcast},
+				&layers.UDP{SrcPort: 67, DstPort: 68},
+				&layers.DHCPv4{
+					Operation:    layers.DHCPOpReply,
+					HardwareType: layers.LinkTypeEthernet,
+					HardwareLen:  6,
+					Xid:          uint32(i),
+					YourClientIP: ip4,
+					ClientHWAddr: mac,
+					Options: layers.DHCPOptions{
+						layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeOffer)}),
+						layers.NewDHCPOption(layers.DHCPOptServerID, []byte{192, 168, 1, 1}),
+						layers.NewDHCPOption(layers.DHCPOptSubnetMask, []byte{255, 255, 255, 0}),
+						layers.NewDHCPOption(layers.DHCPOptRouter, []byte{192, 168, 1, 1}),
+						layers.NewDHCPOption(layers.DHCPOptDNS, []byte{192, 168, 1, 1}),
+					},
+				})
+		}
+	}
+	return packets
+}
+
+// mldReport is a hop-by-hop header with a router alert followed by an MLDv2 report for one group.
+var mldReport = []byte{
+	58, 0, 5, 2, 0, 0, 1, 0, // next header ICMPv6, router alert, PadN
+	143, 0, 0, 0, 0, 0, 0, 1, // MLDv2 report, one record
+	4, 0, 0, 0, 0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xfb, // CHANGE_TO_EXCLUDE ff02::fb
+}
+
+func mirrorEthernet(src, dst net.HardwareAddr, typ layers.EthernetType) *layers.Ethernet {
+	return &layers.Ethernet{SrcMAC: src, DstMAC: dst, EthernetType: typ}
+}
+
+// pcapHeaderLen is the length of the pcap file header, the packet records follow it.
+const pcapHeaderLen = 24
+
+// mirrorPcap returns packets as a recorded pcap.
+func mirrorPcap(tb testing.TB, packets []capturedPacket) []byte {
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		tb.Fatalf("write pcap header: %v", err)
+	}
+	for _, p := range packets {
+		if err := w.WritePacket(p.ci, p.data); err != nil {
+			tb.Fatalf("write packet: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// mirrorReader returns packets as a recorded pcap, as the sniffer reads it from a capture file.
+func mirrorReader(tb testing.TB, packets []capturedPacket) *pcapgo.Reader {
+	return repeatReader(tb, packets, 1)
+}
+
+// repeatReader returns a pcap of packets repeated n times, without holding n copies in memory.
+func repeatReader(tb testing.TB, packets []capturedPacket, n int) *pcapgo.Reader {
+	capture := mirrorPcap(tb, packets)
+	parts := []io.Reader{bytes.NewReader(capture[:pcapHeaderLen])}
+	for i := 0; i < n; i++ {
+		parts = append(parts, bytes.NewReader(capture[pcapHeaderLen:]))
+	}
+	r, err := pcapgo.NewReader(io.MultiReader(parts...))
+	if err != nil {
+		tb.Fatalf("read pcap: %v", err)
+	}
+	return r
+}
+
+// processPacketAPI is the former gopacket.Packet based implementation, kept as reference for the benchmarks.
+func processPacketAPI(data []byte, ci gopacket.CaptureInfo, store db.Store) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	ethLayer := packet.Layer(layers.LayerTypeEthernet)
+	var eth *layers.Ethernet
+	if ethLayer != nil {
+		eth = ethLayer.(*layers.Ethernet)
+	}
+
+	kind := kindOther
+	if arpLayer := packet.Layer(layers.LayerTypeARP); arpLayer != nil {
+		kind = kindARP
+		arp := arpLayer.(*layers.ARP)
+		_ = store.InsertARPEvent(net.IP(arp.SourceProtAddress).String(), net.HardwareAddr(arp.SourceHwAddress).String())
+	}
+	if icmpLayer := packet.Layer(layers.LayerTypeICMPv6); icmpLayer != nil {
+		icmp := icmpLayer.(*layers.ICMPv6)
+		if t := icmp.TypeCode.Type(); t >= 133 && t <= 137 {
+			kind = kindNDP
+		}
+		if icmp.TypeCode.Type() == 136 {
+			if ndpLayer := packet.Layer(layers.LayerTypeIPv6); ndpLayer != nil && eth != nil {
+				_ = store.InsertARPEvent(ndpLayer.(*layers.IPv6).SrcIP.String(), eth.SrcMAC.String())
+			}
+		}
+	}
+	if dhcpLayer := packet.Layer(layers.LayerTypeDHCPv4); dhcpLayer != nil && eth != nil {
+		var srcIP net.IP
+		if ipLayer := packet.Layer(layers.LayerTypeIPv4); ipLayer != nil {
+			srcIP = ipLayer.(*layers.IPv4).SrcIP
+		}
+		processDHCP(dhcpLayer.(*layers.DHCPv4), srcIP, eth.SrcMAC, store)
+	}
+	if eth != nil {
+		countPacket(eth.SrcMAC.String(), kind, ci.Length, ci.Timestamp)
+	}
+}
+
+// sinkStore counts what the sniffer writes without keeping it.
+type sinkStore struct {
+	*db.MemoryStore
+	events map[string]int
+	dhcp   int
+	counts map[db.CounterKey]db.PacketCounts
+}
+
+func (s *sinkStore) InsertARPEvent(ip, mac string) error {
+	s.events[ip+"/"+mac]++
+	return nil
+}
+
+func (s *sinkStore) RecordDHCPServer(server db.DHCPServer) error {
+	s.dhcp++
+	return nil
+}
+
+func (s *sinkStore) AddPacketCounts(counts map[db.CounterKey]db.PacketCounts) error {
+	s.counts = counts
+	return nil
+}
+
+// withSink runs fn against a fresh sinkStore and flushes the packet counters collected by fn into it.
+func withSink(fn func(store *sinkStore)) *sinkStore {
+	store := &sinkStore{MemoryStore: db.NewMemoryStore(), events: map[string]int{}}
+	FlushCounters(store)
+	fn(store)
+	FlushCounters(store)
+	return store
+}
+
+func TestSniff_MatchesPacketAPI(t *testing.T) {
+	packets := mirrorCapture(t)
+
+	want := withSink(func(store *sinkStore) {
+		for _, p := range packets {
+			processPacketAPI(p.data, p.ci, store)
+		}
+	})
+	got := withSink(func(store *sinkStore) {
+		sniff(mirrorReader(t, packets), store)
+	})
+
+	if len(want.events) == 0 || want.dhcp == 0 {
+		t.Fatalf("capture yields no events: %+v", want)
+	}
+	if len(got.events) != len(want.events) || got.dhcp != want.dhcp || len(got.counts) != len(want.counts) {
+		t.Fatalf("got %d events, %d dhcp, %d counters; want %d, %d, %d",
+			len(got.events), got.dhcp, len(got.counts), len(want.events), want.dhcp, len(want.counts))
+	}
+	for k, n := range want.events {
+		if got.events[k] != n {
+			t.Errorf("event %s: got %d, want %d", k, got.events[k], n)
+		}
+	}
+	for k, c := range want.counts {
+		if got.counts[k] != c {
+			t.Errorf("counter %v: got %+v, want %+v", k, got.counts[k], c)
+		}
+	}
+}
+
+func TestProcessPacket_VLANTagged(t *testing.T) {
+	res := withSink(func(store *sinkStore) {
+		eth := &layers.Ethernet{
+			SrcMAC:       []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			DstMAC:       []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+			EthernetType: layers.EthernetTypeDot1Q,
+		}
+		vlan := &layers.Dot1Q{VLANIdentifier: 20, Type: layers.EthernetTypeARP}
+		arpLayer := &layers.ARP{
+			AddrType:          layers.LinkTypeEthernet,
+			Protocol:          layers.EthernetTypeIPv4,
+			HwAddressSize:     6,
+			ProtAddressSize:   4,
+			Operation:         layers.ARPRequest,
+			SourceHwAddress:   []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			SourceProtAddress: []byte{10, 0, 20, 5},
+			DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
+			DstProtAddress:    []byte{10, 0, 20, 1},
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, vlan, arpLayer); err != nil {
+			t.Fatalf("SerializeLayers failed: %v", err)
+		}
+		ProcessPacket(gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default), store)
+	})
+
+	if res.events["10.0.20.5/00:11:22:33:44:55"] != 1 {
+		t.Errorf("expected ARP event from VLAN 20, got %v", res.events)
+	}
+}
+
+func BenchmarkProcess_PacketAPI(b *testing.B) {
+	packets := mirrorCapture(b)
+	withSink(func(store *sinkStore) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			p := packets[i%len(packets)]
+			processPacketAPI(p.data, p.ci, store)
+		}
+	})
+}
+
+func BenchmarkProcess_DecodingLayerParser(b *testing.B) {
+	packets := mirrorCapture(b)
+	d := newDecoder()
+	withSink(func(store *sinkStore) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			p := packets[i%len(packets)]
+			d.process(p.data, p.ci, store)
+		}
+	})
+}
+
+// The Sniff benchmarks read the whole capture once per iteration, including reading the pcap
+// records, and report the time per packet.
+func BenchmarkSniff_PacketAPI(b *testing.B) {
+	packets := mirrorCapture(b)
+	r := repeatReader(b, packets, b.N)
+	withSink(func(store *sinkStore) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		for {
+			data, ci, err := r.ReadPacketData()
+			if err != nil {
+				break
+			}
+			processPacketAPI(data, ci, store)
+		}
+		b.StopTimer()
+	})
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(packets)), "ns/packet")
+}
+
+func BenchmarkSniff_ZeroCopy(b *testing.B) {
+	packets := mirrorCapture(b)
+	r := repeatReader(b, packets, b.N)
+	withSink(func(store *sinkStore) {
+		b.ReportAllocs()
+		b.ResetTimer()
+		sniff(r, store)
+		b.StopTimer()
+	})
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(packets)), "ns/packet")
+}
+
+func TestSniff_CountsPacketTypes(t *testing.T) {
+	packets := mirrorCapture(t)
+	types := []string{"arp", "ndp", "dhcp", "other"}
+	before := make(map[string]float64)
+	for _, typ := range types {
+		before[typ] = packetsProcessed.Value(typ)
+	}
+
+	withSink(func(store *sinkStore) {
+		d := newDecoder()
+		for _, p := range packets {
+			d.process(p.data, p.ci, store)
+		}
+	})
+
+	total := 0.0
+	for _, typ := range types {
+		n := packetsProcessed.Value(typ) - before[typ]
+		if n == 0 && typ != "other" {
+			t.Errorf("no %s packets counted", typ)
+		}
+		total += n
+	}
+	if total != float64(len(packets)) {
+		t.Errorf("counted %v packets, want %d", total, len(packets))
+	}
+}
@@ -0,0 +1,124 @@
+package arp
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/vgropp/arpmonitor/internal/alert"
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+var notify = alert.Notify
+
+var dhcpMu sync.Mutex
+var dhcpAllowlist = map[string]bool{}
+var dhcpAlerted = map[string]bool{}
+
+// SetDHCPAllowlist configures the server identifiers (IPs) and MACs allowed to hand out leases.
+// With an empty allowlist every DHCP server is allowed, they are only tracked and no alerts are
+// raised.
+func SetDHCPAllowlist(entries []string) {
+	dhcpMu.Lock()
+	defer dhcpMu.Unlock()
+	dhcpAllowlist = map[string]bool{}
+	dhcpAlerted = map[string]bool{}
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if mac, err := net.ParseMAC(e); err == nil {
+			e = mac.String()
+		} else if ip := net.ParseIP(e); ip != nil {
+			e = ip.String()
+		}
+		dhcpAllowlist[e] = true
+	}
+}
+
+func dhcpServerAllowed(serverID, mac string) bool {
+	return dhcpAllowlist[serverID] || dhcpAllowlist[mac]
+}
+
+func processDHCP(dhcp *layers.DHCPv4, srcIP net.IP, srcMAC net.HardwareAddr, store db.Store) {
+	if dhcp.Operation != layers.DHCPOpReply {
+		return
+	}
+
+	var msgType layers.DHCPMsgType
+	var serverID, mask net.IP
+	var gateway string
+	var dns []string
+	for _, opt := range dhcp.Options {
+		switch opt.Type {
+		case layers.DHCPOptMessageType:
+			if len(opt.Data) == 1 {
+				msgType = layers.DHCPMsgType(opt.Data[0])
+			}
+		case layers.DHCPOptServerID:
+			if len(opt.Data) == 4 {
+				serverID = net.IP(opt.Data)
+			}
+		case layers.DHCPOptSubnetMask:
+			if len(opt.Data) == 4 {
+				mask = net.IP(opt.Data)
+			}
+		case layers.DHCPOptRouter:
+			if len(opt.Data) >= 4 {
+				gateway = net.IP(opt.Data[:4]).String()
+			}
+		case layers.DHCPOptDNS:
+			for i := 0; i+4 <= len(opt.Data); i += 4 {
+				dns = append(dns, net.IP(opt.Data[i:i+4]).String())
+			}
+		}
+	}
+	if msgType != layers.DHCPMsgTypeOffer && msgType != layers.DHCPMsgTypeAck {
+		return
+	}
+	if serverID == nil {
+		serverID = srcIP
+	}
+	if serverID == nil {
+		return
+	}
+
+	server := db.DHCPServer{
+		ServerID: serverID.String(),
+		MAC:      srcMAC.String(),
+		Gateway:  gateway,
+		DNS:      dns,
+	}
+	if mask != nil && dhcp.YourClientIP != nil && !dhcp.YourClientIP.IsUnspecified() {
+		ipMask := net.IPMask(mask.To4())
+		server.Subnet = (&net.IPNet{IP: dhcp.YourClientIP.Mask(ipMask), Mask: ipMask}).String()
+	}
+
+	dhcpMu.Lock()
+	// without an allowlist every server is allowed
+	server.Allowed = len(dhcpAllowlist) == 0 || dhcpServerAllowed(server.ServerID, server.MAC)
+	key := server.ServerID + "/" + server.MAC
+	raise := !server.Allowed && !dhcpAlerted[key]
+	if raise {
+		dhcpAlerted[key] = true
+	}
+	dhcpMu.Unlock()
+
+	start := time.Now()
+	err := store.RecordDHCPServer(server)
+	observeStore("dhcp", start)
+	if err != nil {
+		log.Printf("failed to record DHCP server %s (%s): %v", server.ServerID, server.MAC, err)
+	}
+
+	if raise {
+		notify("rogue-dhcp", fmt.Sprintf("unknown DHCP server %s (%s) offering subnet %s, gateway %s, dns %s",
+			server.ServerID, server.MAC, server.Subnet, server.Gateway, strings.Join(server.DNS, ",")))
+	}
+}
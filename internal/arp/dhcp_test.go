@@ -0,0 +1,119 @@
+package arp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func buildDHCPPacket(srcMAC net.HardwareAddr, serverID net.IP, msgType layers.DHCPMsgType) gopacket.Packet {
+	eth := &layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip4 := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    serverID,
+		DstIP:    net.IP{255, 255, 255, 255},
+	}
+	udp := &layers.UDP{SrcPort: 67, DstPort: 68}
+	if err := udp.SetNetworkLayerForChecksum(ip4); err != nil {
+		panic(err)
+	}
+	dhcp := &layers.DHCPv4{
+		Operation:    layers.DHCPOpReply,
+		HardwareType: layers.LinkTypeEthernet,
+		HardwareLen:  6,
+		YourClientIP: net.IP{192, 168, 1, 57},
+		ClientHWAddr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		Options: layers.DHCPOptions{
+			layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(msgType)}),
+			layers.NewDHCPOption(layers.DHCPOptServerID, serverID.To4()),
+			layers.NewDHCPOption(layers.DHCPOptSubnetMask, []byte{255, 255, 255, 0}),
+			layers.NewDHCPOption(layers.DHCPOptRouter, []byte{192, 168, 1, 1}),
+			layers.NewDHCPOption(layers.DHCPOptDNS, []byte{192, 168, 1, 1, 1, 1, 1, 1}),
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip4, udp, dhcp); err != nil {
+		panic(fmt.Errorf("SerializeLayers failed: %w", err))
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+}
+
+func TestProcessPacket_DHCPOffer(t *testing.T) {
+	store := newRecordingStore()
+	var alerts []string
+	origNotify := notify
+	notify = func(kind, message string) { alerts = append(alerts, message) }
+	defer func() { notify = origNotify }()
+	SetDHCPAllowlist([]string{"192.168.1.1"})
+	defer SetDHCPAllowlist(nil)
+
+	serverMAC := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	ProcessPacket(buildDHCPPacket(serverMAC, net.IP{192, 168, 1, 1}, layers.DHCPMsgTypeOffer), store)
+
+	recorded := store.dhcp
+	if len(recorded) != 1 {
+		t.Fatalf("expected 1 recorded server, got %d", len(recorded))
+	}
+	got := recorded[0]
+	if got.ServerID != "192.168.1.1" || got.MAC != "00:11:22:33:44:55" || got.Subnet != "192.168.1.0/24" ||
+		got.Gateway != "192.168.1.1" || len(got.DNS) != 2 || got.DNS[1] != "1.1.1.1" || !got.Allowed {
+		t.Errorf("unexpected recorded server: %+v", got)
+	}
+	if len(alerts) != 0 {
+		t.Errorf("expected no alerts for allowed server, got %v", alerts)
+	}
+}
+
+func TestProcessPacket_RogueDHCPAlert(t *testing.T) {
+	store := newRecordingStore()
+	var alerts []string
+	origNotify := notify
+	notify = func(kind, message string) { alerts = append(alerts, message) }
+	defer func() { notify = origNotify }()
+	SetDHCPAllowlist([]string{"192.168.1.1", "00:11:22:33:44:55"})
+	defer SetDHCPAllowlist(nil)
+
+	rogueMAC := net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb}
+	ProcessPacket(buildDHCPPacket(rogueMAC, net.IP{192, 168, 0, 1}, layers.DHCPMsgTypeOffer), store)
+	ProcessPacket(buildDHCPPacket(rogueMAC, net.IP{192, 168, 0, 1}, layers.DHCPMsgTypeAck), store)
+	// other message types are ignored
+	ProcessPacket(buildDHCPPacket(rogueMAC, net.IP{192, 168, 0, 1}, layers.DHCPMsgTypeRelease), store)
+
+	recorded := store.dhcp
+	if len(recorded) != 2 {
+		t.Fatalf("expected 2 recorded sightings, got %d", len(recorded))
+	}
+	if recorded[0].Allowed {
+		t.Errorf("rogue server must not be allowed: %+v", recorded[0])
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("expected exactly 1 alert, got %v", alerts)
+	}
+}
+
+func TestProcessPacket_DHCPWithoutAllowlist(t *testing.T) {
+	store := newRecordingStore()
+	var alerts []string
+	origNotify := notify
+	notify = func(kind, message string) { alerts = append(alerts, message) }
+	defer func() { notify = origNotify }()
+
+	ProcessPacket(buildDHCPPacket(net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb}, net.IP{10, 0, 0, 1}, layers.DHCPMsgTypeOffer), store)
+
+	if len(alerts) != 0 {
+		t.Errorf("expected no alerts without allowlist, got %v", alerts)
+	}
+	if len(store.dhcp) != 1 || !store.dhcp[0].Allowed {
+		t.Errorf("expected the server to be allowed without allowlist, got %+v", store.dhcp)
+	}
+}
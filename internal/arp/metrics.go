@@ -0,0 +1,64 @@
+package arp
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+
+	"github.com/vgropp/arpmonitor/internal/metrics"
+)
+
+var (
+	packetsProcessed = metrics.NewCounter("arpmonitor_packets_total",
+		"Captured frames processed, by type: arp, ndp, dhcp or other.", "type")
+	storeDuration = metrics.NewHistogram("arpmonitor_db_insert_duration_seconds",
+		"Time to write captured data to the store, by kind: sighting, dhcp or counters.", metrics.DefaultLatencyBuckets, "kind")
+)
+
+// statsHandle is the handle of the running sniffer, nil while none runs or after it was closed.
+var (
+	statsMu     sync.Mutex
+	statsHandle *pcap.Handle
+)
+
+func init() {
+	metrics.Register(&metrics.Func{
+		Name:    "arpmonitor_pcap_dropped_packets_total",
+		Help:    "Packets dropped before the sniffer saw them, in the capture buffer or by the interface.",
+		Type:    "counter",
+		Labels:  []string{"where"},
+		Collect: collectDrops,
+	})
+}
+
+func collectDrops() []metrics.Sample {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if statsHandle == nil {
+		return nil
+	}
+	stats, err := statsHandle.Stats()
+	if err != nil {
+		log.Printf("failed to read capture statistics: %v", err)
+		return nil
+	}
+	return []metrics.Sample{
+		{LabelValues: []string{"buffer"}, Value: float64(stats.PacketsDropped)},
+		{LabelValues: []string{"interface"}, Value: float64(stats.PacketsIfDropped)},
+	}
+}
+
+// setStatsHandle makes the drops of handle available as metrics, nil stops reading them before
+// the handle is closed.
+func setStatsHandle(handle *pcap.Handle) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	statsHandle = handle
+}
+
+// observeStore records how long a write of kind that started at start took.
+func observeStore(kind string, start time.Time) {
+	storeDuration.Observe(time.Since(start).Seconds(), kind)
+}
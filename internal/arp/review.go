@@ -0,0 +1,58 @@
+package arp
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+// reviewAlertInterval is how often a device nobody approved raises an alert while it is seen.
+const reviewAlertInterval = time.Hour
+
+var reviewMu sync.Mutex
+var unknownDeviceAlerts bool
+
+// devicesChecked holds when each MAC was last checked for its review state
+var devicesChecked = map[string]time.Time{}
+
+// SetUnknownDeviceAlerts enables alerts for devices nobody approved and for rejected devices.
+func SetUnknownDeviceAlerts(enabled bool) {
+	reviewMu.Lock()
+	defer reviewMu.Unlock()
+	unknownDeviceAlerts = enabled
+	devicesChecked = map[string]time.Time{}
+}
+
+// checkDevice raises an alert if mac is a device that was not approved, new, unknown or rejected,
+// before the sighting is stored. Each MAC is checked at most once per reviewAlertInterval, so a
+// device sending ARP all the time neither floods the alerts nor queries the store per packet.
+func checkDevice(store db.Store, ip, mac string) {
+	now := time.Now()
+	reviewMu.Lock()
+	check := unknownDeviceAlerts && now.Sub(devicesChecked[mac]) >= reviewAlertInterval
+	if check {
+		devicesChecked[mac] = now
+	}
+	reviewMu.Unlock()
+	if !check {
+		return
+	}
+
+	device, err := store.GetDevice(mac)
+	if err != nil && !db.IsPartial(err) {
+		log.Printf("failed to check device %s: %v", mac, err)
+		return
+	}
+	switch {
+	case device != nil && device.Status == db.DeviceRejected:
+		notify("rejected-device", fmt.Sprintf("rejected device %s seen at %s", mac, ip))
+	case device != nil && device.Status == db.DeviceApproved:
+	case device == nil, device.FirstSeen == nil:
+		notify("unknown-device", fmt.Sprintf("new unknown device %s at %s", mac, ip))
+	default:
+		notify("unknown-device", fmt.Sprintf("unknown device %s seen at %s", mac, ip))
+	}
+}
@@ -0,0 +1,63 @@
+package arp
+
+import (
+	"testing"
+
+	"github.com/vgropp/arpmonitor/internal/db"
+)
+
+func TestCheckDevice_Alerts(t *testing.T) {
+	var alerts []string
+	origNotify := notify
+	notify = func(kind, message string) { alerts = append(alerts, kind+": "+message) }
+	defer func() { notify = origNotify }()
+	defer SetUnknownDeviceAlerts(false)
+
+	store := db.NewMemoryStore()
+	if err := store.InsertARPEvent("192.168.1.9", "00:00:00:00:00:01"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	if _, err := store.SetDeviceStatus("00:00:00:00:00:02", db.DeviceApproved, ""); err != nil {
+		t.Fatalf("SetDeviceStatus failed: %v", err)
+	}
+	if _, err := store.SetDeviceStatus("00:00:00:00:00:03", db.DeviceRejected, ""); err != nil {
+		t.Fatalf("SetDeviceStatus failed: %v", err)
+	}
+
+	recordSighting(store, "192.168.1.10", "00:00:00:00:00:04")
+	if len(alerts) != 0 {
+		t.Fatalf("expected no alerts while disabled, got %v", alerts)
+	}
+
+	SetUnknownDeviceAlerts(true)
+	recordSighting(store, "192.168.1.9", "00:00:00:00:00:01")  // known before, though unknown
+	recordSighting(store, "192.168.1.11", "00:00:00:00:00:02") // approved before it was seen
+	recordSighting(store, "192.168.1.12", "00:00:00:00:00:03") // rejected
+	recordSighting(store, "192.168.1.13", "00:00:00:00:00:05") // new
+	recordSighting(store, "192.168.1.13", "00:00:00:00:00:05")
+	recordSighting(store, "192.168.1.12", "00:00:00:00:00:03")
+
+	// once the interval passed, every device nobody approved alerts again
+	reviewMu.Lock()
+	for mac := range devicesChecked {
+		devicesChecked[mac] = devicesChecked[mac].Add(-reviewAlertInterval)
+	}
+	reviewMu.Unlock()
+	recordSighting(store, "192.168.1.11", "00:00:00:00:00:02")
+	recordSighting(store, "192.168.1.13", "00:00:00:00:00:05")
+
+	want := []string{
+		"unknown-device: unknown device 00:00:00:00:00:01 seen at 192.168.1.9",
+		"rejected-device: rejected device 00:00:00:00:00:03 seen at 192.168.1.12",
+		"unknown-device: new unknown device 00:00:00:00:00:05 at 192.168.1.13",
+		"unknown-device: unknown device 00:00:00:00:00:05 seen at 192.168.1.13",
+	}
+	if len(alerts) != len(want) {
+		t.Fatalf("got alerts %v, want %v", alerts, want)
+	}
+	for i := range want {
+		if alerts[i] != want[i] {
+			t.Errorf("alert %d: got %q, want %q", i, alerts[i], want[i])
+		}
+	}
+}
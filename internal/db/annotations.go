@@ -0,0 +1,186 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Annotation is what an admin attached to a MAC. A Name takes precedence over DNS and Kea
+// hostnames.
+type Annotation struct {
+	MAC       string    `json:"mac"`
+	Name      string    `json:"name,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+	Location  string    `json:"location,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Limits of annotation fields, in characters.
+const (
+	maxAnnotationField = 256
+	maxAnnotationNotes = 4096
+	maxAnnotationTags  = 32
+)
+
+// Normalize validates a and brings it into the stored form: the MAC in canonical notation,
+// fields trimmed and tags sorted without duplicates.
+func (a *Annotation) Normalize() error {
+	mac, err := ParseMAC(a.MAC)
+	if err != nil {
+		return err
+	}
+	a.MAC = mac
+
+	for _, f := range []struct {
+		name  string
+		value *string
+	}{{"name", &a.Name}, {"owner", &a.Owner}, {"location", &a.Location}} {
+		*f.value = strings.TrimSpace(*f.value)
+		if err := checkAnnotationText(f.name, *f.value, maxAnnotationField, false); err != nil {
+			return err
+		}
+	}
+	a.Notes = strings.TrimSpace(a.Notes)
+	if err := checkAnnotationText("notes", a.Notes, maxAnnotationNotes, true); err != nil {
+		return err
+	}
+
+	tags := make(map[string]bool)
+	for _, tag := range a.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return errors.New("empty tag")
+		}
+		if err := checkAnnotationText("tag", tag, maxAnnotationField, false); err != nil {
+			return err
+		}
+		tags[tag] = true
+	}
+	if len(tags) > maxAnnotationTags {
+		return fmt.Errorf("more than %d tags", maxAnnotationTags)
+	}
+	a.Tags = nil
+	for tag := range tags {
+		a.Tags = append(a.Tags, tag)
+	}
+	sort.Strings(a.Tags)
+	return nil
+}
+
+// clone returns a copy of a that shares no tags with it.
+func (a Annotation) clone() *Annotation {
+	a.Tags = append([]string(nil), a.Tags...)
+	return &a
+}
+
+func checkAnnotationText(field, value string, maxLen int, multiline bool) error {
+	if len([]rune(value)) > maxLen {
+		return fmt.Errorf("%s is longer than %d characters", field, maxLen)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) && !(multiline && (r == '\n' || r == '\t')) {
+			return fmt.Errorf("%s contains control characters", field)
+		}
+	}
+	return nil
+}
+
+// SetAnnotation stores a, replacing the previous annotation of its MAC, and returns it as stored.
+// A MAC may be annotated before it was ever seen.
+func SetAnnotation(db *sql.DB, a Annotation) (Annotation, error) {
+	if err := a.Normalize(); err != nil {
+		return a, err
+	}
+	a.UpdatedAt = time.Now().UTC()
+	tags, err := json.Marshal(a.Tags)
+	if err != nil {
+		return a, err
+	}
+	_, err = db.Exec(`
+        INSERT INTO device_annotations (mac, name, owner, location, notes, tags, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (mac) DO UPDATE SET
+            name = excluded.name,
+            owner = excluded.owner,
+            location = excluded.location,
+            notes = excluded.notes,
+            tags = excluded.tags,
+            updated_at = excluded.updated_at
+        `, a.MAC, a.Name, a.Owner, a.Location, a.Notes, string(tags), dbTime(a.UpdatedAt))
+	return a, err
+}
+
+// GetAnnotation returns the annotation of mac, nil if it has none.
+func GetAnnotation(db *sql.DB, mac string) (*Annotation, error) {
+	row := db.QueryRow(`
+        SELECT mac, name, owner, location, notes, tags, updated_at FROM device_annotations WHERE mac = ?
+        `, mac)
+	a, err := scanAnnotation(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// DeleteAnnotation removes the annotation of mac and reports whether there was one.
+func DeleteAnnotation(db *sql.DB, mac string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM device_annotations WHERE mac = ?`, mac)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func scanAnnotation(row interface{ Scan(...any) error }) (Annotation, error) {
+	var a Annotation
+	var tags string
+	if err := row.Scan(&a.MAC, &a.Name, &a.Owner, &a.Location, &a.Notes, &tags, &a.UpdatedAt); err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal([]byte(tags), &a.Tags); err != nil {
+		return a, fmt.Errorf("tags of %s: %w", a.MAC, err)
+	}
+	return a, nil
+}
+
+// addAnnotations attaches the annotations of the entries in macMap.
+func addAnnotations(db *sql.DB, macMap map[string]*ArpEntry, skipped *skippedRows) error {
+	if len(macMap) == 0 {
+		return nil
+	}
+	// annotations are few compared to devices, reading them all beats a query per MAC
+	rows, err := db.Query(`SELECT mac, name, owner, location, notes, tags, updated_at FROM device_annotations`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		a, err := scanAnnotation(rows)
+		if err != nil {
+			skipped.add(err)
+			continue
+		}
+		if entry, exists := macMap[a.MAC]; exists {
+			entry.Annotation = &a
+		}
+	}
+	return rows.Err()
+}
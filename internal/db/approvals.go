@@ -0,0 +1,156 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Review states of a device. Every device is unknown until someone approves or rejects it.
+const (
+	DeviceUnknown  = "unknown"
+	DeviceApproved = "approved"
+	DeviceRejected = "rejected"
+)
+
+// Device is the review state of a MAC. Devices may be approved or rejected before they are seen,
+// their sighting times are nil then.
+type Device struct {
+	MAC       string     `json:"mac"`
+	Status    string     `json:"status"`
+	Comment   string     `json:"comment,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+}
+
+// CheckDeviceStatus validates a status filter, "" matches every device.
+func CheckDeviceStatus(status string) error {
+	switch status {
+	case "", DeviceUnknown, DeviceApproved, DeviceRejected:
+		return nil
+	}
+	return fmt.Errorf("unknown device status %q, use %s, %s or %s", status, DeviceUnknown, DeviceApproved, DeviceRejected)
+}
+
+// NormalizeDecision validates a decision on mac and returns the MAC in canonical notation and
+// the trimmed comment. Only approved and rejected are decisions.
+func NormalizeDecision(mac, status, comment string) (string, string, error) {
+	mac, err := ParseMAC(mac)
+	if err != nil {
+		return "", "", err
+	}
+	if status != DeviceApproved && status != DeviceRejected {
+		return "", "", fmt.Errorf("a device can only be %s or %s, not %q", DeviceApproved, DeviceRejected, status)
+	}
+	comment = strings.TrimSpace(comment)
+	if err := checkAnnotationText("comment", comment, maxAnnotationField, false); err != nil {
+		return "", "", err
+	}
+	return mac, comment, nil
+}
+
+// SetDeviceStatus approves or rejects mac, replacing an earlier decision, and returns the device.
+func SetDeviceStatus(db *sql.DB, mac, status, comment string) (Device, error) {
+	mac, comment, err := NormalizeDecision(mac, status, comment)
+	if err != nil {
+		return Device{}, err
+	}
+	if _, err := db.Exec(`
+        INSERT INTO device_approvals (mac, status, comment, decided_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (mac) DO UPDATE SET
+            status = excluded.status,
+            comment = excluded.comment,
+            decided_at = excluded.decided_at
+        `, mac, status, comment, dbTime(time.Now())); err != nil {
+		return Device{}, err
+	}
+	device, err := GetDevice(db, mac)
+	if err != nil {
+		return Device{}, err
+	}
+	return *device, nil
+}
+
+// devicesQuery selects all seen devices with their decision, followed by the decisions on devices
+// never seen.
+const devicesQuery = `
+    SELECT d.mac AS mac, COALESCE(a.status, ?) AS status, COALESCE(a.comment, '') AS comment,
+        a.decided_at AS decided_at, d.first_seen AS first_seen, d.last_seen AS last_seen
+    FROM devices d LEFT JOIN device_approvals a ON a.mac = d.mac
+    UNION ALL
+    SELECT a.mac, a.status, a.comment, a.decided_at, NULL, NULL
+    FROM device_approvals a WHERE a.mac NOT IN (SELECT mac FROM devices)
+    `
+
+// GetDevice returns the review state of mac, nil if it was neither seen nor decided on.
+func GetDevice(db *sql.DB, mac string) (*Device, error) {
+	devices, err := queryDevices(db, `SELECT * FROM (`+devicesQuery+`) WHERE mac = ?`, DeviceUnknown, mac)
+	if len(devices) == 0 {
+		return nil, err
+	}
+	return &devices[0], err
+}
+
+// GetDevices returns all devices with the given status ("" for all), ordered by MAC.
+func GetDevices(db *sql.DB, status string) ([]Device, error) {
+	if err := CheckDeviceStatus(status); err != nil {
+		return nil, err
+	}
+	return queryDevices(db, `SELECT * FROM (`+devicesQuery+`) WHERE ? = '' OR status = ? ORDER BY mac`,
+		DeviceUnknown, status, status)
+}
+
+func queryDevices(db *sql.DB, query string, args ...any) ([]Device, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	devices := []Device{}
+	var skipped skippedRows
+	for rows.Next() {
+		var d Device
+		// the union loses the column types, so the driver returns the timestamps as text; NULL
+		// for undecided or unseen devices
+		var decidedAt, firstSeen, lastSeen sql.NullString
+		if err := rows.Scan(&d.MAC, &d.Status, &d.Comment, &decidedAt, &firstSeen, &lastSeen); err != nil {
+			skipped.add(err)
+			continue
+		}
+		var ok bool
+		if d.DecidedAt, ok = nullTime(decidedAt); !ok {
+			skipped.add(fmt.Errorf("invalid decided_at %q of %s", decidedAt.String, d.MAC))
+			continue
+		}
+		if d.FirstSeen, ok = nullTime(firstSeen); !ok {
+			skipped.add(fmt.Errorf("invalid first_seen %q of %s", firstSeen.String, d.MAC))
+			continue
+		}
+		if d.LastSeen, ok = nullTime(lastSeen); !ok {
+			skipped.add(fmt.Errorf("invalid last_seen %q of %s", lastSeen.String, d.MAC))
+			continue
+		}
+		devices = append(devices, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return devices, skipped.err()
+}
+
+// nullTime parses a nullable stored timestamp, reporting false if it is invalid.
+func nullTime(s sql.NullString) (*time.Time, bool) {
+	if !s.Valid {
+		return nil, true
+	}
+	t, ok := parseDBTime(s.String)
+	return &t, ok
+}
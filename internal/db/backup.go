@@ -0,0 +1,82 @@
+package db
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Backuper is implemented by stores that can write a consistent snapshot of their data.
+type Backuper interface {
+	// Backup writes a snapshot to the file at path, which must not exist.
+	Backup(path string) error
+}
+
+var _ Backuper = (*SQLiteStore)(nil)
+
+// Backup writes a consistent snapshot of db to path with VACUUM INTO. It is safe to run while
+// other connections keep writing, unlike copying the database file. path must not exist.
+func Backup(db *sql.DB, path string) error {
+	_, err := db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+func (s *SQLiteStore) Backup(path string) error {
+	return Backup(s.DB, path)
+}
+
+// WriteBackup snapshots b into a temporary file and copies it to w, gzip compressed if compress is set.
+func WriteBackup(b Backuper, w io.Writer, compress bool) error {
+	dir, err := os.MkdirTemp("", "arpmonitor-backup")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("failed to remove backup snapshot: %v", err)
+		}
+	}()
+
+	snapshot := filepath.Join(dir, "arpmonitor.db")
+	if err := b.Backup(snapshot); err != nil {
+		return err
+	}
+	f, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("failed to close backup snapshot: %v", err)
+		}
+	}()
+
+	if !compress {
+		_, err = io.Copy(w, f)
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	if _, err := io.Copy(zw, f); err != nil {
+		_ = zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// BackupFile writes a snapshot of the database at path to w without migrating or changing it,
+// for backups from outside the running arpmonitor.
+func BackupFile(path string, w io.Writer, compress bool) error {
+	db, err := OpenReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("failed to close database: %v", err)
+		}
+	}()
+	return WriteBackup(&SQLiteStore{DB: db}, w, compress)
+}
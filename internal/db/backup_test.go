@@ -0,0 +1,97 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestBackup_WhileWriting(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewSQLiteStore(filepath.Join(dir, "arpmonitor.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	defer func() { _ = store.Close() }()
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if err := store.InsertARPEvent("192.168.1.11", "66:77:88:99:aa:bb"); err != nil {
+					t.Errorf("InsertARPEvent during backup failed: %v", err)
+					return
+				}
+			}
+		}
+	}()
+	snapshot := filepath.Join(dir, "snapshot.db")
+	err = store.Backup(snapshot)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	db := openTestDB(t, snapshot)
+	var integrity string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&integrity); err != nil || integrity != "ok" {
+		t.Errorf("integrity_check = %q, %v", integrity, err)
+	}
+	version, err := SchemaVersion(db)
+	if err != nil || version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() = %d, %v", version, err)
+	}
+	entries, err := GetRecentEntries(db, 1)
+	if err != nil || len(entries) == 0 || entries[0].MAC != "00:11:22:33:44:55" {
+		t.Errorf("GetRecentEntries() = %+v, %v", entries, err)
+	}
+
+	if err := store.Backup(snapshot); err == nil {
+		t.Error("expected Backup to refuse an existing file")
+	}
+}
+
+func TestBackupFile_Gzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arpmonitor.db")
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := BackupFile(path, &buf, true); err != nil {
+		t.Fatalf("BackupFile failed: %v", err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("not gzip: %v", err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || !bytes.HasPrefix(data, []byte("SQLite format 3\x00")) {
+		t.Fatalf("expected an SQLite database, got %d bytes, %v", len(data), err)
+	}
+
+	if err := BackupFile(filepath.Join(t.TempDir(), "missing.db"), io.Discard, false); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for missing database, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// defaultBenchRows is the size of the synthetic arp_events table, the millions of events a busy
+// network collects within the retention period. ARPMONITOR_BENCH_ROWS overrides it for a quicker
+// run:
+//
+//	ARPMONITOR_BENCH_ROWS=200000 go test ./internal/db -run '^$' -bench GetRecentEntries
+const defaultBenchRows = 3000000
+
+const (
+	benchMACs = 5000
+	benchDays = 60
+)
+
+// openBenchDB returns a database with benchRows events of benchMACs devices spread over the last
+// benchDays. It is kept in the temp directory, generating millions of rows takes a while.
+func openBenchDB(b *testing.B) *sql.DB {
+	b.Helper()
+	rows := defaultBenchRows
+	if env := os.Getenv("ARPMONITOR_BENCH_ROWS"); env != "" {
+		var err error
+		if rows, err = strconv.Atoi(env); err != nil {
+			b.Fatalf("invalid ARPMONITOR_BENCH_ROWS: %v", err)
+		}
+	}
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("arpmonitor-bench-%d-v%d.db", rows, LatestSchemaVersion()))
+
+	db, err := InitDB(path)
+	if err != nil {
+		b.Fatalf("InitDB failed: %v", err)
+	}
+	b.Cleanup(func() { _ = db.Close() })
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM arp_events`).Scan(&count); err != nil {
+		b.Fatalf("count events: %v", err)
+	}
+	if count == rows {
+		return db
+	}
+	if count != 0 {
+		b.Fatalf("%s has %d events instead of %d, remove it", path, count, rows)
+	}
+
+	b.Logf("generating %d events in %s", rows, path)
+	if err := fillBenchDB(db, rows); err != nil {
+		_ = os.Remove(path)
+		b.Fatalf("generate: %v", err)
+	}
+	return db
+}
+
+func fillBenchDB(db *sql.DB, rows int) error {
+	rnd := rand.New(rand.NewSource(1))
+	now := time.Now()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	stmt, err := tx.Prepare(`INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < rows; i++ {
+		n := rnd.Intn(benchMACs)
+		mac := fmt.Sprintf("02:00:00:00:%02x:%02x", n>>8, n&0xff)
+		ip, ipType := fmt.Sprintf("10.0.%d.%d", n>>8, n&0xff), "ipv4"
+		if rnd.Intn(4) == 0 {
+			ip, ipType = fmt.Sprintf("fe80::%x", n), "ipv6"
+		}
+		seenAt := now.Add(-time.Duration(rnd.Int63n(int64(benchDays * 24 * time.Hour))))
+		if _, err := stmt.Exec(ip, ipType, mac, dbTime(seenAt)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+        INSERT INTO device_addresses (mac, ip, ip_type, first_seen, last_seen, count)
+        SELECT mac, ip, ip_type, MIN(seen_at), MAX(seen_at), COUNT(*) FROM arp_events GROUP BY mac, ip;
+        INSERT INTO devices (mac, first_seen, last_seen)
+        SELECT mac, MIN(first_seen), MAX(last_seen) FROM device_addresses GROUP BY mac;
+        `); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getRecentEntriesUngrouped is GetRecentEntries before grouping in SQL: every sighting in the
+// window is scanned and merged in Go. It is kept as a reference for the benchmarks.
+func getRecentEntriesUngrouped(db *sql.DB, days int) ([]ArpEntry, error) {
+	from, to := dbTime(daysAgo(days)), rangeEnd(time.Time{})
+	rows, err := db.Query(`
+        SELECT mac, ip, ip_type, seen_at FROM arp_events WHERE seen_at >= ?
+        UNION ALL
+        SELECT mac, ip, ip_type, last_seen FROM arp_rollups WHERE last_seen >= ?
+        order by mac,seen_at desc
+        `, from, from)
+	if err != nil {
+		return nil, err
+	}
+	macMap := make(map[string]*ArpEntry)
+	for rows.Next() {
+		var mac, ip, ipType string
+		var seenAt time.Time
+		if err := rows.Scan(&mac, &ip, &ipType, &seenAt); err != nil {
+			continue
+		}
+		addSighting(macMap, mac, ip, ipType, seenAt)
+	}
+	_ = rows.Close()
+	if err := addDeviceDetails(db, macMap, from, to, false, &skippedRows{}); err != nil {
+		return nil, err
+	}
+	return sortedEntries(macMap), nil
+}
+
+func TestGetRecentEntries_MatchesUngrouped(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	if err := fillBenchDB(db, 2000); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	for _, days := range []int{1, 7, benchDays} {
+		want, err := getRecentEntriesUngrouped(db, days)
+		if err != nil {
+			t.Fatalf("getRecentEntriesUngrouped failed: %v", err)
+		}
+		got, err := GetRecentEntries(db, days)
+		if err != nil {
+			t.Fatalf("GetRecentEntries failed: %v", err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("days=%d: result differs from the reference (%d vs %d entries)", days, len(got), len(want))
+		}
+		bounded, err := GetEntries(db, daysAgo(days), time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("GetEntries failed: %v", err)
+		}
+		if fmt.Sprint(bounded) != fmt.Sprint(want) {
+			t.Errorf("days=%d: grouped result differs from the reference (%d vs %d entries)", days, len(bounded), len(want))
+		}
+	}
+}
+
+func BenchmarkGetRecentEntries(b *testing.B) {
+	db := openBenchDB(b)
+	for _, days := range []int{1, 7} {
+		b.Run(fmt.Sprintf("days=%d", days), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := GetRecentEntries(db, days); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("days=%d/bounded", days), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := GetEntries(db, daysAgo(days), time.Now().Add(time.Hour)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("days=%d/ungrouped", days), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := getRecentEntriesUngrouped(db, days); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInsertEvent(b *testing.B) {
+	db, err := InitDB(filepath.Join(b.TempDir(), "insert.db"))
+	if err != nil {
+		b.Fatalf("InitDB failed: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+	now := time.Now()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := i % benchMACs
+		mac := fmt.Sprintf("02:00:00:00:%02x:%02x", n>>8, n&0xff)
+		if err := insertEvent(db, fmt.Sprintf("10.0.%d.%d", n>>8, n&0xff), "ipv4", mac, now.Add(time.Duration(i)*time.Millisecond)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of Change.
+const (
+	ChangeNew      = "new"
+	ChangeIP       = "ip-changed"
+	ChangeHostname = "hostname-changed"
+	ChangeSilent   = "silent"
+)
+
+// Change is something that happened to a device: it was seen for the first time, was seen at
+// another address than before, got another hostname or went silent for SessionGap.
+type Change struct {
+	MAC  string `json:"mac"`
+	Type string `json:"type"`
+	// At is when the change happened, for silent devices when SessionGap passed after the last
+	// sighting
+	At time.Time `json:"at"`
+	// IP is the first address of a new device or the new address
+	IP string `json:"ip,omitempty"`
+	// PreviousIP is the address of the same family the device was seen at before
+	PreviousIP string `json:"previous_ip,omitempty"`
+	Hostname   string `json:"hostname,omitempty"`
+	// PreviousHostname is set for hostname changes
+	PreviousHostname string `json:"previous_hostname,omitempty"`
+}
+
+// recordAddressChange records a change if mac was last seen at another address of the family of
+// ip. Sightings older than the last one of the family arrive late and change nothing.
+func recordAddressChange(tx *sql.Tx, ip, ipType, mac string, seenAt time.Time) error {
+	var previous string
+	var lastSeen time.Time
+	err := tx.QueryRow(`
+        SELECT ip, last_seen FROM device_addresses WHERE mac = ? AND ip_type = ?
+        ORDER BY last_seen DESC LIMIT 1
+        `, mac, ipType).Scan(&previous, &lastSeen)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil || previous == ip || seenAt.Before(lastSeen) {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO address_changes (mac, ip, previous_ip, changed_at) VALUES (?, ?, ?, ?)`,
+		mac, ip, previous, dbTime(seenAt))
+	return err
+}
+
+// RecordHostname remembers the hostname mac resolved to, so GetChanges can report when it
+// changes. The first hostname of a MAC is no change.
+func RecordHostname(db *sql.DB, mac, hostname, source string) error {
+	_, err := db.Exec(`
+        INSERT INTO hostnames (mac, hostname, source, previous, changed_at) VALUES (?, ?, ?, '', NULL)
+        ON CONFLICT (mac) DO UPDATE SET
+            previous = CASE WHEN hostname = excluded.hostname THEN previous ELSE hostname END,
+            changed_at = CASE WHEN hostname = excluded.hostname THEN changed_at ELSE ? END,
+            hostname = excluded.hostname,
+            source = excluded.source
+        `, mac, hostname, source, dbTime(time.Now()))
+	return err
+}
+
+// FindHostname returns the MACs whose annotated name or last recorded hostname is name, ignoring
+// case and a trailing dot, ordered by MAC.
+func FindHostname(db *sql.DB, name string) ([]string, error) {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+	var skipped skippedRows
+	macs, err := queryStrings(db, &skipped, `
+        SELECT mac FROM device_annotations WHERE lower(rtrim(name, '.')) = ?
+        UNION SELECT mac FROM hostnames WHERE lower(rtrim(hostname, '.')) = ?
+        ORDER BY 1
+        `, name, name)
+	if err != nil {
+		return nil, err
+	}
+	return macs, skipped.err()
+}
+
+// GetChanges returns the changes since since, ordered by time, with the last recorded hostname
+// of the device.
+func GetChanges(db *sql.DB, since time.Time) ([]Change, error) {
+	from := dbTime(since)
+	now := time.Now()
+	var changes []Change
+	var skipped skippedRows
+
+	// a device's first address is the one it was first seen with
+	err := queryChanges(db, &changes, &skipped, func(rows *sql.Rows) (Change, error) {
+		c := Change{Type: ChangeNew}
+		var ip, hostname sql.NullString
+		err := rows.Scan(&c.MAC, &c.At, &ip, &hostname)
+		c.IP, c.Hostname = ip.String, hostname.String
+		return c, err
+	}, `
+        SELECT d.mac, d.first_seen,
+            (SELECT a.ip FROM device_addresses a WHERE a.mac = d.mac ORDER BY a.first_seen LIMIT 1),
+            h.hostname
+        FROM devices d LEFT JOIN hostnames h ON h.mac = d.mac WHERE d.first_seen >= ?
+        `, from)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryChanges(db, &changes, &skipped, func(rows *sql.Rows) (Change, error) {
+		c := Change{Type: ChangeIP}
+		var hostname sql.NullString
+		err := rows.Scan(&c.MAC, &c.IP, &c.PreviousIP, &c.At, &hostname)
+		c.Hostname = hostname.String
+		return c, err
+	}, `
+        SELECT c.mac, c.ip, c.previous_ip, c.changed_at, h.hostname
+        FROM address_changes c LEFT JOIN hostnames h ON h.mac = c.mac WHERE c.changed_at >= ?
+        `, from)
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryChanges(db, &changes, &skipped, func(rows *sql.Rows) (Change, error) {
+		c := Change{Type: ChangeHostname}
+		err := rows.Scan(&c.MAC, &c.Hostname, &c.PreviousHostname, &c.At)
+		return c, err
+	}, `SELECT mac, hostname, previous, changed_at FROM hostnames WHERE changed_at >= ?`, from)
+	if err != nil {
+		return nil, err
+	}
+
+	// silent since the gap after the last sighting passed, after since
+	err = queryChanges(db, &changes, &skipped, func(rows *sql.Rows) (Change, error) {
+		c := Change{Type: ChangeSilent}
+		var hostname sql.NullString
+		err := rows.Scan(&c.MAC, &c.At, &hostname)
+		c.At, c.Hostname = c.At.Add(SessionGap), hostname.String
+		return c, err
+	}, `
+        SELECT d.mac, d.last_seen, h.hostname
+        FROM devices d LEFT JOIN hostnames h ON h.mac = d.mac WHERE d.last_seen >= ? AND d.last_seen < ?
+        `,
+		dbTime(since.Add(-SessionGap)), dbTime(now.Add(-SessionGap)))
+	if err != nil {
+		return nil, err
+	}
+
+	sortChanges(changes)
+	return changes, skipped.err()
+}
+
+// queryChanges appends the changes scanned from the rows of query.
+func queryChanges(db *sql.DB, changes *[]Change, skipped *skippedRows, scan func(*sql.Rows) (Change, error), query string, args ...any) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		c, err := scan(rows)
+		if err != nil {
+			skipped.add(fmt.Errorf("%s change: %w", c.Type, err))
+			continue
+		}
+		*changes = append(*changes, c)
+	}
+	return rows.Err()
+}
+
+func sortChanges(changes []Change) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		if !changes[i].At.Equal(changes[j].At) {
+			return changes[i].At.Before(changes[j].At)
+		}
+		return changes[i].MAC < changes[j].MAC
+	})
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"sort"
+	"time"
+)
+
+type PacketCounts struct {
+	ARPPackets int64 `json:"arp_packets"`
+	NDPPackets int64 `json:"ndp_packets"`
+	Frames     int64 `json:"frames"`
+	Bytes      int64 `json:"bytes"`
+}
+
+func (c *PacketCounts) Add(o PacketCounts) {
+	c.ARPPackets += o.ARPPackets
+	c.NDPPackets += o.NDPPackets
+	c.Frames += o.Frames
+	c.Bytes += o.Bytes
+}
+
+type CounterKey struct {
+	MAC  string
+	Hour time.Time
+}
+
+type HourlyCounts struct {
+	Hour time.Time `json:"hour"`
+	PacketCounts
+}
+
+// MACCounters holds the totals of a MAC over the queried window and its hourly buckets, newest first.
+type MACCounters struct {
+	MAC string `json:"mac"`
+	PacketCounts
+	Hours []HourlyCounts `json:"hours"`
+}
+
+// AddPacketCounts adds the given counts to the hourly buckets in one transaction.
+func AddPacketCounts(db *sql.DB, counts map[CounterKey]PacketCounts) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`
+        INSERT INTO mac_counters (mac, hour, arp_packets, ndp_packets, frames, bytes)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (mac, hour) DO UPDATE SET
+            arp_packets = arp_packets + excluded.arp_packets,
+            ndp_packets = ndp_packets + excluded.ndp_packets,
+            frames = frames + excluded.frames,
+            bytes = bytes + excluded.bytes
+        `)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer func() {
+		if err := stmt.Close(); err != nil {
+			log.Printf("error closing statement: %v", err)
+		}
+	}()
+
+	for key, c := range counts {
+		if _, err := stmt.Exec(key.MAC, dbTime(key.Hour.UTC().Truncate(time.Hour)), c.ARPPackets, c.NDPPackets, c.Frames, c.Bytes); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetPacketCounts returns the counters of the last hours per MAC, busiest MACs (by ARP+NDP packets) first.
+func GetPacketCounts(db *sql.DB, hours int) ([]MACCounters, error) {
+	rows, err := db.Query(`
+        SELECT mac, hour, arp_packets, ndp_packets, frames, bytes FROM mac_counters
+        WHERE hour >= ? ORDER BY mac, hour DESC
+        `, dbTime(time.Now().Add(-time.Duration(hours)*time.Hour)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	macMap := make(map[string]*MACCounters)
+	var skipped skippedRows
+	for rows.Next() {
+		var mac string
+		var h HourlyCounts
+		if err := rows.Scan(&mac, &h.Hour, &h.ARPPackets, &h.NDPPackets, &h.Frames, &h.Bytes); err != nil {
+			skipped.add(err)
+			continue
+		}
+		addHourlyCounts(macMap, mac, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sortedCounters(macMap), skipped.err()
+}
+
+// addHourlyCounts merges one hour bucket into the counters of mac, buckets are passed newest first.
+func addHourlyCounts(macMap map[string]*MACCounters, mac string, h HourlyCounts) {
+	entry, exists := macMap[mac]
+	if !exists {
+		entry = &MACCounters{MAC: mac}
+		macMap[mac] = entry
+	}
+	entry.Add(h.PacketCounts)
+	entry.Hours = append(entry.Hours, h)
+}
+
+func sortedCounters(macMap map[string]*MACCounters) []MACCounters {
+	var result []MACCounters
+	for _, entry := range macMap {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		pi := result[i].ARPPackets + result[i].NDPPackets
+		pj := result[j].ARPPackets + result[j].NDPPackets
+		if pi != pj {
+			return pi > pj
+		}
+		return result[i].MAC < result[j].MAC
+	})
+	return result
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"log"
+	"testing"
+	"time"
+)
+
+func TestAddAndGetPacketCounts(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("failed to close database: %v", err)
+		}
+	}()
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	quiet, chatty := "00:11:22:33:44:55", "66:77:88:99:aa:bb"
+	if err := AddPacketCounts(db, map[CounterKey]PacketCounts{
+		{MAC: quiet, Hour: now}:                  {ARPPackets: 1, Frames: 1, Bytes: 60},
+		{MAC: chatty, Hour: now}:                 {ARPPackets: 100, NDPPackets: 5, Frames: 105, Bytes: 6300},
+		{MAC: chatty, Hour: now.Add(-time.Hour)}: {ARPPackets: 50, Frames: 50, Bytes: 3000},
+	}); err != nil {
+		t.Fatalf("AddPacketCounts failed: %v", err)
+	}
+	// counts of the same bucket are summed up
+	if err := AddPacketCounts(db, map[CounterKey]PacketCounts{
+		{MAC: quiet, Hour: now.Add(10 * time.Minute)}: {ARPPackets: 2, Frames: 2, Bytes: 120},
+	}); err != nil {
+		t.Fatalf("AddPacketCounts failed: %v", err)
+	}
+
+	counters, err := GetPacketCounts(db, 3)
+	if err != nil {
+		t.Fatalf("GetPacketCounts failed: %v", err)
+	}
+	if len(counters) != 2 {
+		t.Fatalf("expected 2 MACs, got %d: %+v", len(counters), counters)
+	}
+	if counters[0].MAC != chatty || counters[0].ARPPackets != 150 || counters[0].NDPPackets != 5 || len(counters[0].Hours) != 2 {
+		t.Errorf("unexpected counters for chatty MAC: %+v", counters[0])
+	}
+	if !counters[0].Hours[0].Hour.After(counters[0].Hours[1].Hour) {
+		t.Errorf("expected newest hour first: %+v", counters[0].Hours)
+	}
+	if counters[1].MAC != quiet || counters[1].ARPPackets != 3 || counters[1].Bytes != 180 || len(counters[1].Hours) != 1 {
+		t.Errorf("unexpected counters for quiet MAC: %+v", counters[1])
+	}
+}
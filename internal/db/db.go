@@ -5,22 +5,66 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os"
 	"sort"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type ArpEntry struct {
-	MAC      string    `json:"mac"`
-	IPv4     []string  `json:"ipv4,omitempty"`
-	IPv6     []string  `json:"ipv6,omitempty"`
-	Hostname string    `json:"hostname,omitempty"`
-	LastSeen time.Time `json:"last_seen"`
+	MAC       string        `json:"mac"`
+	IPv4      []string      `json:"ipv4,omitempty"`
+	IPv6      []string      `json:"ipv6,omitempty"`
+	Addresses []AddressInfo `json:"addresses,omitempty"`
+	Hostname  string        `json:"hostname,omitempty"`
+	// HostnameSource tells where the API resolved Hostname from: annotation, dns or kea-lease
+	HostnameSource string    `json:"hostname_source,omitempty"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	// Annotation is set for devices an admin annotated
+	Annotation *Annotation `json:"annotation,omitempty"`
+	// Interface is the capture interface of the last sighting, empty if it was not recorded
+	Interface string `json:"interface,omitempty"`
+}
+
+// AddressInfo describes one address of a device over its whole history.
+type AddressInfo struct {
+	IP        string    `json:"ip"`
+	Family    string    `json:"family"` // 'ipv4' or 'ipv6'
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int64     `json:"count"`
+	// Interface is the capture interface of the last sighting of the address
+	Interface string `json:"interface,omitempty"`
+}
+
+// busyTimeout is how long a connection waits for a lock held by another connection or process,
+// e.g. an import while the sniffer writes.
+const busyTimeout = 5 * time.Second
+
+// dsn adds the connection settings to path: WAL, so readers like the API never block the
+// sniffer and vice versa, the busy timeout, and secure_delete, so deleted rows (e.g. of a forgotten
+// device) are overwritten instead of staying in free pages.
+func dsn(path string) string {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%s_busy_timeout=%d&_journal_mode=WAL&_synchronous=NORMAL&_secure_delete=on", path, sep, busyTimeout.Milliseconds())
+}
+
+// OpenReadOnly opens the existing database at path without migrating or changing it.
+func OpenReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=%d", path, busyTimeout.Milliseconds()))
 }
 
 func InitDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	db, err := sql.Open("sqlite3", dsn(path))
 	if err != nil {
 		return nil, err
 	}
@@ -29,20 +73,14 @@ func InitDB(path string) (*sql.DB, error) {
 	return db, err
 }
 
+// CreateTable brings the schema up to date by applying all pending migrations.
 func CreateTable(db *sql.DB) error {
-	_, err := db.Exec(`
-        CREATE TABLE IF NOT EXISTS arp_events (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-            ip TEXT NOT NULL,
-            ip_type TEXT NOT NULL,   -- 'ipv4' or 'ipv6'
-            mac TEXT NOT NULL,
-            seen_at DATETIME DEFAULT CURRENT_TIMESTAMP
-        );
-    `)
+	_, err := Migrate(db)
 	return err
 }
 
-func InsertARPEvent(db *sql.DB, ip, mac string) {
+// InsertARPEvent records a sighting of ip at mac now.
+func InsertARPEvent(db *sql.DB, ip, mac string) error {
 	// Bestimmen, ob es sich um IPv4 oder IPv6 handelt
 	var ipType string
 	if net.ParseIP(ip).To4() != nil {
@@ -51,18 +89,70 @@ func InsertARPEvent(db *sql.DB, ip, mac string) {
 		ipType = "ipv6"
 	}
 
-	_, err := db.Exec(`INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES (?, ?, ?, ?)`,
-		ip, ipType, mac, time.Now())
+	return insertEvent(db, ip, ipType, mac, time.Now())
+}
+
+// insertEvent stores a raw sighting and updates the devices, device_addresses and sessions summaries.
+func insertEvent(db *sql.DB, ip, ipType, mac string, seenAt time.Time) error {
+	tx, err := db.Begin()
 	if err != nil {
-		log.Println("DB Fehler:", err)
+		return err
 	}
+	if _, err := tx.Exec(`INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES (?, ?, ?, ?)`,
+		ip, ipType, mac, dbTime(seenAt)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := recordAddressChange(tx, ip, ipType, mac, seenAt); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := upsertDevice(tx, ip, ipType, mac, CaptureInterface, seenAt); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := recordSession(tx, mac, seenAt); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 func GetRecentEntries(db *sql.DB, days int) ([]ArpEntry, error) {
+	return GetEntries(db, daysAgo(days), time.Time{})
+}
+
+// GetEntries returns all devices seen between since and until, ordered by MAC. A zero until
+// leaves the range open ended. Rows that cannot be read are left out, the result then comes with
+// a *PartialError.
+func GetEntries(db *sql.DB, since, until time.Time) ([]ArpEntry, error) {
+	from, to := dbTime(since), rangeEnd(until)
+	macMap := make(map[string]*ArpEntry)
+	var skipped skippedRows
+	if until.IsZero() {
+		// device_addresses already holds the last sighting per MAC and address
+		if err := addDeviceDetails(db, macMap, from, to, true, &skipped); err != nil {
+			return nil, err
+		}
+		if err := addAnnotations(db, macMap, &skipped); err != nil {
+			return nil, err
+		}
+		return sortedEntries(macMap), skipped.err()
+	}
+
+	// one row per MAC and address with its last sighting in the range, newest first per MAC;
+	// rolled up events count as seen at their last sighting in the range
 	rows, err := db.Query(`
-        SELECT mac, ip, ip_type, seen_at FROM arp_events
-        WHERE seen_at >= datetime('now', ?) order by mac,seen_at desc
-        `, fmt.Sprintf("-%d days", days))
+        SELECT mac, ip, ip_type, MAX(seen_at) AS last_seen FROM (
+            SELECT mac, ip, ip_type, seen_at FROM arp_events
+            WHERE seen_at >= ? AND seen_at <= ?
+            UNION ALL
+            SELECT mac, ip, ip_type, CASE WHEN last_seen <= ? THEN last_seen ELSE first_seen END FROM arp_rollups
+            WHERE (last_seen >= ? AND last_seen <= ?) OR (first_seen >= ? AND first_seen <= ?)
+        )
+        GROUP BY mac, ip
+        ORDER BY mac, last_seen DESC
+        `, from, to, to, from, to, from, to)
 	if err != nil {
 		return nil, err
 	}
@@ -72,35 +162,59 @@ func GetRecentEntries(db *sql.DB, days int) ([]ArpEntry, error) {
 		}
 	}()
 
-	macMap := make(map[string]*ArpEntry)
-
 	for rows.Next() {
-		var mac, ip, ipType string
-		var seenAt time.Time
-		if err := rows.Scan(&mac, &ip, &ipType, &seenAt); err != nil {
+		// aggregates lose the column type, so the driver returns the timestamp as text
+		var mac, ip, ipType, lastSeen string
+		if err := rows.Scan(&mac, &ip, &ipType, &lastSeen); err != nil {
+			skipped.add(err)
 			continue
 		}
-
-		entry, exists := macMap[mac]
-		if !exists {
-			entry = &ArpEntry{
-				MAC: mac,
-			}
-			macMap[mac] = entry
+		seenAt, ok := parseDBTime(lastSeen)
+		if !ok {
+			skipped.add(fmt.Errorf("invalid last sighting %q of %s at %s", lastSeen, ip, mac))
+			continue
 		}
 
-		switch ipType {
-		case "ipv4":
-			entry.IPv4 = addIfNotExists(entry.IPv4, ip)
-		case "ipv6":
-			entry.IPv6 = addIfNotExists(entry.IPv6, ip)
-		}
+		addSighting(macMap, mac, ip, ipType, seenAt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		if seenAt.After(entry.LastSeen) {
-			entry.LastSeen = seenAt
+	if err := addDeviceDetails(db, macMap, from, to, false, &skipped); err != nil {
+		return nil, err
+	}
+	if err := addAnnotations(db, macMap, &skipped); err != nil {
+		return nil, err
+	}
+
+	return sortedEntries(macMap), skipped.err()
+}
+
+// addSighting merges one sighting into the entry of its MAC. Sightings must be passed newest
+// first per MAC, so the address lists end up ordered by their last sighting.
+func addSighting(macMap map[string]*ArpEntry, mac, ip, ipType string, seenAt time.Time) {
+	entry, exists := macMap[mac]
+	if !exists {
+		entry = &ArpEntry{
+			MAC: mac,
 		}
+		macMap[mac] = entry
+	}
+
+	switch ipType {
+	case "ipv4":
+		entry.IPv4 = addIfNotExists(entry.IPv4, ip)
+	case "ipv6":
+		entry.IPv6 = addIfNotExists(entry.IPv6, ip)
 	}
 
+	if seenAt.After(entry.LastSeen) {
+		entry.LastSeen = seenAt
+	}
+}
+
+func sortedEntries(macMap map[string]*ArpEntry) []ArpEntry {
 	var result []ArpEntry
 	for _, entry := range macMap {
 		result = append(result, *entry)
@@ -108,8 +222,7 @@ func GetRecentEntries(db *sql.DB, days int) ([]ArpEntry, error) {
 	sort.Slice(result, func(i, j int) bool {
 		return result[i].MAC < result[j].MAC
 	})
-
-	return result, nil
+	return result
 }
 
 func addIfNotExists[T comparable](slice []T, item T) []T {
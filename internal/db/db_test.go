@@ -33,9 +33,15 @@ func TestInsertAndGetRecentEntries(t *testing.T) {
 		}
 	}()
 	// Insert IPv4 and IPv6 events
-	InsertARPEvent(db, "192.168.1.10", "00:11:22:33:44:55")
-	InsertARPEvent(db, "fe80::1", "00:11:22:33:44:55")
-	InsertARPEvent(db, "192.168.1.11", "66:77:88:99:AA:BB")
+	for _, e := range []struct{ ip, mac string }{
+		{"192.168.1.10", "00:11:22:33:44:55"},
+		{"fe80::1", "00:11:22:33:44:55"},
+		{"192.168.1.11", "66:77:88:99:AA:BB"},
+	} {
+		if err := InsertARPEvent(db, e.ip, e.mac); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
 
 	entries, err := GetRecentEntries(db, 1)
 	if err != nil {
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// ParseMAC accepts the common notations of a MAC address, 00:11:22:33:44:55, 00-11-22-33-44-55,
+// 0011.2233.4455 and 001122334455 in any case, and returns it in canonical notation.
+func ParseMAC(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if len(s) == 12 {
+		if b, err := hex.DecodeString(s); err == nil {
+			return net.HardwareAddr(b).String(), nil
+		}
+	}
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return "", err
+	}
+	return mac.String(), nil
+}
+
+// CaptureInterface is the interface the sniffer captures on, recorded with every sighting.
+var CaptureInterface string
+
+func upsertDevice(tx *sql.Tx, ip, ipType, mac, iface string, seenAt time.Time) error {
+	return upsertDeviceRange(tx, ip, ipType, mac, iface, seenAt, seenAt, 1)
+}
+
+// upsertDeviceRange widens the devices and device_addresses history of mac and ip to firstSeen
+// and lastSeen and adds count sightings. A non-empty iface replaces the interface of the address
+// unless it was seen later.
+func upsertDeviceRange(tx *sql.Tx, ip, ipType, mac, iface string, firstSeen, lastSeen time.Time, count int64) error {
+	if _, err := tx.Exec(`
+        INSERT INTO devices (mac, first_seen, last_seen) VALUES (?, ?, ?)
+        ON CONFLICT (mac) DO UPDATE SET
+            first_seen = MIN(first_seen, excluded.first_seen),
+            last_seen = MAX(last_seen, excluded.last_seen)
+        `, mac, dbTime(firstSeen), dbTime(lastSeen)); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+        INSERT INTO device_addresses (mac, ip, ip_type, interface, first_seen, last_seen, count) VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (mac, ip) DO UPDATE SET
+            interface = CASE WHEN excluded.interface != '' AND excluded.last_seen >= last_seen
+                THEN excluded.interface ELSE interface END,
+            first_seen = MIN(first_seen, excluded.first_seen),
+            last_seen = MAX(last_seen, excluded.last_seen),
+            count = count + excluded.count
+        `, mac, ip, ipType, iface, dbTime(firstSeen), dbTime(lastSeen), count)
+	return err
+}
+
+// addDeviceDetails sets first_seen and the per-address history of the entries from the devices model.
+// Addresses are ordered by their last sighting, the current one first.
+// With sightings set, the last sighting of every address also counts as a sighting of the entry,
+// which is the same as GROUP BY mac, ip over all events and rollups for an open ended range.
+// Unreadable rows are added to skipped, only failing queries are returned.
+func addDeviceDetails(db *sql.DB, macMap map[string]*ArpEntry, from, to string, sightings bool, skipped *skippedRows) error {
+	if len(macMap) == 0 && !sightings {
+		return nil
+	}
+	rows, err := db.Query(`
+        SELECT a.mac, a.ip, a.ip_type, a.first_seen, a.last_seen, a.count, a.interface, d.first_seen
+        FROM device_addresses a JOIN devices d ON d.mac = a.mac
+        WHERE a.last_seen >= ? AND a.first_seen <= ?
+        ORDER BY a.mac, a.last_seen DESC
+        `, from, to)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	for rows.Next() {
+		var mac string
+		var addr AddressInfo
+		var deviceFirstSeen time.Time
+		if err := rows.Scan(&mac, &addr.IP, &addr.Family, &addr.FirstSeen, &addr.LastSeen, &addr.Count, &addr.Interface, &deviceFirstSeen); err != nil {
+			skipped.add(err)
+			continue
+		}
+		if sightings {
+			addSighting(macMap, mac, addr.IP, addr.Family, addr.LastSeen)
+		}
+		addAddress(macMap, mac, addr, deviceFirstSeen)
+	}
+	return rows.Err()
+}
+
+// addAddress attaches the history of an address to the entry of mac, if the entry contains it.
+// Addresses must be passed ordered by their last sighting, newest first.
+func addAddress(macMap map[string]*ArpEntry, mac string, addr AddressInfo, deviceFirstSeen time.Time) {
+	entry, exists := macMap[mac]
+	if !exists || !entry.hasIP(addr.IP) {
+		return
+	}
+	entry.FirstSeen = deviceFirstSeen
+	if len(entry.Addresses) == 0 {
+		entry.Interface = addr.Interface
+	}
+	entry.Addresses = append(entry.Addresses, addr)
+}
+
+func (e *ArpEntry) hasIP(ip string) bool {
+	for _, v := range e.IPv4 {
+		if v == ip {
+			return true
+		}
+	}
+	for _, v := range e.IPv6 {
+		if v == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceCount is the number of devices seen on a capture interface with an address of a family.
+type DeviceCount struct {
+	Interface string // empty for addresses last seen before the interface was recorded
+	Family    string
+	Devices   int
+}
+
+// CountDevices returns per capture interface and IP family the number of devices with an address
+// of the family seen on the interface since since, without reading the devices.
+func CountDevices(db *sql.DB, since time.Time) ([]DeviceCount, error) {
+	rows, err := db.Query(`
+        SELECT interface, ip_type, COUNT(DISTINCT mac) FROM device_addresses
+        WHERE last_seen >= ? GROUP BY interface, ip_type ORDER BY interface, ip_type
+        `, dbTime(since))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	var counts []DeviceCount
+	for rows.Next() {
+		var c DeviceCount
+		if err := rows.Scan(&c.Interface, &c.Family, &c.Devices); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// GetEntry returns mac with all addresses it ever used, the current one first, nil if it was never
+// seen. Older versions did not normalize every MAC, so mac matches in any case.
+func GetEntry(db *sql.DB, mac string) (*ArpEntry, error) {
+	rows, err := db.Query(`
+        SELECT a.mac, a.ip, a.ip_type, a.first_seen, a.last_seen, a.count, a.interface, d.first_seen
+        FROM device_addresses a JOIN devices d ON d.mac = a.mac
+        WHERE lower(a.mac) = lower(?)
+        ORDER BY a.mac, a.last_seen DESC
+        `, mac)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	macMap := make(map[string]*ArpEntry)
+	var skipped skippedRows
+	for rows.Next() {
+		var stored string
+		var addr AddressInfo
+		var deviceFirstSeen time.Time
+		if err := rows.Scan(&stored, &addr.IP, &addr.Family, &addr.FirstSeen, &addr.LastSeen, &addr.Count, &addr.Interface, &deviceFirstSeen); err != nil {
+			skipped.add(err)
+			continue
+		}
+		addSighting(macMap, stored, addr.IP, addr.Family, addr.LastSeen)
+		addAddress(macMap, stored, addr, deviceFirstSeen)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := addAnnotations(db, macMap, &skipped); err != nil {
+		return nil, err
+	}
+
+	entries := sortedEntries(macMap)
+	if len(entries) == 0 {
+		return nil, skipped.err()
+	}
+	return &entries[0], skipped.err()
+}
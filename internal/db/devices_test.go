@@ -0,0 +1,101 @@
+package db
+
+import (
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInsertEvent_UpdatesDevices(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("failed to close database: %v", err)
+		}
+	}()
+
+	mac := "00:11:22:33:44:55"
+	now := time.Now()
+	for _, ev := range []struct {
+		ip, ipType string
+		at         time.Time
+	}{
+		{"192.168.1.10", "ipv4", now.Add(-3 * time.Hour)},
+		{"192.168.1.10", "ipv4", now.Add(-2 * time.Hour)},
+		{"fe80::1", "ipv6", now.Add(-90 * time.Minute)},
+		{"192.168.1.20", "ipv4", now.Add(-time.Hour)},
+	} {
+		if err := insertEvent(db, ev.ip, ev.ipType, mac, ev.at); err != nil {
+			t.Fatalf("insertEvent failed: %v", err)
+		}
+	}
+
+	entries, err := GetRecentEntries(db, 1)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("GetRecentEntries() = %+v, %v", entries, err)
+	}
+	e := entries[0]
+	if !e.FirstSeen.Equal(now.Add(-3 * time.Hour)) {
+		t.Errorf("expected first seen %v, got %v", now.Add(-3*time.Hour), e.FirstSeen)
+	}
+	if len(e.Addresses) != 3 {
+		t.Fatalf("expected 3 addresses, got %+v", e.Addresses)
+	}
+	current := e.Addresses[0]
+	if current.IP != "192.168.1.20" || current.Family != "ipv4" || current.Count != 1 {
+		t.Errorf("expected 192.168.1.20 as current address, got %+v", current)
+	}
+	old := e.Addresses[2]
+	if old.IP != "192.168.1.10" || old.Count != 2 || !old.FirstSeen.Equal(now.Add(-3*time.Hour)) ||
+		!old.LastSeen.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("unexpected history of 192.168.1.10: %+v", old)
+	}
+}
+
+func TestMigrate_BackfillsDevices(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "devices.db"))
+	migrateTo(t, db, 2)
+
+	now := time.Now()
+	if _, err := db.Exec(`INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES (?, 'ipv4', ?, ?), (?, 'ipv4', ?, ?)`,
+		"192.168.1.10", "00:11:22:33:44:55", now.Add(-time.Hour), "192.168.1.10", "00:11:22:33:44:55", now); err != nil {
+		t.Fatalf("insert events: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO arp_rollups (mac, ip, ip_type, first_seen, last_seen, count) VALUES (?, ?, 'ipv4', ?, ?, 5)`,
+		"00:11:22:33:44:55", "192.168.1.10", now.AddDate(0, 0, -90), now.AddDate(0, 0, -60)); err != nil {
+		t.Fatalf("insert rollup: %v", err)
+	}
+
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	entries, err := GetRecentEntries(db, 1)
+	if err != nil || len(entries) != 1 || len(entries[0].Addresses) != 1 {
+		t.Fatalf("GetRecentEntries() = %+v, %v", entries, err)
+	}
+	addr := entries[0].Addresses[0]
+	if addr.Count != 7 || !addr.FirstSeen.Equal(now.AddDate(0, 0, -90)) || !addr.LastSeen.Equal(now) {
+		t.Errorf("unexpected backfilled address: %+v", addr)
+	}
+	if !entries[0].FirstSeen.Equal(now.AddDate(0, 0, -90)) {
+		t.Errorf("expected device first seen %v, got %v", now.AddDate(0, 0, -90), entries[0].FirstSeen)
+	}
+}
+
+func TestParseMAC(t *testing.T) {
+	for _, s := range []string{"00:11:22:aa:bb:cc", "00-11-22-AA-BB-CC", "0011.22aa.bbcc", "001122AABBCC", " 00:11:22:AA:BB:CC "} {
+		if mac, err := ParseMAC(s); err != nil || mac != "00:11:22:aa:bb:cc" {
+			t.Errorf("ParseMAC(%q) = %q, %v", s, mac, err)
+		}
+	}
+	for _, s := range []string{"", "nonsense", "00112233445", "0011223344zz", "00:11:22:33:44"} {
+		if mac, err := ParseMAC(s); err == nil {
+			t.Errorf("ParseMAC(%q) = %q, expected an error", s, mac)
+		}
+	}
+}
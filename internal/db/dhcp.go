@@ -0,0 +1,73 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+)
+
+type DHCPServer struct {
+	ServerID  string    `json:"server_id"`
+	MAC       string    `json:"mac"`
+	Subnet    string    `json:"subnet,omitempty"`
+	Gateway   string    `json:"gateway,omitempty"`
+	DNS       []string  `json:"dns,omitempty"`
+	Allowed   bool      `json:"allowed"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int       `json:"count"`
+}
+
+// RecordDHCPServer stores a DHCP OFFER/ACK sighting, updating the offered parameters of a known server.
+func RecordDHCPServer(db *sql.DB, server DHCPServer) error {
+	now := dbTime(time.Now())
+	_, err := db.Exec(`
+        INSERT INTO dhcp_servers (server_id, mac, subnet, gateway, dns, allowed, first_seen, last_seen, count)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1)
+        ON CONFLICT (server_id, mac) DO UPDATE SET
+            subnet = excluded.subnet,
+            gateway = excluded.gateway,
+            dns = excluded.dns,
+            allowed = excluded.allowed,
+            last_seen = excluded.last_seen,
+            count = count + 1
+        `, server.ServerID, server.MAC, server.Subnet, server.Gateway, strings.Join(server.DNS, ","),
+		server.Allowed, now, now)
+	return err
+}
+
+func GetDHCPServers(db *sql.DB) ([]DHCPServer, error) {
+	rows, err := db.Query(`
+        SELECT server_id, mac, subnet, gateway, dns, allowed, first_seen, last_seen, count
+        FROM dhcp_servers ORDER BY server_id, mac
+        `)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	var result []DHCPServer
+	var skipped skippedRows
+	for rows.Next() {
+		var s DHCPServer
+		var dns string
+		if err := rows.Scan(&s.ServerID, &s.MAC, &s.Subnet, &s.Gateway, &dns, &s.Allowed,
+			&s.FirstSeen, &s.LastSeen, &s.Count); err != nil {
+			skipped.add(err)
+			continue
+		}
+		if dns != "" {
+			s.DNS = strings.Split(dns, ",")
+		}
+		result = append(result, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, skipped.err()
+}
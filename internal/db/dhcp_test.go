@@ -0,0 +1,45 @@
+package db
+
+import (
+	"log"
+	"testing"
+)
+
+func TestRecordAndGetDHCPServers(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("failed to close database: %v", err)
+		}
+	}()
+
+	for _, server := range []DHCPServer{
+		{ServerID: "192.168.1.1", MAC: "00:11:22:33:44:55", Subnet: "192.168.1.0/24",
+			Gateway: "192.168.1.1", DNS: []string{"192.168.1.1", "1.1.1.1"}, Allowed: true},
+		{ServerID: "192.168.1.1", MAC: "00:11:22:33:44:55", Subnet: "192.168.1.0/24",
+			Gateway: "192.168.1.254", DNS: []string{"192.168.1.1"}, Allowed: true},
+		{ServerID: "192.168.0.1", MAC: "66:77:88:99:aa:bb", Subnet: "192.168.0.0/24"},
+	} {
+		if err := RecordDHCPServer(db, server); err != nil {
+			t.Fatalf("RecordDHCPServer failed: %v", err)
+		}
+	}
+
+	servers, err := GetDHCPServers(db)
+	if err != nil {
+		t.Fatalf("GetDHCPServers failed: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d: %+v", len(servers), servers)
+	}
+	rogue, known := servers[0], servers[1]
+	if rogue.ServerID != "192.168.0.1" || rogue.Allowed || rogue.Count != 1 || len(rogue.DNS) != 0 {
+		t.Errorf("unexpected rogue server: %+v", rogue)
+	}
+	if known.Count != 2 || known.Gateway != "192.168.1.254" || len(known.DNS) != 1 || !known.Allowed {
+		t.Errorf("unexpected known server: %+v", known)
+	}
+}
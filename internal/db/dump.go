@@ -0,0 +1,511 @@
+package db
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// DumpRecord is one record of a database export. Events carry their sighting as first_seen and
+// last_seen with a count of 1, devices have no ip, family and count. Annotations and approvals
+// carry the time they were last changed as first_seen and last_seen.
+type DumpRecord struct {
+	Type      string    `json:"type"`
+	MAC       string    `json:"mac"`
+	IP        string    `json:"ip,omitempty"`
+	Family    string    `json:"family,omitempty"` // 'ipv4' or 'ipv6'
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Count     int64     `json:"count,omitempty"`
+	// fields of annotations
+	Name     string   `json:"name,omitempty"`
+	Owner    string   `json:"owner,omitempty"`
+	Location string   `json:"location,omitempty"`
+	Notes    string   `json:"notes,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// fields of approvals
+	Status  string `json:"status,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Record types of a dump, exported in this order.
+const (
+	RecordDevice     = "device"
+	RecordAddress    = "address"
+	RecordRollup     = "rollup"
+	RecordEvent      = "event"
+	RecordAnnotation = "annotation"
+	RecordApproval   = "approval"
+)
+
+// Dump formats: one JSON object per line, or CSV with a header row of dumpColumns.
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+var dumpColumns = []string{"type", "mac", "ip", "family", "first_seen", "last_seen", "count",
+	"name", "owner", "location", "notes", "tags", "status", "comment"}
+
+// sightingColumns are the columns of CSV dumps written before annotations and approvals were
+// exported, they are still imported.
+const sightingColumns = 7
+
+// ImportStats summarizes an import. Duplicates are events, rollups, annotations and approvals
+// already in the database, or older than the stored ones.
+type ImportStats struct {
+	Devices     int `json:"devices"`
+	Addresses   int `json:"addresses"`
+	Rollups     int `json:"rollups"`
+	Events      int `json:"events"`
+	Annotations int `json:"annotations"`
+	Approvals   int `json:"approvals"`
+	Duplicates  int `json:"duplicates"`
+}
+
+func checkFormat(format string) error {
+	if format != FormatJSONL && format != FormatCSV {
+		return fmt.Errorf("unknown dump format %q, use %s or %s", format, FormatJSONL, FormatCSV)
+	}
+	return nil
+}
+
+// Export writes all devices, addresses, rollups, events, annotations and approvals of db to w. Everything is read in one
+// transaction, so the dump is consistent while the sniffer keeps writing.
+func Export(db *sql.DB, w io.Writer, format string) error {
+	if err := checkFormat(format); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	write := func(rec DumpRecord) error { return enc.Encode(rec) }
+	var cw *csv.Writer
+	if format == FormatCSV {
+		cw = csv.NewWriter(w)
+		if err := cw.Write(dumpColumns); err != nil {
+			return err
+		}
+		write = func(rec DumpRecord) error { return cw.Write(rec.csvRow()) }
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	queries := []struct {
+		recordType string
+		query      string
+	}{
+		{RecordDevice, `SELECT mac, '', '', first_seen, last_seen, 0 FROM devices ORDER BY mac`},
+		{RecordAddress, `SELECT mac, ip, ip_type, first_seen, last_seen, count FROM device_addresses ORDER BY mac, ip`},
+		{RecordRollup, `SELECT mac, ip, ip_type, first_seen, last_seen, count FROM arp_rollups ORDER BY mac, ip`},
+		{RecordEvent, `SELECT mac, ip, ip_type, seen_at, seen_at, 1 FROM arp_events WHERE seen_at IS NOT NULL ORDER BY id`},
+	}
+	for _, q := range queries {
+		if err := exportQuery(tx, q.recordType, q.query, write); err != nil {
+			return fmt.Errorf("export %ss: %w", q.recordType, err)
+		}
+	}
+	if err := exportAnnotations(tx, write); err != nil {
+		return fmt.Errorf("export annotations: %w", err)
+	}
+	if err := exportApprovals(tx, write); err != nil {
+		return fmt.Errorf("export approvals: %w", err)
+	}
+
+	if cw != nil {
+		cw.Flush()
+		return cw.Error()
+	}
+	return nil
+}
+
+func exportQuery(tx *sql.Tx, recordType, query string, write func(DumpRecord) error) error {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		rec := DumpRecord{Type: recordType}
+		if err := rows.Scan(&rec.MAC, &rec.IP, &rec.Family, &rec.FirstSeen, &rec.LastSeen, &rec.Count); err != nil {
+			return err
+		}
+		if err := write(rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func exportAnnotations(tx *sql.Tx, write func(DumpRecord) error) error {
+	rows, err := tx.Query(`SELECT mac, name, owner, location, notes, tags, updated_at FROM device_annotations ORDER BY mac`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		a, err := scanAnnotation(rows)
+		if err != nil {
+			return err
+		}
+		if err := write(DumpRecord{Type: RecordAnnotation, MAC: a.MAC, FirstSeen: a.UpdatedAt, LastSeen: a.UpdatedAt,
+			Name: a.Name, Owner: a.Owner, Location: a.Location, Notes: a.Notes, Tags: a.Tags}); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func exportApprovals(tx *sql.Tx, write func(DumpRecord) error) error {
+	rows, err := tx.Query(`SELECT mac, status, comment, decided_at FROM device_approvals ORDER BY mac`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+	for rows.Next() {
+		rec := DumpRecord{Type: RecordApproval}
+		if err := rows.Scan(&rec.MAC, &rec.Status, &rec.Comment, &rec.FirstSeen); err != nil {
+			return err
+		}
+		rec.LastSeen = rec.FirstSeen
+		if err := write(rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (rec DumpRecord) csvRow() []string {
+	count := ""
+	if rec.Count != 0 {
+		count = strconv.FormatInt(rec.Count, 10)
+	}
+	tags := ""
+	if len(rec.Tags) > 0 {
+		// tags are checked for control characters only, a JSON array keeps any separator intact
+		b, _ := json.Marshal(rec.Tags)
+		tags = string(b)
+	}
+	return []string{rec.Type, rec.MAC, rec.IP, rec.Family,
+		rec.FirstSeen.UTC().Format(time.RFC3339Nano), rec.LastSeen.UTC().Format(time.RFC3339Nano), count,
+		rec.Name, rec.Owner, rec.Location, rec.Notes, tags, rec.Status, rec.Comment}
+}
+
+// parseCSVRecord parses a row of dumpColumns, or of their first sightingColumns.
+func parseCSVRecord(row []string) (DumpRecord, error) {
+	row = append(row, make([]string, len(dumpColumns)-len(row))...)
+	rec := DumpRecord{Type: row[0], MAC: row[1], IP: row[2], Family: row[3],
+		Name: row[7], Owner: row[8], Location: row[9], Notes: row[10], Status: row[12], Comment: row[13]}
+	var err error
+	if rec.FirstSeen, err = time.Parse(time.RFC3339Nano, row[4]); err != nil {
+		return rec, fmt.Errorf("first_seen: %w", err)
+	}
+	if rec.LastSeen, err = time.Parse(time.RFC3339Nano, row[5]); err != nil {
+		return rec, fmt.Errorf("last_seen: %w", err)
+	}
+	if row[6] != "" {
+		if rec.Count, err = strconv.ParseInt(row[6], 10, 64); err != nil {
+			return rec, fmt.Errorf("count: %w", err)
+		}
+	}
+	if row[11] != "" {
+		if err := json.Unmarshal([]byte(row[11]), &rec.Tags); err != nil {
+			return rec, fmt.Errorf("tags: %w", err)
+		}
+	}
+	return rec, nil
+}
+
+// Import merges a dump written by Export into db, in one transaction that is rolled back on the
+// first invalid record. Devices and addresses only widen the known first/last sightings; the
+// sighting counts follow from the imported events and rollups. Events already stored with the
+// same MAC, IP and time are skipped as duplicates and record address changes like live ones,
+// rollups add up unless imported before, and annotations and approvals replace stored ones only
+// when they were changed later. So importing the same dump twice changes nothing.
+func Import(db *sql.DB, r io.Reader, format string) (ImportStats, error) {
+	var stats ImportStats
+	if err := checkFormat(format); err != nil {
+		return stats, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return stats, err
+	}
+	apply := func(line int, rec DumpRecord) error {
+		if err := importRecord(tx, rec, &stats); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		return nil
+	}
+	if format == FormatCSV {
+		err = readCSVDump(r, apply)
+	} else {
+		err = readJSONLDump(r, apply)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return ImportStats{}, err
+	}
+	return stats, tx.Commit()
+}
+
+func readJSONLDump(r io.Reader, apply func(line int, rec DumpRecord) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec DumpRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := apply(line, rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func readCSVDump(r io.Reader, apply func(line int, rec DumpRecord) error) error {
+	cr := csv.NewReader(r)
+	// every row has as many fields as the header
+	cr.FieldsPerRecord = 0
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if len(header) != len(dumpColumns) && len(header) != sightingColumns {
+		return fmt.Errorf("unexpected header %v, want %v", header, dumpColumns)
+	}
+	for i, column := range header {
+		if dumpColumns[i] != column {
+			return fmt.Errorf("unexpected header %v, want %v", header, dumpColumns)
+		}
+	}
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rec, err := parseCSVRecord(row)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := apply(line, rec); err != nil {
+			return err
+		}
+	}
+}
+
+// normalize validates rec and brings its MAC and family into the stored form.
+func (rec *DumpRecord) normalize() error {
+	mac, err := net.ParseMAC(rec.MAC)
+	if err != nil {
+		return err
+	}
+	rec.MAC = mac.String()
+	if rec.FirstSeen.IsZero() || rec.LastSeen.Before(rec.FirstSeen) {
+		return fmt.Errorf("invalid sighting range %v - %v", rec.FirstSeen, rec.LastSeen)
+	}
+	switch rec.Type {
+	case RecordDevice:
+		return nil
+	case RecordAnnotation:
+		a := Annotation{MAC: rec.MAC, Name: rec.Name, Owner: rec.Owner, Location: rec.Location, Notes: rec.Notes, Tags: rec.Tags}
+		if err := a.Normalize(); err != nil {
+			return err
+		}
+		rec.Name, rec.Owner, rec.Location, rec.Notes, rec.Tags = a.Name, a.Owner, a.Location, a.Notes, a.Tags
+		return nil
+	case RecordApproval:
+		var err error
+		rec.MAC, rec.Comment, err = NormalizeDecision(rec.MAC, rec.Status, rec.Comment)
+		return err
+	}
+
+	ip := net.ParseIP(rec.IP)
+	if ip == nil {
+		return fmt.Errorf("invalid ip %q", rec.IP)
+	}
+	family := "ipv6"
+	if ip.To4() != nil {
+		family = "ipv4"
+	}
+	if rec.Family != "" && rec.Family != family {
+		return fmt.Errorf("ip %s is not %s", rec.IP, rec.Family)
+	}
+	rec.Family = family
+	if rec.Type == RecordRollup && rec.Count <= 0 {
+		return fmt.Errorf("invalid rollup count %d", rec.Count)
+	}
+	return nil
+}
+
+func importRecord(tx *sql.Tx, rec DumpRecord, stats *ImportStats) error {
+	switch rec.Type {
+	case RecordDevice, RecordAddress, RecordRollup, RecordEvent, RecordAnnotation, RecordApproval:
+	default:
+		return fmt.Errorf("unknown record type %q", rec.Type)
+	}
+	if err := rec.normalize(); err != nil {
+		return err
+	}
+
+	switch rec.Type {
+	case RecordDevice:
+		stats.Devices++
+		_, err := tx.Exec(`
+            INSERT INTO devices (mac, first_seen, last_seen) VALUES (?, ?, ?)
+            ON CONFLICT (mac) DO UPDATE SET
+                first_seen = MIN(first_seen, excluded.first_seen),
+                last_seen = MAX(last_seen, excluded.last_seen)
+            `, rec.MAC, dbTime(rec.FirstSeen), dbTime(rec.LastSeen))
+		return err
+
+	case RecordAddress:
+		stats.Addresses++
+		return upsertDeviceRange(tx, rec.IP, rec.Family, rec.MAC, "", rec.FirstSeen, rec.LastSeen, 0)
+
+	case RecordRollup:
+		return importRollup(tx, rec, stats)
+
+	case RecordAnnotation:
+		var updatedAt time.Time
+		err := tx.QueryRow(`SELECT updated_at FROM device_annotations WHERE mac = ?`, rec.MAC).Scan(&updatedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil && !updatedAt.Before(rec.LastSeen) {
+			stats.Duplicates++
+			return nil
+		}
+		stats.Annotations++
+		tags, err := json.Marshal(append([]string{}, rec.Tags...))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+            INSERT INTO device_annotations (mac, name, owner, location, notes, tags, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?, ?)
+            ON CONFLICT (mac) DO UPDATE SET
+                name = excluded.name,
+                owner = excluded.owner,
+                location = excluded.location,
+                notes = excluded.notes,
+                tags = excluded.tags,
+                updated_at = excluded.updated_at
+            `, rec.MAC, rec.Name, rec.Owner, rec.Location, rec.Notes, string(tags), dbTime(rec.LastSeen))
+		return err
+
+	case RecordApproval:
+		var decidedAt time.Time
+		err := tx.QueryRow(`SELECT decided_at FROM device_approvals WHERE mac = ?`, rec.MAC).Scan(&decidedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil && !decidedAt.Before(rec.LastSeen) {
+			stats.Duplicates++
+			return nil
+		}
+		stats.Approvals++
+		_, err = tx.Exec(`
+            INSERT INTO device_approvals (mac, status, comment, decided_at) VALUES (?, ?, ?, ?)
+            ON CONFLICT (mac) DO UPDATE SET
+                status = excluded.status,
+                comment = excluded.comment,
+                decided_at = excluded.decided_at
+            `, rec.MAC, rec.Status, rec.Comment, dbTime(rec.LastSeen))
+		return err
+
+	default: // RecordEvent
+		var duplicate int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM arp_events WHERE mac = ? AND ip = ? AND seen_at = ?`,
+			rec.MAC, rec.IP, dbTime(rec.FirstSeen)).Scan(&duplicate); err != nil {
+			return err
+		}
+		if duplicate > 0 {
+			stats.Duplicates++
+			return nil
+		}
+		stats.Events++
+		if _, err := tx.Exec(`INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES (?, ?, ?, ?)`,
+			rec.IP, rec.Family, rec.MAC, dbTime(rec.FirstSeen)); err != nil {
+			return err
+		}
+		if err := recordAddressChange(tx, rec.IP, rec.Family, rec.MAC, rec.FirstSeen); err != nil {
+			return err
+		}
+		if err := upsertDevice(tx, rec.IP, rec.Family, rec.MAC, "", rec.FirstSeen); err != nil {
+			return err
+		}
+		return recordSession(tx, rec.MAC, rec.FirstSeen)
+	}
+}
+
+// importRollup adds a rollup to the stored one of its MAC and IP, so the sightings of sensors that
+// watched the same network add up. A rollup equal to the stored one, as in a dump of this database,
+// or imported before is a duplicate.
+func importRollup(tx *sql.Tx, rec DumpRecord, stats *ImportStats) error {
+	var firstSeen, lastSeen time.Time
+	var count int64
+	err := tx.QueryRow(`SELECT first_seen, last_seen, count FROM arp_rollups WHERE mac = ? AND ip = ?`,
+		rec.MAC, rec.IP).Scan(&firstSeen, &lastSeen, &count)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err == nil && rec.Count == count && rec.FirstSeen.Equal(firstSeen) && rec.LastSeen.Equal(lastSeen) {
+		stats.Duplicates++
+		return nil
+	}
+	res, err := tx.Exec(`INSERT OR IGNORE INTO imported_rollups (mac, ip, first_seen, last_seen, count) VALUES (?, ?, ?, ?, ?)`,
+		rec.MAC, rec.IP, dbTime(rec.FirstSeen), dbTime(rec.LastSeen), rec.Count)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		stats.Duplicates++
+		return nil
+	}
+	stats.Rollups++
+	if _, err := tx.Exec(`
+        INSERT INTO arp_rollups (mac, ip, ip_type, first_seen, last_seen, count) VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (mac, ip) DO UPDATE SET
+            first_seen = MIN(first_seen, excluded.first_seen),
+            last_seen = MAX(last_seen, excluded.last_seen),
+            count = count + excluded.count
+        `, rec.MAC, rec.IP, rec.Family, dbTime(rec.FirstSeen), dbTime(rec.LastSeen), rec.Count); err != nil {
+		return err
+	}
+	return upsertDeviceRange(tx, rec.IP, rec.Family, rec.MAC, "", rec.FirstSeen, rec.LastSeen, rec.Count)
+}
@@ -0,0 +1,243 @@
+package db
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDumpTestDB(t *testing.T, name string) *SQLiteStore {
+	t.Helper()
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	now := time.Now().UTC()
+	src := newDumpTestDB(t, "src.db")
+	for _, e := range []struct {
+		ip, ipType, mac string
+		seenAt          time.Time
+	}{
+		{"192.168.1.10", "ipv4", "00:11:22:33:44:55", now.AddDate(0, 0, -40)},
+		{"192.168.1.10", "ipv4", "00:11:22:33:44:55", now.Add(-time.Hour)},
+		{"fe80::1", "ipv6", "00:11:22:33:44:55", now.Add(-time.Minute)},
+		{"192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now},
+	} {
+		if err := insertEvent(src.DB, e.ip, e.ipType, e.mac, e.seenAt); err != nil {
+			t.Fatalf("insertEvent failed: %v", err)
+		}
+	}
+	if _, err := RollupEvents(src.DB, 30); err != nil {
+		t.Fatalf("RollupEvents failed: %v", err)
+	}
+	annotation, err := SetAnnotation(src.DB, Annotation{MAC: "00:11:22:33:44:55", Name: "nas", Owner: "ops",
+		Notes: "rack 2\nshelf 1", Tags: []string{"storage", "a,b"}})
+	if err != nil {
+		t.Fatalf("SetAnnotation failed: %v", err)
+	}
+	approval, err := SetDeviceStatus(src.DB, "66:77:88:99:aa:bb", DeviceRejected, "unknown laptop")
+	if err != nil {
+		t.Fatalf("SetDeviceStatus failed: %v", err)
+	}
+	want, err := GetRecentEntries(src.DB, 60)
+	if err != nil {
+		t.Fatalf("GetRecentEntries failed: %v", err)
+	}
+
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			var dump bytes.Buffer
+			if err := Export(src.DB, &dump, format); err != nil {
+				t.Fatalf("Export failed: %v", err)
+			}
+			dst := newDumpTestDB(t, "dst.db")
+			stats, err := Import(dst.DB, bytes.NewReader(dump.Bytes()), format)
+			if err != nil {
+				t.Fatalf("Import failed: %v", err)
+			}
+			if stats != (ImportStats{Devices: 2, Addresses: 3, Rollups: 1, Events: 3, Annotations: 1, Approvals: 1}) {
+				t.Errorf("unexpected stats: %+v", stats)
+			}
+			got, err := GetRecentEntries(dst.DB, 60)
+			if err != nil || !reflect.DeepEqual(got, want) {
+				t.Errorf("imported entries = %+v, %v; want %+v", got, err, want)
+			}
+			if a, err := GetAnnotation(dst.DB, annotation.MAC); err != nil || a == nil || !reflect.DeepEqual(*a, annotation) {
+				t.Errorf("imported annotation = %+v, %v; want %+v", a, err, annotation)
+			}
+			if d, err := GetDevice(dst.DB, approval.MAC); err != nil || d == nil || d.Status != DeviceRejected ||
+				d.Comment != approval.Comment || !d.DecidedAt.Equal(*approval.DecidedAt) {
+				t.Errorf("imported approval = %+v, %v; want %+v", d, err, approval)
+			}
+
+			// importing the same dump again only finds duplicates
+			stats, err = Import(dst.DB, bytes.NewReader(dump.Bytes()), format)
+			if err != nil || stats != (ImportStats{Devices: 2, Addresses: 3, Duplicates: 6}) {
+				t.Errorf("second import = %+v, %v", stats, err)
+			}
+			if got, _ := GetRecentEntries(dst.DB, 60); !reflect.DeepEqual(got, want) {
+				t.Errorf("second import changed entries: %+v", got)
+			}
+		})
+	}
+}
+
+func TestImport_MergesSensors(t *testing.T) {
+	now := time.Now().UTC()
+	primary := newDumpTestDB(t, "main.db")
+	sensor := newDumpTestDB(t, "sensor.db")
+	if err := insertEvent(primary.DB, "192.168.1.10", "ipv4", "00:11:22:33:44:55", now.Add(-time.Hour)); err != nil {
+		t.Fatalf("insertEvent failed: %v", err)
+	}
+	if err := insertEvent(sensor.DB, "192.168.1.10", "ipv4", "00:11:22:33:44:55", now.Add(-48*time.Hour)); err != nil {
+		t.Fatalf("insertEvent failed: %v", err)
+	}
+	if err := insertEvent(sensor.DB, "192.168.1.10", "ipv4", "00:11:22:33:44:55", now); err != nil {
+		t.Fatalf("insertEvent failed: %v", err)
+	}
+
+	var dump bytes.Buffer
+	if err := Export(sensor.DB, &dump, FormatJSONL); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if _, err := Import(primary.DB, &dump, FormatJSONL); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	entries, err := GetRecentEntries(primary.DB, 7)
+	if err != nil || len(entries) != 1 || len(entries[0].Addresses) != 1 {
+		t.Fatalf("GetRecentEntries() = %+v, %v", entries, err)
+	}
+	addr := entries[0].Addresses[0]
+	if addr.Count != 3 || !addr.FirstSeen.Equal(now.Add(-48*time.Hour)) || !addr.LastSeen.Equal(now) {
+		t.Errorf("unexpected merged address: %+v", addr)
+	}
+}
+
+func TestImport_RollupsOfTwoSensorsAddUp(t *testing.T) {
+	store := newDumpTestDB(t, "arpmonitor.db")
+	rollup := func(firstSeen string, count int) string {
+		return `{"type":"rollup","mac":"00:11:22:33:44:55","ip":"192.168.1.10","first_seen":"` + firstSeen + `",` +
+			`"last_seen":"2025-05-02T08:00:00Z","count":` + strconv.Itoa(count) + "}\n"
+	}
+	addressCount := func() int64 {
+		t.Helper()
+		var rollupCount, count int64
+		if err := store.DB.QueryRow(`SELECT count FROM arp_rollups`).Scan(&rollupCount); err != nil {
+			t.Fatalf("read rollup: %v", err)
+		}
+		if err := store.DB.QueryRow(`SELECT count FROM device_addresses`).Scan(&count); err != nil {
+			t.Fatalf("read address: %v", err)
+		}
+		if rollupCount != count {
+			t.Errorf("rollup count %d, address count %d", rollupCount, count)
+		}
+		return count
+	}
+
+	for i, step := range []struct {
+		firstSeen string
+		count     int
+		want      int64
+		duplicate bool
+	}{
+		{firstSeen: "2025-05-01T08:00:00Z", count: 3, want: 3},
+		{firstSeen: "2025-05-01T08:00:00Z", count: 3, want: 3, duplicate: true},
+		// the other sensor saw the device in the same hours
+		{firstSeen: "2025-05-01T09:00:00Z", count: 5, want: 8},
+		{firstSeen: "2025-05-01T09:00:00Z", count: 5, want: 8, duplicate: true},
+		// a dump of the merged database
+		{firstSeen: "2025-05-01T08:00:00Z", count: 8, want: 8, duplicate: true},
+	} {
+		stats, err := Import(store.DB, strings.NewReader(rollup(step.firstSeen, step.count)), FormatJSONL)
+		if err != nil {
+			t.Fatalf("import %d failed: %v", i, err)
+		}
+		if (stats.Duplicates == 1) != step.duplicate {
+			t.Errorf("import %d: unexpected stats %+v", i, stats)
+		}
+		if count := addressCount(); count != step.want {
+			t.Errorf("import %d: count %d, want %d", i, count, step.want)
+		}
+	}
+}
+
+func TestImport_RecordsAddressChanges(t *testing.T) {
+	store := newDumpTestDB(t, "arpmonitor.db")
+	now := time.Now().UTC().Truncate(time.Second)
+	event := func(ip string, seenAt time.Time) string {
+		at := seenAt.Format(time.RFC3339)
+		return `{"type":"event","mac":"00:11:22:33:44:55","ip":"` + ip + `","first_seen":"` + at +
+			`","last_seen":"` + at + `","count":1}` + "\n"
+	}
+	dump := event("192.168.1.10", now.Add(-2*time.Hour)) + event("192.168.1.11", now.Add(-time.Hour))
+	for i := 0; i < 2; i++ {
+		if _, err := Import(store.DB, strings.NewReader(dump), FormatJSONL); err != nil {
+			t.Fatalf("Import failed: %v", err)
+		}
+	}
+
+	changes, err := GetChanges(store.DB, now.Add(-3*time.Hour))
+	if err != nil {
+		t.Fatalf("GetChanges failed: %v", err)
+	}
+	var moves []Change
+	for _, c := range changes {
+		if c.Type == ChangeIP {
+			moves = append(moves, c)
+		}
+	}
+	if len(moves) != 1 || moves[0].IP != "192.168.1.11" || moves[0].PreviousIP != "192.168.1.10" ||
+		!moves[0].At.Equal(now.Add(-time.Hour)) {
+		t.Errorf("ip changes = %+v, want one move to 192.168.1.11", moves)
+	}
+}
+
+func TestImport_LegacyCSV(t *testing.T) {
+	store := newDumpTestDB(t, "arpmonitor.db")
+	dump := "type,mac,ip,family,first_seen,last_seen,count\n" +
+		"event,00:11:22:33:44:55,192.168.1.10,ipv4,2025-05-01T08:00:00Z,2025-05-01T08:00:00Z,1\n"
+	if stats, err := Import(store.DB, strings.NewReader(dump), FormatCSV); err != nil || stats.Events != 1 {
+		t.Errorf("Import() = %+v, %v", stats, err)
+	}
+}
+
+func TestImport_InvalidRecordRollsBack(t *testing.T) {
+	store := newDumpTestDB(t, "arpmonitor.db")
+	dump := `{"type":"event","mac":"00:11:22:33:44:55","ip":"192.168.1.10","first_seen":"2025-05-01T08:00:00Z","last_seen":"2025-05-01T08:00:00Z","count":1}
+{"type":"event","mac":"not a mac","ip":"192.168.1.11","first_seen":"2025-05-01T08:00:00Z","last_seen":"2025-05-01T08:00:00Z","count":1}
+`
+	if _, err := Import(store.DB, strings.NewReader(dump), FormatJSONL); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error for line 2, got %v", err)
+	}
+	var events int
+	if err := store.DB.QueryRow(`SELECT COUNT(*) FROM arp_events`).Scan(&events); err != nil || events != 0 {
+		t.Errorf("expected nothing imported, got %d events, %v", events, err)
+	}
+
+	for _, dump := range []string{
+		`{"type":"lease","mac":"00:11:22:33:44:55","first_seen":"2025-05-01T08:00:00Z","last_seen":"2025-05-01T08:00:00Z"}`,
+		`{"type":"address","mac":"00:11:22:33:44:55","ip":"192.168.1.10","family":"ipv6","first_seen":"2025-05-01T08:00:00Z","last_seen":"2025-05-01T08:00:00Z"}`,
+		`{"type":"rollup","mac":"00:11:22:33:44:55","ip":"192.168.1.10","first_seen":"2025-05-01T08:00:00Z","last_seen":"2025-04-01T08:00:00Z","count":2}`,
+		`{"type":"approval","mac":"00:11:22:33:44:55","status":"unknown","first_seen":"2025-05-01T08:00:00Z","last_seen":"2025-05-01T08:00:00Z"}`,
+		`{"type":"annotation","mac":"00:11:22:33:44:55","name":"two\nlines","first_seen":"2025-05-01T08:00:00Z","last_seen":"2025-05-01T08:00:00Z"}`,
+	} {
+		if _, err := Import(store.DB, strings.NewReader(dump), FormatJSONL); err == nil {
+			t.Errorf("expected %s to be rejected", dump)
+		}
+	}
+	if _, err := Import(store.DB, strings.NewReader("mac,ip\n"), FormatCSV); err == nil {
+		t.Error("expected CSV with wrong header to be rejected")
+	}
+	if _, err := Import(store.DB, strings.NewReader(""), "xml"); err == nil {
+		t.Error("expected unknown format to be rejected")
+	}
+}
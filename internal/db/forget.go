@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ActionForgetDevice is the audit action of ForgetDevice.
+const ActionForgetDevice = "forget-device"
+
+// AuditEntry records that something happened without keeping the data it was about, e.g. a
+// deletion lists how many rows it removed per table but not the MAC.
+type AuditEntry struct {
+	ID     int64     `json:"id"`
+	Action string    `json:"action"`
+	At     time.Time `json:"at"`
+	// Source is where the action was triggered, e.g. "api" or "cli".
+	Source string `json:"source"`
+	// Reason is free text given by the caller, e.g. the reference of a deletion request.
+	Reason  string           `json:"reason,omitempty"`
+	Deleted map[string]int64 `json:"deleted,omitempty"`
+}
+
+// forgetTables lists every table with data about a MAC in its mac column.
+var forgetTables = []string{
+	"arp_events",
+	"arp_rollups",
+	"imported_rollups",
+	"device_addresses",
+	"devices",
+	"sessions",
+	"mac_counters",
+	"dhcp_servers",
+	"device_annotations",
+	"device_approvals",
+	"hostnames",
+	"address_changes",
+}
+
+// NormalizeForget validates a forget request and returns the MAC in canonical notation and the
+// trimmed reason.
+func NormalizeForget(mac, reason string) (string, string, error) {
+	mac, err := ParseMAC(mac)
+	if err != nil {
+		return "", "", err
+	}
+	reason = strings.TrimSpace(reason)
+	if err := checkAnnotationText("reason", reason, maxAnnotationField, false); err != nil {
+		return "", "", err
+	}
+	return mac, reason, nil
+}
+
+// ForgetDevice deletes everything stored about mac from all tables and records the deletion in the
+// audit log, in one transaction. A nil entry means nothing was stored about mac.
+func ForgetDevice(db *sql.DB, mac, source, reason string) (*AuditEntry, error) {
+	mac, reason, err := NormalizeForget(mac, reason)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	entry := AuditEntry{Action: ActionForgetDevice, At: time.Now().UTC(), Source: source, Reason: reason,
+		Deleted: make(map[string]int64)}
+	var total int64
+	for _, table := range forgetTables {
+		// older versions did not normalize every MAC
+		res, err := tx.Exec(`DELETE FROM `+table+` WHERE lower(mac) = ?`, mac)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", table, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			entry.Deleted[table] = n
+		}
+		total += n
+	}
+	if total == 0 {
+		return nil, nil
+	}
+
+	deleted, err := json.Marshal(entry.Deleted)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(`INSERT INTO audit_log (action, at, source, reason, detail) VALUES (?, ?, ?, ?, ?)`,
+		entry.Action, dbTime(entry.At), entry.Source, entry.Reason, string(deleted))
+	if err != nil {
+		return nil, err
+	}
+	if entry.ID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	truncateWAL(db)
+	return &entry, nil
+}
+
+// truncateWAL checkpoints the WAL into the database and empties it, so it no longer holds the pages
+// from before a deletion. Readers in the middle of a transaction keep it from finishing; the next
+// checkpoint then overwrites the old pages.
+func truncateWAL(db *sql.DB) {
+	var busy, frames, checkpointed int
+	err := db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &frames, &checkpointed)
+	if err != nil || busy != 0 {
+		log.Printf("could not truncate the WAL after a deletion, the deleted rows stay in it until the next checkpoint: busy %d, %v", busy, err)
+	}
+}
+
+// GetAuditLog returns all audit entries, oldest first.
+func GetAuditLog(db *sql.DB) ([]AuditEntry, error) {
+	rows, err := db.Query(`SELECT id, action, at, source, reason, detail FROM audit_log ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	entries := []AuditEntry{}
+	var skipped skippedRows
+	for rows.Next() {
+		var e AuditEntry
+		var detail string
+		if err := rows.Scan(&e.ID, &e.Action, &e.At, &e.Source, &e.Reason, &detail); err != nil {
+			skipped.add(err)
+			continue
+		}
+		if err := json.Unmarshal([]byte(detail), &e.Deleted); err != nil {
+			skipped.add(fmt.Errorf("detail of audit entry %d: %w", e.ID, err))
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, skipped.err()
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Health states reported by Store.Health.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// Health summarizes the writes and failures of a store since it was opened. It is degraded while
+// the latest insert of the sniffer failed, i.e. sightings, DHCP servers or packet counters are
+// being lost.
+type Health struct {
+	Status string `json:"status"`
+	// Inserts counts the successful inserts, LastInsertAt is the time of the latest one.
+	Inserts      int64      `json:"inserts"`
+	LastInsertAt *time.Time `json:"last_insert_at,omitempty"`
+	// InsertFailures counts all failed inserts, ConsecutiveInsertFailures those since the last success.
+	InsertFailures            int64      `json:"insert_failures"`
+	ConsecutiveInsertFailures int64      `json:"consecutive_insert_failures"`
+	LastInsertError           string     `json:"last_insert_error,omitempty"`
+	LastInsertErrorAt         *time.Time `json:"last_insert_error_at,omitempty"`
+	// SkippedRows counts rows that could not be read and were left out of query results.
+	SkippedRows int64 `json:"skipped_rows"`
+}
+
+// healthTracker collects the Health of one store. The zero value is ready to use.
+type healthTracker struct {
+	mu     sync.Mutex
+	health Health
+}
+
+// recordInsert counts the outcome of an insert.
+func (t *healthTracker) recordInsert(err error) {
+	now := time.Now().UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil {
+		t.health.Inserts++
+		t.health.LastInsertAt = &now
+		t.health.ConsecutiveInsertFailures = 0
+		return
+	}
+	t.health.InsertFailures++
+	t.health.ConsecutiveInsertFailures++
+	t.health.LastInsertError = err.Error()
+	t.health.LastInsertErrorAt = &now
+}
+
+// recordResult counts the rows skipped by a query that returned err, and returns err.
+func (t *healthTracker) recordResult(err error) error {
+	var partial *PartialError
+	if errors.As(err, &partial) {
+		t.mu.Lock()
+		t.health.SkippedRows += int64(partial.Skipped)
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// current returns the health with its status.
+func (t *healthTracker) current() Health {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h := t.health
+	h.Status = HealthOK
+	if h.ConsecutiveInsertFailures > 0 {
+		h.Status = HealthDegraded
+	}
+	return h
+}
+
+// PartialError is returned together with a query result that lacks rows which could not be read.
+type PartialError struct {
+	Skipped int
+	// Err is the first error, the others are logged.
+	Err error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("skipped %d unreadable rows: %v", e.Skipped, e.Err)
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// IsPartial reports whether err only means that a result is incomplete.
+func IsPartial(err error) bool {
+	var partial *PartialError
+	return errors.As(err, &partial)
+}
+
+// skippedRows collects the scan errors of a query, which leave out the row but not the result.
+type skippedRows struct {
+	count int
+	first error
+}
+
+func (s *skippedRows) add(err error) {
+	log.Printf("skipping unreadable row: %v", err)
+	if s.count == 0 {
+		s.first = err
+	}
+	s.count++
+}
+
+// err returns a *PartialError if rows were skipped, nil otherwise.
+func (s *skippedRows) err() error {
+	if s.count == 0 {
+		return nil
+	}
+	return &PartialError{Skipped: s.count, Err: s.first}
+}
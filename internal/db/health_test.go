@@ -0,0 +1,152 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newHealthTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "health.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore failed: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestGetEntries_PartialResult(t *testing.T) {
+	store := newHealthTestStore(t)
+	db := store.DB
+
+	now := time.Now()
+	if err := insertEvent(db, "192.168.1.10", "ipv4", "00:11:22:33:44:55", now); err != nil {
+		t.Fatalf("insertEvent failed: %v", err)
+	}
+	if err := insertEvent(db, "192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now); err != nil {
+		t.Fatalf("insertEvent failed: %v", err)
+	}
+	// rows the driver cannot scan, e.g. written by a broken import
+	if _, err := db.Exec(`UPDATE device_addresses SET count = 'many' WHERE mac = '66:77:88:99:aa:bb'`); err != nil {
+		t.Fatalf("corrupt device_addresses: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES ('192.168.1.12', 'ipv4', 'aa:aa:aa:aa:aa:aa', ?)`,
+		dbTime(now)+" garbage"); err != nil {
+		t.Fatalf("corrupt arp_events: %v", err)
+	}
+
+	entries, err := store.GetRecentEntries(1)
+	if !IsPartial(err) {
+		t.Fatalf("expected a partial result, got %v", err)
+	}
+	if len(entries) != 1 || entries[0].MAC != "00:11:22:33:44:55" {
+		t.Errorf("expected the readable device, got %+v", entries)
+	}
+
+	entries, err = store.GetEntries(now.Add(-time.Hour), now.Add(time.Hour))
+	if !IsPartial(err) {
+		t.Fatalf("expected a partial result for a closed range, got %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected both devices with events in the range, got %+v", entries)
+	}
+	if skipped := store.Health().SkippedRows; skipped != 3 {
+		t.Errorf("expected 3 skipped rows to be counted, got %d", skipped)
+	}
+}
+
+func TestInsertARPEvent_Health(t *testing.T) {
+	store := newHealthTestStore(t)
+	other := newHealthTestStore(t)
+
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	if h := store.Health(); h.Status != HealthOK || h.Inserts != 1 || h.LastInsertAt == nil {
+		t.Fatalf("expected ok after a successful insert, got %+v", h)
+	}
+
+	if _, err := store.DB.Exec(`CREATE TRIGGER fail BEFORE INSERT ON arp_events BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatalf("create trigger: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err == nil {
+			t.Fatal("expected InsertARPEvent to fail")
+		}
+	}
+	h := store.Health()
+	if h.Status != HealthDegraded || h.ConsecutiveInsertFailures != 2 || h.InsertFailures != 2 || h.Inserts != 1 {
+		t.Errorf("expected degraded after 2 failures, got %+v", h)
+	}
+	if h.LastInsertError != "disk full" || h.LastInsertErrorAt == nil {
+		t.Errorf("expected the last error, got %q at %v", h.LastInsertError, h.LastInsertErrorAt)
+	}
+	if h := other.Health(); h.Status != HealthOK || h.InsertFailures != 0 {
+		t.Errorf("expected the failures of one store to leave another one ok, got %+v", h)
+	}
+
+	if _, err := store.DB.Exec(`DROP TRIGGER fail`); err != nil {
+		t.Fatalf("drop trigger: %v", err)
+	}
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	if h := store.Health(); h.Status != HealthOK || h.ConsecutiveInsertFailures != 0 {
+		t.Errorf("expected ok once inserts succeed again, got %+v", h)
+	}
+}
+
+func TestSnifferWrites_Health(t *testing.T) {
+	store := newHealthTestStore(t)
+
+	for _, table := range []string{"dhcp_servers", "mac_counters"} {
+		if _, err := store.DB.Exec(`CREATE TRIGGER fail_` + table + ` BEFORE INSERT ON ` + table +
+			` BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+			t.Fatalf("create trigger: %v", err)
+		}
+	}
+	if err := store.RecordDHCPServer(DHCPServer{ServerID: "192.168.1.1", MAC: "00:11:22:33:44:55"}); err == nil {
+		t.Fatal("expected RecordDHCPServer to fail")
+	}
+	counts := map[CounterKey]PacketCounts{{MAC: "00:11:22:33:44:55", Hour: time.Now()}: {Frames: 1}}
+	if err := store.AddPacketCounts(counts); err == nil {
+		t.Fatal("expected AddPacketCounts to fail")
+	}
+	if h := store.Health(); h.Status != HealthDegraded || h.InsertFailures != 2 {
+		t.Errorf("expected degraded after failed DHCP and counter writes, got %+v", h)
+	}
+
+	if err := store.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+	if h := store.Health(); h.Status != HealthOK {
+		t.Errorf("expected ok once inserts succeed again, got %+v", h)
+	}
+}
+
+func TestStore_HealthCountsInserts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		if h := s.Health(); h.Status != HealthOK || h.Inserts != 0 || h.LastInsertAt != nil {
+			t.Errorf("expected a fresh store without inserts, got %+v", h)
+		}
+		if err := s.InsertARPEvent("192.168.1.10", "00:11:22:33:44:55"); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+		if err := s.RecordDHCPServer(DHCPServer{ServerID: "192.168.1.1", MAC: "00:11:22:33:44:55"}); err != nil {
+			t.Fatalf("RecordDHCPServer failed: %v", err)
+		}
+		counts := map[CounterKey]PacketCounts{{MAC: "00:11:22:33:44:55", Hour: time.Now()}: {Frames: 1}}
+		if err := s.AddPacketCounts(counts); err != nil {
+			t.Fatalf("AddPacketCounts failed: %v", err)
+		}
+		// the sniffer flushes every minute, also without packets
+		if err := s.AddPacketCounts(nil); err != nil {
+			t.Fatalf("AddPacketCounts failed: %v", err)
+		}
+
+		if h := s.Health(); h.Status != HealthOK || h.Inserts != 3 || h.LastInsertAt == nil {
+			t.Errorf("expected 3 inserts, got %+v", h)
+		}
+	})
+}
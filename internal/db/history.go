@@ -0,0 +1,165 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// IPBinding is a period in which one MAC used an address: a run of sightings of the address
+// without another MAC using it in between.
+type IPBinding struct {
+	IP        string    `json:"ip"`
+	MAC       string    `json:"mac"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Sightings int64     `json:"sightings"`
+	// RolledUp marks bindings from events past the retention period. Only the first and last
+	// sighting are known for them, other MACs may have used the address in between.
+	RolledUp bool `json:"rolled_up,omitempty"`
+}
+
+type ipSighting struct {
+	mac    string
+	seenAt time.Time
+}
+
+// addBindings turns the sightings of ip, ordered by time, into bindings.
+func addBindings(bindings []IPBinding, ip string, sightings []ipSighting) []IPBinding {
+	start := len(bindings)
+	for _, s := range sightings {
+		if n := len(bindings); n > start && bindings[n-1].MAC == s.mac {
+			bindings[n-1].LastSeen = s.seenAt
+			bindings[n-1].Sightings++
+			continue
+		}
+		bindings = append(bindings, IPBinding{IP: ip, MAC: s.mac, FirstSeen: s.seenAt, LastSeen: s.seenAt, Sightings: 1})
+	}
+	return bindings
+}
+
+// sortBindings orders bindings by address and then by their first sighting.
+func sortBindings(bindings []IPBinding) {
+	sort.SliceStable(bindings, func(i, j int) bool {
+		if bindings[i].IP != bindings[j].IP {
+			a, _ := netip.ParseAddr(bindings[i].IP)
+			b, _ := netip.ParseAddr(bindings[j].IP)
+			return a.Less(b)
+		}
+		return bindings[i].FirstSeen.Before(bindings[j].FirstSeen)
+	})
+}
+
+// inPrefix reports whether the stored address ip is in prefix.
+func inPrefix(prefix netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && prefix.Contains(addr.Unmap())
+}
+
+// GetIPHistory returns every binding of an address in prefix, a single address being a /32 or
+// /128, ordered by address and time. Rows that cannot be read are left out, the result then comes
+// with a *PartialError.
+func GetIPHistory(db *sql.DB, prefix netip.Prefix) ([]IPBinding, error) {
+	prefix = prefix.Masked()
+	var skipped skippedRows
+	var ips []string
+	if prefix.IsSingleIP() {
+		ips = []string{prefix.Addr().String()}
+	} else {
+		// addresses are stored as text, so the prefix is matched against all known addresses
+		all, err := queryStrings(db, &skipped, `SELECT DISTINCT ip FROM device_addresses`)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range all {
+			if inPrefix(prefix, ip) {
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	bindings := []IPBinding{}
+	for _, ip := range ips {
+		sightings, err := querySightings(db, &skipped, ip)
+		if err != nil {
+			return nil, err
+		}
+		bindings = addBindings(bindings, ip, sightings)
+
+		rollups, err := queryRollupBindings(db, &skipped, ip)
+		if err != nil {
+			return nil, err
+		}
+		bindings = append(bindings, rollups...)
+	}
+	sortBindings(bindings)
+	return bindings, skipped.err()
+}
+
+func queryStrings(db *sql.DB, skipped *skippedRows, query string, args ...any) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			skipped.add(err)
+			continue
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+func querySightings(db *sql.DB, skipped *skippedRows, ip string) ([]ipSighting, error) {
+	rows, err := db.Query(`SELECT mac, seen_at FROM arp_events WHERE ip = ? AND seen_at IS NOT NULL ORDER BY seen_at`, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+	var sightings []ipSighting
+	for rows.Next() {
+		var s ipSighting
+		if err := rows.Scan(&s.mac, &s.seenAt); err != nil {
+			skipped.add(err)
+			continue
+		}
+		sightings = append(sightings, s)
+	}
+	return sightings, rows.Err()
+}
+
+func queryRollupBindings(db *sql.DB, skipped *skippedRows, ip string) ([]IPBinding, error) {
+	rows, err := db.Query(`SELECT mac, first_seen, last_seen, count FROM arp_rollups WHERE ip = ?`, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+	var bindings []IPBinding
+	for rows.Next() {
+		b := IPBinding{IP: ip, RolledUp: true}
+		if err := rows.Scan(&b.MAC, &b.FirstSeen, &b.LastSeen, &b.Sightings); err != nil {
+			skipped.add(err)
+			continue
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings, rows.Err()
+}
@@ -0,0 +1,793 @@
+package db
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryEvent struct {
+	ip, ipType, mac string
+	seenAt          time.Time
+}
+
+// memoryHistory is the sighting history of one address: the raw events in the order they were
+// recorded and, by MAC, the rollups of the events folded away.
+type memoryHistory struct {
+	events  []memoryEvent
+	rollups map[string]*memoryRollup
+}
+
+// MemoryEventLimit bounds the raw events a MemoryStore keeps. Beyond it the oldest quarter is
+// rolled up like RollupEvents does, so memory stays bounded without a retention period.
+var MemoryEventLimit = 100000
+
+type macIP struct {
+	mac, ip string
+}
+
+type memoryRollup struct {
+	ipType    string
+	firstSeen time.Time
+	lastSeen  time.Time
+	count     int64
+}
+
+type memoryHostname struct {
+	hostname, source, previous string
+	changedAt                  time.Time // zero until the hostname changes the first time
+}
+
+type memoryDevice struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+	addresses map[string]*AddressInfo
+}
+
+// MemoryStore keeps everything in memory, for ephemeral deployments (e.g. on a read-only
+// SD card) and tests. All data is lost on restart.
+type MemoryStore struct {
+	mu sync.Mutex
+	// history holds the events and rollups by address, events counts the raw events in it
+	history  map[string]*memoryHistory
+	events   int
+	devices  map[string]*memoryDevice
+	dhcp     map[macIP]*DHCPServer
+	counters map[CounterKey]PacketCounts
+	sessions map[string][]Session
+
+	annotations map[string]Annotation
+	// approvals holds the decided devices, with Status, Comment and DecidedAt only
+	approvals map[string]Device
+	audit     []AuditEntry
+	hostnames map[string]memoryHostname
+	// addressChanges are the ChangeIP changes in the order they were recorded
+	addressChanges []Change
+
+	// health counts the inserts, which never fail in memory
+	health healthTracker
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		history:  make(map[string]*memoryHistory),
+		devices:  make(map[string]*memoryDevice),
+		dhcp:     make(map[macIP]*DHCPServer),
+		counters: make(map[CounterKey]PacketCounts),
+		sessions: make(map[string][]Session),
+
+		annotations: make(map[string]Annotation),
+		approvals:   make(map[string]Device),
+		hostnames:   make(map[string]memoryHostname),
+	}
+}
+
+func (m *MemoryStore) InsertARPEvent(ip, mac string) error {
+	ipType := "ipv6"
+	if net.ParseIP(ip).To4() != nil {
+		ipType = "ipv4"
+	}
+	m.insertEvent(ip, ipType, mac, time.Now())
+	m.health.recordInsert(nil)
+	return nil
+}
+
+func (m *MemoryStore) insertEvent(ip, ipType, mac string, seenAt time.Time) {
+	// UTC like the SQLite store returns it
+	seenAt = seenAt.UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, exists := m.history[ip]
+	if !exists {
+		h = &memoryHistory{rollups: make(map[string]*memoryRollup)}
+		m.history[ip] = h
+	}
+	h.events = append(h.events, memoryEvent{ip: ip, ipType: ipType, mac: mac, seenAt: seenAt})
+	m.events++
+	if m.events > MemoryEventLimit {
+		m.rollupOldest(m.events / 4)
+	}
+
+	dev, exists := m.devices[mac]
+	if !exists {
+		dev = &memoryDevice{firstSeen: seenAt, lastSeen: seenAt, addresses: make(map[string]*AddressInfo)}
+		m.devices[mac] = dev
+	}
+	dev.firstSeen = minTime(dev.firstSeen, seenAt)
+	dev.lastSeen = maxTime(dev.lastSeen, seenAt)
+
+	var previous *AddressInfo
+	for _, a := range dev.addresses {
+		if a.Family == ipType && (previous == nil || a.LastSeen.After(previous.LastSeen)) {
+			previous = a
+		}
+	}
+	if previous != nil && previous.IP != ip && !seenAt.Before(previous.LastSeen) {
+		m.addressChanges = append(m.addressChanges, Change{MAC: mac, Type: ChangeIP, At: seenAt, IP: ip, PreviousIP: previous.IP})
+	}
+
+	addr, exists := dev.addresses[ip]
+	if !exists {
+		addr = &AddressInfo{IP: ip, Family: ipType, FirstSeen: seenAt, LastSeen: seenAt}
+		dev.addresses[ip] = addr
+	}
+	if CaptureInterface != "" && !seenAt.Before(addr.LastSeen) {
+		addr.Interface = CaptureInterface
+	}
+	addr.FirstSeen = minTime(addr.FirstSeen, seenAt)
+	addr.LastSeen = maxTime(addr.LastSeen, seenAt)
+	addr.Count++
+
+	m.sessions[mac] = mergeSession(m.sessions[mac], mac, seenAt)
+}
+
+func (m *MemoryStore) GetRecentEntries(days int) ([]ArpEntry, error) {
+	return m.GetEntries(daysAgo(days), time.Time{})
+}
+
+func (m *MemoryStore) CountDevices(since time.Time) ([]DeviceCount, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type group struct{ iface, family string }
+	devices := make(map[group]int)
+	for _, dev := range m.devices {
+		seen := make(map[group]bool)
+		for _, addr := range dev.addresses {
+			g := group{addr.Interface, addr.Family}
+			if !addr.LastSeen.Before(since) && !seen[g] {
+				seen[g] = true
+				devices[g]++
+			}
+		}
+	}
+	counts := make([]DeviceCount, 0, len(devices))
+	for g, n := range devices {
+		counts = append(counts, DeviceCount{Interface: g.iface, Family: g.family, Devices: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Interface != counts[j].Interface {
+			return counts[i].Interface < counts[j].Interface
+		}
+		return counts[i].Family < counts[j].Family
+	})
+	return counts, nil
+}
+
+func (m *MemoryStore) GetEntry(mac string) (*ArpEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for stored, dev := range m.devices {
+		if !strings.EqualFold(stored, mac) {
+			continue
+		}
+		var addrs []AddressInfo
+		for _, addr := range dev.addresses {
+			addrs = append(addrs, *addr)
+		}
+		sort.Slice(addrs, func(i, j int) bool {
+			return addrs[i].LastSeen.After(addrs[j].LastSeen)
+		})
+		macMap := make(map[string]*ArpEntry)
+		for _, addr := range addrs {
+			addSighting(macMap, stored, addr.IP, addr.Family, addr.LastSeen)
+			addAddress(macMap, stored, addr, dev.firstSeen)
+		}
+		entry, exists := macMap[stored]
+		if !exists {
+			continue
+		}
+		if a, exists := m.annotations[stored]; exists {
+			entry.Annotation = a.clone()
+		}
+		return entry, nil
+	}
+	return nil, nil
+}
+
+func (m *MemoryStore) GetEntries(since, until time.Time) ([]ArpEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if until.IsZero() {
+		// the devices already hold the last sighting per MAC and address
+		macMap := make(map[string]*ArpEntry)
+		for mac, dev := range m.devices {
+			if dev.lastSeen.Before(since) {
+				continue
+			}
+			for _, addr := range dev.sortedAddresses(since, until) {
+				addSighting(macMap, mac, addr.IP, addr.Family, addr.LastSeen)
+				addAddress(macMap, mac, addr, dev.firstSeen)
+			}
+		}
+		m.addAnnotations(macMap)
+		return sortedEntries(macMap), nil
+	}
+
+	inRange := func(t time.Time) bool {
+		return !t.Before(since) && !t.After(until)
+	}
+	// rolled up events count as seen at their last sighting in the range
+	var sightings []memoryEvent
+	for ip, h := range m.history {
+		for _, ev := range h.events {
+			if inRange(ev.seenAt) {
+				sightings = append(sightings, ev)
+			}
+		}
+		for mac, r := range h.rollups {
+			seenAt := r.lastSeen
+			if !inRange(seenAt) {
+				seenAt = r.firstSeen
+			}
+			if inRange(seenAt) {
+				sightings = append(sightings, memoryEvent{ip: ip, ipType: r.ipType, mac: mac, seenAt: seenAt})
+			}
+		}
+	}
+	sort.SliceStable(sightings, func(i, j int) bool {
+		if sightings[i].mac != sightings[j].mac {
+			return sightings[i].mac < sightings[j].mac
+		}
+		return sightings[i].seenAt.After(sightings[j].seenAt)
+	})
+
+	macMap := make(map[string]*ArpEntry)
+	for _, s := range sightings {
+		addSighting(macMap, s.mac, s.ip, s.ipType, s.seenAt)
+	}
+	for mac := range macMap {
+		if dev, exists := m.devices[mac]; exists {
+			for _, addr := range dev.sortedAddresses(since, until) {
+				addAddress(macMap, mac, addr, dev.firstSeen)
+			}
+		}
+	}
+	m.addAnnotations(macMap)
+	return sortedEntries(macMap), nil
+}
+
+// sortedAddresses returns the addresses seen between since and until (open ended if zero), the
+// last seen first.
+func (dev *memoryDevice) sortedAddresses(since, until time.Time) []AddressInfo {
+	var addrs []AddressInfo
+	for _, addr := range dev.addresses {
+		if !addr.LastSeen.Before(since) && (until.IsZero() || !addr.FirstSeen.After(until)) {
+			addrs = append(addrs, *addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].LastSeen.After(addrs[j].LastSeen)
+	})
+	return addrs
+}
+
+func (m *MemoryStore) addAnnotations(macMap map[string]*ArpEntry) {
+	for mac, entry := range macMap {
+		if a, exists := m.annotations[mac]; exists {
+			entry.Annotation = a.clone()
+		}
+	}
+}
+
+func (m *MemoryStore) RecordDHCPServer(server DHCPServer) error {
+	now := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := macIP{mac: server.MAC, ip: server.ServerID}
+	if existing, exists := m.dhcp[key]; exists {
+		server.FirstSeen = existing.FirstSeen
+		server.Count = existing.Count + 1
+	} else {
+		server.FirstSeen = now
+		server.Count = 1
+	}
+	server.LastSeen = now
+	server.DNS = append([]string(nil), server.DNS...)
+	m.dhcp[key] = &server
+	m.health.recordInsert(nil)
+	return nil
+}
+
+func (m *MemoryStore) GetDHCPServers() ([]DHCPServer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var result []DHCPServer
+	for _, s := range m.dhcp {
+		server := *s
+		server.DNS = append([]string(nil), s.DNS...)
+		if len(server.DNS) == 0 {
+			server.DNS = nil
+		}
+		result = append(result, server)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ServerID != result[j].ServerID {
+			return result[i].ServerID < result[j].ServerID
+		}
+		return result[i].MAC < result[j].MAC
+	})
+	return result, nil
+}
+
+func (m *MemoryStore) AddPacketCounts(counts map[CounterKey]PacketCounts) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, c := range counts {
+		key.Hour = key.Hour.UTC().Truncate(time.Hour)
+		total := m.counters[key]
+		total.Add(c)
+		m.counters[key] = total
+	}
+	m.health.recordInsert(nil)
+	return nil
+}
+
+func (m *MemoryStore) GetPacketCounts(hours int) ([]MACCounters, error) {
+	cutoff := time.Now().Add(-time.Duration(hours) * time.Hour)
+
+	m.mu.Lock()
+	var keys []CounterKey
+	for key := range m.counters {
+		if !key.Hour.Before(cutoff) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].MAC != keys[j].MAC {
+			return keys[i].MAC < keys[j].MAC
+		}
+		return keys[i].Hour.After(keys[j].Hour)
+	})
+	macMap := make(map[string]*MACCounters)
+	for _, key := range keys {
+		addHourlyCounts(macMap, key.MAC, HourlyCounts{Hour: key.Hour, PacketCounts: m.counters[key]})
+	}
+	m.mu.Unlock()
+
+	return sortedCounters(macMap), nil
+}
+
+func (m *MemoryStore) GetSessions(mac string, days int) ([]Session, error) {
+	cutoff := daysAgo(days)
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := []Session{}
+	all := m.sessions[mac]
+	for i := len(all) - 1; i >= 0; i-- {
+		if s := all[i]; !s.End.Before(cutoff) {
+			s.finish(now)
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *MemoryStore) GetIPHistory(prefix netip.Prefix) ([]IPBinding, error) {
+	prefix = prefix.Masked()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	histories := make(map[string]*memoryHistory)
+	if prefix.IsSingleIP() {
+		if h, exists := m.history[prefix.Addr().String()]; exists {
+			histories[prefix.Addr().String()] = h
+		}
+	} else {
+		for ip, h := range m.history {
+			if inPrefix(prefix, ip) {
+				histories[ip] = h
+			}
+		}
+	}
+
+	bindings := []IPBinding{}
+	for ip, h := range histories {
+		s := make([]ipSighting, len(h.events))
+		for i, ev := range h.events {
+			s[i] = ipSighting{mac: ev.mac, seenAt: ev.seenAt}
+		}
+		sort.SliceStable(s, func(i, j int) bool { return s[i].seenAt.Before(s[j].seenAt) })
+		bindings = addBindings(bindings, ip, s)
+		for mac, r := range h.rollups {
+			bindings = append(bindings, IPBinding{IP: ip, MAC: mac, FirstSeen: r.firstSeen,
+				LastSeen: r.lastSeen, Sightings: r.count, RolledUp: true})
+		}
+	}
+	sortBindings(bindings)
+	return bindings, nil
+}
+
+func (m *MemoryStore) SetAnnotation(a Annotation) (Annotation, error) {
+	if err := a.Normalize(); err != nil {
+		return a, err
+	}
+	a.UpdatedAt = time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.annotations[a.MAC] = *a.clone()
+	return a, nil
+}
+
+func (m *MemoryStore) GetAnnotation(mac string) (*Annotation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, exists := m.annotations[mac]
+	if !exists {
+		return nil, nil
+	}
+	return a.clone(), nil
+}
+
+func (m *MemoryStore) DeleteAnnotation(mac string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, exists := m.annotations[mac]
+	delete(m.annotations, mac)
+	return exists, nil
+}
+
+func (m *MemoryStore) SetDeviceStatus(mac, status, comment string) (Device, error) {
+	mac, comment, err := NormalizeDecision(mac, status, comment)
+	if err != nil {
+		return Device{}, err
+	}
+	decidedAt := time.Now().UTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.approvals[mac] = Device{MAC: mac, Status: status, Comment: comment, DecidedAt: &decidedAt}
+	return *m.device(mac), nil
+}
+
+func (m *MemoryStore) GetDevice(mac string) (*Device, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.device(mac), nil
+}
+
+func (m *MemoryStore) GetDevices(status string) ([]Device, error) {
+	if err := CheckDeviceStatus(status); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	devices := []Device{}
+	add := func(mac string) {
+		if d := m.device(mac); status == "" || d.Status == status {
+			devices = append(devices, *d)
+		}
+	}
+	for mac := range m.devices {
+		add(mac)
+	}
+	for mac := range m.approvals {
+		if _, seen := m.devices[mac]; !seen {
+			add(mac)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].MAC < devices[j].MAC
+	})
+	return devices, nil
+}
+
+// device returns the review state of mac like GetDevice, m.mu must be held.
+func (m *MemoryStore) device(mac string) *Device {
+	d, decided := m.approvals[mac]
+	dev, seen := m.devices[mac]
+	if !decided && !seen {
+		return nil
+	}
+	if !decided {
+		d = Device{MAC: mac, Status: DeviceUnknown}
+	}
+	if seen {
+		firstSeen, lastSeen := dev.firstSeen, dev.lastSeen
+		d.FirstSeen, d.LastSeen = &firstSeen, &lastSeen
+	}
+	return &d
+}
+
+func (m *MemoryStore) ForgetDevice(mac, source, reason string) (*AuditEntry, error) {
+	mac, reason, err := NormalizeForget(mac, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// counted per table of the SQLite store, so both audit logs look the same; like there, MACs
+	// are matched in any case
+	deleted := make(map[string]int64)
+	match := func(s string) bool { return strings.EqualFold(s, mac) }
+	for ip, h := range m.history {
+		kept := h.events[:0]
+		for _, ev := range h.events {
+			if match(ev.mac) {
+				deleted["arp_events"]++
+				m.events--
+				continue
+			}
+			kept = append(kept, ev)
+		}
+		h.events = kept
+		for mac := range h.rollups {
+			if match(mac) {
+				deleted["arp_rollups"]++
+				delete(h.rollups, mac)
+			}
+		}
+		if len(h.events) == 0 && len(h.rollups) == 0 {
+			delete(m.history, ip)
+		}
+	}
+	for key, dev := range m.devices {
+		if match(key) {
+			deleted["devices"]++
+			deleted["device_addresses"] += int64(len(dev.addresses))
+			delete(m.devices, key)
+		}
+	}
+	for key, sessions := range m.sessions {
+		if match(key) {
+			if len(sessions) > 0 {
+				deleted["sessions"] += int64(len(sessions))
+			}
+			delete(m.sessions, key)
+		}
+	}
+	for key := range m.counters {
+		if match(key.MAC) {
+			deleted["mac_counters"]++
+			delete(m.counters, key)
+		}
+	}
+	for key := range m.dhcp {
+		if match(key.mac) {
+			deleted["dhcp_servers"]++
+			delete(m.dhcp, key)
+		}
+	}
+	for key := range m.annotations {
+		if match(key) {
+			deleted["device_annotations"]++
+			delete(m.annotations, key)
+		}
+	}
+	for key := range m.hostnames {
+		if match(key) {
+			deleted["hostnames"]++
+			delete(m.hostnames, key)
+		}
+	}
+	keptChanges := m.addressChanges[:0]
+	for _, c := range m.addressChanges {
+		if match(c.MAC) {
+			deleted["address_changes"]++
+			continue
+		}
+		keptChanges = append(keptChanges, c)
+	}
+	m.addressChanges = keptChanges
+	for key := range m.approvals {
+		if match(key) {
+			deleted["device_approvals"]++
+			delete(m.approvals, key)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+
+	entry := AuditEntry{ID: int64(len(m.audit) + 1), Action: ActionForgetDevice, At: time.Now().UTC(),
+		Source: source, Reason: reason, Deleted: deleted}
+	m.audit = append(m.audit, entry)
+	return &entry, nil
+}
+
+func (m *MemoryStore) GetAuditLog() ([]AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := make([]AuditEntry, len(m.audit))
+	copy(entries, m.audit)
+	return entries, nil
+}
+
+func (m *MemoryStore) RecordHostname(mac, hostname, source string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, exists := m.hostnames[mac]
+	if exists && h.hostname != hostname {
+		h.previous, h.changedAt = h.hostname, time.Now()
+	}
+	h.hostname, h.source = hostname, source
+	m.hostnames[mac] = h
+	return nil
+}
+
+func (m *MemoryStore) FindHostname(name string) ([]string, error) {
+	normalize := func(s string) string { return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".") }
+	name = normalize(name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	found := make(map[string]bool)
+	for mac, a := range m.annotations {
+		if normalize(a.Name) == name {
+			found[mac] = true
+		}
+	}
+	for mac, h := range m.hostnames {
+		if normalize(h.hostname) == name {
+			found[mac] = true
+		}
+	}
+	macs := make([]string, 0, len(found))
+	for mac := range found {
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs, nil
+}
+
+func (m *MemoryStore) GetChanges(since time.Time) ([]Change, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var changes []Change
+	for mac, dev := range m.devices {
+		if !dev.firstSeen.Before(since) {
+			c := Change{MAC: mac, Type: ChangeNew, At: dev.firstSeen}
+			var first *AddressInfo
+			for _, addr := range dev.addresses {
+				if first == nil || addr.FirstSeen.Before(first.FirstSeen) {
+					first = addr
+				}
+			}
+			if first != nil {
+				c.IP = first.IP
+			}
+			changes = append(changes, c)
+		}
+		if !dev.lastSeen.Before(since.Add(-SessionGap)) && dev.lastSeen.Before(now.Add(-SessionGap)) {
+			changes = append(changes, Change{MAC: mac, Type: ChangeSilent, At: dev.lastSeen.Add(SessionGap)})
+		}
+	}
+	for _, c := range m.addressChanges {
+		if !c.At.Before(since) {
+			changes = append(changes, c)
+		}
+	}
+	for i := range changes {
+		changes[i].Hostname = m.hostnames[changes[i].MAC].hostname
+	}
+	for mac, h := range m.hostnames {
+		if !h.changedAt.IsZero() && !h.changedAt.Before(since) {
+			changes = append(changes, Change{MAC: mac, Type: ChangeHostname, At: h.changedAt,
+				Hostname: h.hostname, PreviousHostname: h.previous})
+		}
+	}
+	sortChanges(changes)
+	return changes, nil
+}
+
+func (m *MemoryStore) RollupEvents(retentionDays int) (int64, error) {
+	cutoff := daysAgo(retentionDays)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.counters {
+		if key.Hour.Before(cutoff) {
+			delete(m.counters, key)
+		}
+	}
+	return m.rollup(func(ev memoryEvent) bool { return ev.seenAt.Before(cutoff) }), nil
+}
+
+// rollupOldest rolls up at least n of the oldest events.
+func (m *MemoryStore) rollupOldest(n int) {
+	times := make([]time.Time, 0, m.events)
+	for _, h := range m.history {
+		for _, ev := range h.events {
+			times = append(times, ev.seenAt)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	cutoff := times[min(n, len(times)-1)]
+	m.rollup(func(ev memoryEvent) bool { return !ev.seenAt.After(cutoff) })
+}
+
+// rollup folds the events selected by old into the rollups of their MAC and address and returns
+// how many were folded.
+func (m *MemoryStore) rollup(old func(memoryEvent) bool) int64 {
+	var folded int64
+	for _, h := range m.history {
+		kept := h.events[:0]
+		for _, ev := range h.events {
+			if !old(ev) {
+				kept = append(kept, ev)
+				continue
+			}
+			folded++
+			r, exists := h.rollups[ev.mac]
+			if !exists {
+				h.rollups[ev.mac] = &memoryRollup{ipType: ev.ipType, firstSeen: ev.seenAt, lastSeen: ev.seenAt, count: 1}
+				continue
+			}
+			r.firstSeen = minTime(r.firstSeen, ev.seenAt)
+			r.lastSeen = maxTime(r.lastSeen, ev.seenAt)
+			r.count++
+		}
+		h.events = kept
+	}
+	m.events -= int(folded)
+	return folded
+}
+
+func (m *MemoryStore) Health() Health {
+	return m.health.current()
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,346 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Migration is one step of the schema history. Migrations are applied in order, each in its own
+// transaction together with its schema_version row. Released migrations must never be changed,
+// schema changes always get a new migration appended to migrations.
+type Migration struct {
+	Version     int
+	Description string
+	up          func(tx *sql.Tx) error
+}
+
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+func execSQL(stmt string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(stmt)
+		return err
+	}
+}
+
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "arp_events, dhcp_servers and mac_counters",
+		// IF NOT EXISTS: databases created before schema versioning already have some of these tables
+		up: execSQL(`
+            CREATE TABLE IF NOT EXISTS arp_events (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                ip TEXT NOT NULL,
+                ip_type TEXT NOT NULL,   -- 'ipv4' or 'ipv6'
+                mac TEXT NOT NULL,
+                seen_at DATETIME DEFAULT CURRENT_TIMESTAMP
+            );
+            CREATE TABLE IF NOT EXISTS dhcp_servers (
+                server_id TEXT NOT NULL,
+                mac TEXT NOT NULL,
+                subnet TEXT NOT NULL DEFAULT '',
+                gateway TEXT NOT NULL DEFAULT '',
+                dns TEXT NOT NULL DEFAULT '',   -- comma separated
+                allowed INTEGER NOT NULL DEFAULT 0,
+                first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+                last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+                count INTEGER NOT NULL DEFAULT 1,
+                PRIMARY KEY (server_id, mac)
+            );
+            CREATE TABLE IF NOT EXISTS mac_counters (
+                mac TEXT NOT NULL,
+                hour DATETIME NOT NULL,  -- start of the hour bucket in UTC
+                arp_packets INTEGER NOT NULL DEFAULT 0,
+                ndp_packets INTEGER NOT NULL DEFAULT 0,
+                frames INTEGER NOT NULL DEFAULT 0,
+                bytes INTEGER NOT NULL DEFAULT 0,
+                PRIMARY KEY (mac, hour)
+            );
+        `),
+	},
+	{
+		Version:     2,
+		Description: "arp_rollups for events past the retention period",
+		up: execSQL(`
+            CREATE TABLE arp_rollups (
+                mac TEXT NOT NULL,
+                ip TEXT NOT NULL,
+                ip_type TEXT NOT NULL,   -- 'ipv4' or 'ipv6'
+                first_seen DATETIME NOT NULL,
+                last_seen DATETIME NOT NULL,
+                count INTEGER NOT NULL,
+                PRIMARY KEY (mac, ip)
+            );
+        `),
+	},
+	{
+		Version:     3,
+		Description: "devices and device_addresses, filled from the event history",
+		up: execSQL(`
+            CREATE TABLE devices (
+                mac TEXT PRIMARY KEY,
+                first_seen DATETIME NOT NULL,
+                last_seen DATETIME NOT NULL
+            );
+            CREATE TABLE device_addresses (
+                mac TEXT NOT NULL,
+                ip TEXT NOT NULL,
+                ip_type TEXT NOT NULL,   -- 'ipv4' or 'ipv6'
+                first_seen DATETIME NOT NULL,
+                last_seen DATETIME NOT NULL,
+                count INTEGER NOT NULL,
+                PRIMARY KEY (mac, ip)
+            );
+            INSERT INTO device_addresses (mac, ip, ip_type, first_seen, last_seen, count)
+            SELECT mac, ip, ip_type, MIN(first_seen), MAX(last_seen), SUM(count) FROM (
+                SELECT mac, ip, ip_type, seen_at AS first_seen, seen_at AS last_seen, 1 AS count FROM arp_events
+                UNION ALL
+                SELECT mac, ip, ip_type, first_seen, last_seen, count FROM arp_rollups
+            ) GROUP BY mac, ip;
+            INSERT INTO devices (mac, first_seen, last_seen)
+            SELECT mac, MIN(first_seen), MAX(last_seen) FROM device_addresses GROUP BY mac;
+        `),
+	},
+	{
+		Version:     4,
+		Description: "timestamps normalized to UTC",
+		up:          convertTimestamps,
+	},
+	{
+		Version:     5,
+		Description: "sessions of device presence, derived from the event history",
+		up: func(tx *sql.Tx) error {
+			if err := execSQL(`
+                CREATE TABLE sessions (
+                    mac TEXT NOT NULL,
+                    started_at DATETIME NOT NULL,
+                    ended_at DATETIME NOT NULL,
+                    sightings INTEGER NOT NULL,
+                    PRIMARY KEY (mac, started_at)
+                );
+            `)(tx); err != nil {
+				return err
+			}
+			return backfillSessions(tx)
+		},
+	},
+	{
+		Version:     6,
+		Description: "indexes for time range and address queries",
+		// the arp_events index covers GetEntries, so the time range never touches the table itself
+		up: execSQL(`
+            CREATE INDEX idx_arp_events_seen_at ON arp_events (seen_at, mac, ip, ip_type);
+            CREATE INDEX idx_arp_events_ip ON arp_events (ip, seen_at);
+            CREATE INDEX idx_arp_rollups_last_seen ON arp_rollups (last_seen);
+            CREATE INDEX idx_arp_rollups_ip ON arp_rollups (ip);
+            CREATE INDEX idx_device_addresses_last_seen ON device_addresses (last_seen);
+            CREATE INDEX idx_mac_counters_hour ON mac_counters (hour);
+        `),
+	},
+	{
+		Version:     7,
+		Description: "device_annotations for names, owners, locations, notes and tags",
+		up: execSQL(`
+            CREATE TABLE device_annotations (
+                mac TEXT PRIMARY KEY,
+                name TEXT NOT NULL DEFAULT '',
+                owner TEXT NOT NULL DEFAULT '',
+                location TEXT NOT NULL DEFAULT '',
+                notes TEXT NOT NULL DEFAULT '',
+                tags TEXT NOT NULL DEFAULT '[]',   -- JSON array
+                updated_at DATETIME NOT NULL
+            );
+        `),
+	},
+	{
+		Version:     8,
+		Description: "device_approvals for the review of unknown devices",
+		up: execSQL(`
+            CREATE TABLE device_approvals (
+                mac TEXT PRIMARY KEY,
+                status TEXT NOT NULL,   -- 'approved' or 'rejected', unknown devices have no row
+                comment TEXT NOT NULL DEFAULT '',
+                decided_at DATETIME NOT NULL
+            );
+        `),
+	},
+	{
+		Version:     9,
+		Description: "audit_log of deletions",
+		up: execSQL(`
+            CREATE TABLE audit_log (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                action TEXT NOT NULL,
+                at DATETIME NOT NULL,
+                source TEXT NOT NULL,
+                reason TEXT NOT NULL DEFAULT '',
+                detail TEXT NOT NULL DEFAULT '{}'   -- JSON, never the deleted data itself
+            );
+        `),
+	},
+	{
+		Version:     10,
+		Description: "hostnames for the change feed",
+		up: execSQL(`
+            CREATE TABLE hostnames (
+                mac TEXT PRIMARY KEY,
+                hostname TEXT NOT NULL,
+                source TEXT NOT NULL,
+                previous TEXT NOT NULL DEFAULT '',
+                changed_at DATETIME   -- NULL until the hostname changes the first time
+            );
+            CREATE INDEX idx_hostnames_changed_at ON hostnames (changed_at);
+        `),
+	},
+	{
+		Version:     11,
+		Description: "address_changes for the change feed, derived from the event history",
+		// every sighting at another address than the previous one of the same family, including
+		// moves back to an address used before
+		up: execSQL(`
+            CREATE TABLE address_changes (
+                mac TEXT NOT NULL,
+                ip TEXT NOT NULL,
+                previous_ip TEXT NOT NULL,
+                changed_at DATETIME NOT NULL
+            );
+            CREATE INDEX idx_address_changes_changed_at ON address_changes (changed_at);
+            INSERT INTO address_changes (mac, ip, previous_ip, changed_at)
+            SELECT mac, ip, previous_ip, seen_at FROM (
+                SELECT mac, ip, seen_at, LAG(ip) OVER (PARTITION BY mac, ip_type ORDER BY seen_at, id) AS previous_ip
+                FROM arp_events
+            ) WHERE previous_ip IS NOT NULL AND previous_ip != ip;
+        `),
+	},
+	{
+		Version:     12,
+		Description: "interface of the last sighting per device address",
+		// earlier sightings were not recorded with their interface, those addresses have none
+		up: execSQL(`
+            ALTER TABLE device_addresses ADD COLUMN interface TEXT NOT NULL DEFAULT '';
+        `),
+	},
+	{
+		Version:     13,
+		Description: "imported_rollups to recognize rollups imported before",
+		// rollups of other sensors add up, so each one may be imported only once
+		up: execSQL(`
+            CREATE TABLE imported_rollups (
+                mac TEXT NOT NULL,
+                ip TEXT NOT NULL,
+                first_seen DATETIME NOT NULL,
+                last_seen DATETIME NOT NULL,
+                count INTEGER NOT NULL,
+                PRIMARY KEY (mac, ip, first_seen, last_seen, count)
+            );
+        `),
+	},
+}
+
+// LatestSchemaVersion is the schema version this binary migrates databases to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func createSchemaVersionTable(db *sql.DB) error {
+	_, err := db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_version (
+            version INTEGER PRIMARY KEY,
+            description TEXT NOT NULL,
+            applied_at DATETIME NOT NULL
+        );
+    `)
+	return err
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 for an unversioned database.
+func SchemaVersion(db *sql.DB) (int, error) {
+	var exists int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+	var version int
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// PendingMigrations returns the migrations not yet applied to db, without changing anything.
+func PendingMigrations(db *sql.DB) ([]Migration, error) {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if current > LatestSchemaVersion() {
+		return nil, fmt.Errorf("%w: database is at version %d, latest known version is %d",
+			ErrSchemaTooNew, current, LatestSchemaVersion())
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations and returns them. A database with a newer schema than
+// this binary knows is refused with ErrSchemaTooNew and left untouched.
+func Migrate(db *sql.DB) ([]Migration, error) {
+	if err := createSchemaVersionTable(db); err != nil {
+		return nil, err
+	}
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		if err := applyMigration(db, m); err != nil {
+			return pending[:i], fmt.Errorf("migration %d (%s): %w", m.Version, m.Description, err)
+		}
+		log.Printf("applied database migration %d: %s", m.Version, m.Description)
+	}
+	return pending, nil
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := m.up(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Description, dbTime(time.Now())); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DryRunMigrations returns the migrations InitDB would apply to the database at path. The database
+// is opened read-only and never created or modified.
+func DryRunMigrations(path string) ([]Migration, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return migrations, nil
+	}
+	db, err := OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("failed to close database: %v", err)
+		}
+	}()
+	return PendingMigrations(db)
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			log.Printf("failed to close database: %v", err)
+		}
+	})
+	return db
+}
+
+// legacySchema is the schema of releases before versioning
+const legacySchema = `CREATE TABLE arp_events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            ip TEXT NOT NULL,
+            ip_type TEXT NOT NULL,
+            mac TEXT NOT NULL,
+            seen_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        )`
+
+// migrateTo applies the migrations up to version only, to set up data for the later ones.
+func migrateTo(t *testing.T, db *sql.DB, version int) {
+	t.Helper()
+	orig := migrations
+	defer func() { migrations = orig }()
+	migrations = orig[:version]
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate to version %d failed: %v", version, err)
+	}
+}
+
+func TestMigrate_FreshDatabase(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "fresh.db"))
+
+	applied, err := Migrate(db)
+	if err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("expected %d applied migrations, got %d", len(migrations), len(applied))
+	}
+	version, err := SchemaVersion(db)
+	if err != nil || version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion() = %d, %v; want %d", version, err, LatestSchemaVersion())
+	}
+
+	applied, err = Migrate(db)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("second Migrate() = %v, %v; want nothing to apply", applied, err)
+	}
+}
+
+func TestMigrate_UnversionedDatabase(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "legacy.db"))
+	if _, err := db.Exec(legacySchema); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES (?, 'ipv4', ?, ?)`,
+		"192.168.1.10", "00:11:22:33:44:55", time.Now()); err != nil {
+		t.Fatalf("insert legacy event: %v", err)
+	}
+
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	entries, err := GetRecentEntries(db, 1)
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected legacy event to survive migration, got %+v, %v", entries, err)
+	}
+}
+
+func TestMigrate_RefusesNewerDatabase(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "newer.db"))
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, 'from the future', CURRENT_TIMESTAMP)`,
+		LatestSchemaVersion()+1); err != nil {
+		t.Fatalf("insert schema_version: %v", err)
+	}
+
+	if _, err := Migrate(db); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("expected ErrSchemaTooNew, got %v", err)
+	}
+	if err := CreateTable(db); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("expected CreateTable to refuse newer database, got %v", err)
+	}
+}
+
+func TestMigrate_FailedMigrationRollsBack(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "failing.db"))
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	orig := migrations
+	defer func() { migrations = orig }()
+	migrations = append(append([]Migration{}, orig...), Migration{
+		Version:     LatestSchemaVersion() + 1,
+		Description: "broken",
+		up: func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`CREATE TABLE half_done (id INTEGER)`); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`THIS IS NOT SQL`)
+			return err
+		},
+	})
+
+	if _, err := Migrate(db); err == nil {
+		t.Fatal("expected broken migration to fail")
+	}
+	version, err := SchemaVersion(db)
+	if err != nil || version != orig[len(orig)-1].Version {
+		t.Errorf("SchemaVersion() = %d, %v; want %d", version, err, orig[len(orig)-1].Version)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("expected half_done to be rolled back, got %d, %v", n, err)
+	}
+}
+
+func TestDryRunMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dryrun.db")
+
+	pending, err := DryRunMigrations(path)
+	if err != nil || len(pending) != len(migrations) {
+		t.Fatalf("DryRunMigrations() on missing file = %d, %v; want %d", len(pending), err, len(migrations))
+	}
+
+	db := openTestDB(t, path)
+	if _, err := db.Exec(legacySchema); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	pending, err = DryRunMigrations(path)
+	if err != nil || len(pending) != len(migrations) {
+		t.Fatalf("DryRunMigrations() = %d, %v; want %d", len(pending), err, len(migrations))
+	}
+	if version, _ := SchemaVersion(db); version != 0 {
+		t.Errorf("dry run must not change the database, got version %d", version)
+	}
+
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	pending, err = DryRunMigrations(path)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("DryRunMigrations() after Migrate = %d, %v; want 0", len(pending), err)
+	}
+}
+
+func TestMigrate_ConvertsLocalTimestamps(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "local.db"))
+	migrateTo(t, db, 3)
+	// as written by earlier versions: local time with offset, and SQLite's CURRENT_TIMESTAMP
+	if _, err := db.Exec(`
+        INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES
+            ('192.168.1.10', 'ipv4', '00:11:22:33:44:55', '2024-03-31 01:30:00.5+02:00'),
+            ('192.168.1.11', 'ipv4', '66:77:88:99:aa:bb', '2024-03-30 22:00:00');
+        INSERT INTO devices (mac, first_seen, last_seen) VALUES
+            ('00:11:22:33:44:55', '2024-03-30 19:15:00-05:00', '2024-03-31 01:30:00.5+02:00');
+        INSERT INTO mac_counters (mac, hour, arp_packets) VALUES
+            ('00:11:22:33:44:55', '2024-03-30T23:00:00Z', 1);
+        `); err != nil {
+		t.Fatalf("insert legacy rows: %v", err)
+	}
+
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	for query, want := range map[string]string{
+		`SELECT seen_at FROM arp_events WHERE mac = '00:11:22:33:44:55'`: "2024-03-30 23:30:00.500000000",
+		`SELECT seen_at FROM arp_events WHERE mac = '66:77:88:99:aa:bb'`: "2024-03-30 22:00:00.000000000",
+		`SELECT first_seen FROM devices`:                                 "2024-03-31 00:15:00.000000000",
+		`SELECT last_seen FROM devices`:                                  "2024-03-30 23:30:00.500000000",
+		`SELECT hour FROM mac_counters`:                                  "2024-03-30 23:00:00.000000000",
+	} {
+		var got string
+		if err := db.QueryRow(`SELECT CAST((` + query + `) AS TEXT)`).Scan(&got); err != nil || got != want {
+			t.Errorf("%s = %q, %v; want %q", query, got, err, want)
+		}
+	}
+}
+
+func TestMigrate_BackfillsSessions(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "sessions.db"))
+	migrateTo(t, db, 4)
+	now := time.Now()
+	for _, seenAt := range []time.Time{now.Add(-2 * time.Hour), now.Add(-110 * time.Minute), now.Add(-time.Minute)} {
+		if _, err := db.Exec(`INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES (?, 'ipv4', ?, ?)`,
+			"192.168.1.10", "00:11:22:33:44:55", dbTime(seenAt)); err != nil {
+			t.Fatalf("insert event: %v", err)
+		}
+	}
+
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	sessions, err := GetSessions(db, "00:11:22:33:44:55", 1)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("GetSessions() = %+v, %v", sessions, err)
+	}
+	if sessions[1].Sightings != 2 || sessions[1].DurationSeconds != 10*60 {
+		t.Errorf("unexpected backfilled session: %+v", sessions[1])
+	}
+}
+
+func TestMigrate_BackfillsAddressChanges(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "changes.db"))
+	migrateTo(t, db, 10)
+	now := time.Now()
+	for _, e := range []struct {
+		ip     string
+		seenAt time.Time
+	}{
+		{"192.168.1.10", now.Add(-3 * time.Hour)},
+		{"192.168.1.10", now.Add(-2 * time.Hour)},
+		{"192.168.1.11", now.Add(-time.Hour)},
+		{"192.168.1.10", now.Add(-time.Minute)},
+	} {
+		if _, err := db.Exec(`INSERT INTO arp_events (ip, ip_type, mac, seen_at) VALUES (?, 'ipv4', ?, ?)`,
+			e.ip, "00:11:22:33:44:55", dbTime(e.seenAt)); err != nil {
+			t.Fatalf("insert event: %v", err)
+		}
+	}
+
+	if _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	changes, err := GetChanges(db, now.Add(-4*time.Hour))
+	if err != nil || len(changes) != 2 {
+		t.Fatalf("GetChanges() = %+v, %v", changes, err)
+	}
+	if changes[0].IP != "192.168.1.11" || changes[0].PreviousIP != "192.168.1.10" ||
+		changes[1].IP != "192.168.1.10" || changes[1].PreviousIP != "192.168.1.11" {
+		t.Errorf("unexpected backfilled changes: %+v", changes)
+	}
+}
+
+func TestForgetTables_CoverEveryMACColumn(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "arp.db"))
+	if err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	rows, err := db.Query(`
+        SELECT m.name FROM sqlite_master m, pragma_table_info(m.name) c
+        WHERE m.type = 'table' AND c.name = 'mac' ORDER BY m.name`)
+	if err != nil {
+		t.Fatalf("query tables: %v", err)
+	}
+	defer func() { _ = rows.Close() }()
+	covered := make(map[string]bool)
+	for _, table := range forgetTables {
+		covered[table] = true
+	}
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		if !covered[table] {
+			t.Errorf("table %s has a mac column but ForgetDevice does not delete from it", table)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// RollupEvents folds arp_events older than retentionDays into arp_rollups, keeping first/last
+// sighting and count per MAC and IP, and deletes them from arp_events. The hourly packet counters
+// of that age are deleted too. It returns the number of deleted events.
+func RollupEvents(db *sql.DB, retentionDays int) (int64, error) {
+	cutoff := dbTime(daysAgo(retentionDays))
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO arp_rollups (mac, ip, ip_type, first_seen, last_seen, count)
+        SELECT mac, ip, ip_type, MIN(seen_at), MAX(seen_at), COUNT(*) FROM arp_events
+        WHERE seen_at < ?
+        GROUP BY mac, ip
+        ON CONFLICT (mac, ip) DO UPDATE SET
+            first_seen = MIN(first_seen, excluded.first_seen),
+            last_seen = MAX(last_seen, excluded.last_seen),
+            count = count + excluded.count
+        `, cutoff)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM arp_events WHERE seen_at < ?`, cutoff)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM mac_counters WHERE hour < ?`, cutoff); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunRetention rolls up expired events right away and then every interval. A retentionDays
+// of 0 or less keeps raw events forever.
+func RunRetention(store Store, retentionDays int, interval time.Duration) {
+	if retentionDays <= 0 {
+		return
+	}
+	for {
+		deleted, err := store.RollupEvents(retentionDays)
+		if err != nil {
+			log.Printf("retention: failed to roll up events: %v", err)
+		} else if deleted > 0 {
+			log.Printf("retention: rolled up %d events older than %d days", deleted, retentionDays)
+		}
+		time.Sleep(interval)
+	}
+}
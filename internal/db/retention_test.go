@@ -0,0 +1,86 @@
+package db
+
+import (
+	"log"
+	"testing"
+	"time"
+)
+
+func TestRollupEvents(t *testing.T) {
+	db, err := InitDB(":memory:")
+	if err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("failed to close database: %v", err)
+		}
+	}()
+
+	now := time.Now()
+	insertAt := func(ip, ipType, mac string, seenAt time.Time) {
+		if err := insertEvent(db, ip, ipType, mac, seenAt); err != nil {
+			t.Fatalf("insert event: %v", err)
+		}
+	}
+	// a device seen only long ago and one that is still active
+	insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.AddDate(0, 0, -50))
+	insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.AddDate(0, 0, -45))
+	insertAt("fe80::1", "ipv6", "00:11:22:33:44:55", now.AddDate(0, 0, -40))
+	insertAt("192.168.1.11", "ipv4", "66:77:88:99:AA:BB", now.AddDate(0, 0, -35))
+	insertAt("192.168.1.11", "ipv4", "66:77:88:99:AA:BB", now.AddDate(0, 0, -1))
+
+	deleted, err := RollupEvents(db, 30)
+	if err != nil {
+		t.Fatalf("RollupEvents failed: %v", err)
+	}
+	if deleted != 4 {
+		t.Errorf("expected 4 rolled up events, got %d", deleted)
+	}
+
+	var raw, rollups, count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM arp_events`).Scan(&raw); err != nil || raw != 1 {
+		t.Errorf("expected 1 raw event left, got %d, %v", raw, err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM arp_rollups`).Scan(&rollups); err != nil || rollups != 3 {
+		t.Errorf("expected 3 rollups, got %d, %v", rollups, err)
+	}
+	var firstSeen time.Time
+	if err := db.QueryRow(`SELECT first_seen, count FROM arp_rollups WHERE mac = ? AND ip = ?`,
+		"00:11:22:33:44:55", "192.168.1.10").Scan(&firstSeen, &count); err != nil {
+		t.Fatalf("query rollup: %v", err)
+	}
+	if count != 2 || !firstSeen.Equal(now.AddDate(0, 0, -50)) {
+		t.Errorf("expected count 2 since %v, got %d since %v", now.AddDate(0, 0, -50), count, firstSeen)
+	}
+
+	// rolling up again merges into the existing rollup
+	insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.AddDate(0, 0, -31))
+	if _, err := RollupEvents(db, 30); err != nil {
+		t.Fatalf("RollupEvents failed: %v", err)
+	}
+	if err := db.QueryRow(`SELECT first_seen, count FROM arp_rollups WHERE mac = ? AND ip = ?`,
+		"00:11:22:33:44:55", "192.168.1.10").Scan(&firstSeen, &count); err != nil {
+		t.Fatalf("query rollup: %v", err)
+	}
+	if count != 3 || !firstSeen.Equal(now.AddDate(0, 0, -50)) {
+		t.Errorf("expected merged count 3 since %v, got %d since %v", now.AddDate(0, 0, -50), count, firstSeen)
+	}
+
+	// GetRecentEntries combines raw events and rollups
+	entries, err := GetRecentEntries(db, 7)
+	if err != nil || len(entries) != 1 || entries[0].MAC != "66:77:88:99:AA:BB" {
+		t.Errorf("expected only the active device in the last 7 days, got %+v, %v", entries, err)
+	}
+	entries, err = GetRecentEntries(db, 60)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected both devices in the last 60 days, got %+v, %v", entries, err)
+	}
+	old := entries[0]
+	if old.MAC != "00:11:22:33:44:55" || len(old.IPv4) != 1 || len(old.IPv6) != 1 {
+		t.Errorf("unexpected rolled up device: %+v", old)
+	}
+	if !old.LastSeen.Equal(now.AddDate(0, 0, -31)) {
+		t.Errorf("expected last seen %v, got %v", now.AddDate(0, 0, -31), old.LastSeen)
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"sort"
+	"time"
+)
+
+// SessionGap is how long a device may stay silent and still count as present. A sighting
+// further than SessionGap from all sessions of its MAC starts a new session. Changing it only
+// affects sessions recorded from then on.
+var SessionGap = 10 * time.Minute
+
+// Session is a period in which a device was present, from its first to its last sighting.
+type Session struct {
+	MAC             string    `json:"mac"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	DurationSeconds int64     `json:"duration_seconds"`
+	Sightings       int64     `json:"sightings"`
+	// Active is set while the device has not been silent for SessionGap yet.
+	Active bool `json:"active"`
+}
+
+// finish derives the duration and active state of a session read from storage.
+func (s *Session) finish(now time.Time) {
+	s.DurationSeconds = int64(s.End.Sub(s.Start) / time.Second)
+	s.Active = now.Sub(s.End) <= SessionGap
+}
+
+// recordSession adds a sighting of mac to its sessions. All sessions within SessionGap of seenAt
+// are merged into one, so out of order sightings (e.g. from an import) may join two sessions.
+func recordSession(tx *sql.Tx, mac string, seenAt time.Time) error {
+	from, to := dbTime(seenAt.Add(-SessionGap)), dbTime(seenAt.Add(SessionGap))
+	rows, err := tx.Query(`SELECT started_at, ended_at, sightings FROM sessions
+        WHERE mac = ? AND ended_at >= ? AND started_at <= ?`, mac, from, to)
+	if err != nil {
+		return err
+	}
+	merged := Session{Start: seenAt, End: seenAt, Sightings: 1}
+	var found int
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.Start, &s.End, &s.Sightings); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		merged.Start = minTime(merged.Start, s.Start)
+		merged.End = maxTime(merged.End, s.End)
+		merged.Sightings += s.Sightings
+		found++
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	if found > 0 {
+		if _, err := tx.Exec(`DELETE FROM sessions WHERE mac = ? AND ended_at >= ? AND started_at <= ?`,
+			mac, from, to); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO sessions (mac, started_at, ended_at, sightings) VALUES (?, ?, ?, ?)`,
+		mac, dbTime(merged.Start), dbTime(merged.End), merged.Sightings)
+	return err
+}
+
+// backfillSessions derives the sessions of all raw events. Rolled up events have no single
+// sightings anymore and are left out.
+func backfillSessions(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT mac, seen_at FROM arp_events WHERE seen_at IS NOT NULL ORDER BY mac, seen_at`)
+	if err != nil {
+		return err
+	}
+	var sessions []Session
+	for rows.Next() {
+		var mac string
+		var seenAt time.Time
+		if err := rows.Scan(&mac, &seenAt); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		if n := len(sessions); n > 0 && sessions[n-1].MAC == mac && seenAt.Sub(sessions[n-1].End) <= SessionGap {
+			sessions[n-1].End = seenAt
+			sessions[n-1].Sightings++
+			continue
+		}
+		sessions = append(sessions, Session{MAC: mac, Start: seenAt, End: seenAt, Sightings: 1})
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, s := range sessions {
+		if _, err := tx.Exec(`INSERT INTO sessions (mac, started_at, ended_at, sightings) VALUES (?, ?, ?, ?)`,
+			s.MAC, dbTime(s.Start), dbTime(s.End), s.Sightings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSessions returns the sessions of mac that lasted into the last days, newest first.
+func GetSessions(db *sql.DB, mac string, days int) ([]Session, error) {
+	rows, err := db.Query(`
+        SELECT mac, started_at, ended_at, sightings FROM sessions
+        WHERE mac = ? AND ended_at >= ? ORDER BY started_at DESC
+        `, mac, dbTime(daysAgo(days)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("error closing rows: %v", err)
+		}
+	}()
+
+	now := time.Now()
+	sessions := []Session{}
+	var skipped skippedRows
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.MAC, &s.Start, &s.End, &s.Sightings); err != nil {
+			skipped.add(err)
+			continue
+		}
+		s.finish(now)
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, skipped.err()
+}
+
+// mergeSession is recordSession for the sessions of one MAC held in memory.
+func mergeSession(sessions []Session, mac string, seenAt time.Time) []Session {
+	merged := Session{MAC: mac, Start: seenAt, End: seenAt, Sightings: 1}
+	kept := sessions[:0]
+	for _, s := range sessions {
+		if s.End.Before(seenAt.Add(-SessionGap)) || s.Start.After(seenAt.Add(SessionGap)) {
+			kept = append(kept, s)
+			continue
+		}
+		merged.Start = minTime(merged.Start, s.Start)
+		merged.End = maxTime(merged.End, s.End)
+		merged.Sightings += s.Sightings
+	}
+	kept = append(kept, merged)
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].Start.Before(kept[j].Start)
+	})
+	return kept
+}
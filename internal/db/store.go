@@ -0,0 +1,219 @@
+package db
+
+import (
+	"database/sql"
+	"net/netip"
+	"time"
+)
+
+// Store is the storage behind the sniffer and the API. Queries that could not read all rows
+// return what they read together with a *PartialError.
+type Store interface {
+	// InsertARPEvent records a sighting of ip at mac.
+	InsertARPEvent(ip, mac string) error
+	// GetRecentEntries returns all devices seen in the last days, ordered by MAC.
+	GetRecentEntries(days int) ([]ArpEntry, error)
+	// GetEntries returns all devices seen between since and until (open ended if zero), ordered by MAC.
+	GetEntries(since, until time.Time) ([]ArpEntry, error)
+	// GetEntry returns mac with all addresses it ever used, nil if it was never seen.
+	GetEntry(mac string) (*ArpEntry, error)
+	// CountDevices returns per capture interface and IP family the number of devices with an
+	// address of the family seen on the interface since since.
+	CountDevices(since time.Time) ([]DeviceCount, error)
+
+	// RecordDHCPServer records a DHCP OFFER/ACK sighting of server.
+	RecordDHCPServer(server DHCPServer) error
+	GetDHCPServers() ([]DHCPServer, error)
+
+	AddPacketCounts(counts map[CounterKey]PacketCounts) error
+	GetPacketCounts(hours int) ([]MACCounters, error)
+
+	// GetSessions returns the presence sessions of mac that lasted into the last days, newest first.
+	GetSessions(mac string, days int) ([]Session, error)
+
+	// GetIPHistory returns which MACs used the addresses in prefix over time, ordered by address
+	// and time. A single address is a /32 or /128 prefix.
+	GetIPHistory(prefix netip.Prefix) ([]IPBinding, error)
+
+	// SetAnnotation replaces the annotation of a.MAC and returns it as stored.
+	SetAnnotation(a Annotation) (Annotation, error)
+	// GetAnnotation returns the annotation of mac, nil if it has none.
+	GetAnnotation(mac string) (*Annotation, error)
+	// DeleteAnnotation removes the annotation of mac and reports whether there was one.
+	DeleteAnnotation(mac string) (bool, error)
+
+	// SetDeviceStatus approves or rejects mac and returns the device.
+	SetDeviceStatus(mac, status, comment string) (Device, error)
+	// GetDevice returns the review state of mac, nil if it was neither seen nor decided on.
+	GetDevice(mac string) (*Device, error)
+	// GetDevices returns the devices with status ("" for all), ordered by MAC.
+	GetDevices(status string) ([]Device, error)
+
+	// ForgetDevice deletes all data about mac and records that in the audit log. A nil entry
+	// means nothing was stored about mac.
+	ForgetDevice(mac, source, reason string) (*AuditEntry, error)
+	// GetAuditLog returns all audit entries, oldest first.
+	GetAuditLog() ([]AuditEntry, error)
+
+	// RecordHostname remembers the hostname mac resolved to, for GetChanges.
+	RecordHostname(mac, hostname, source string) error
+	// FindHostname returns the MACs whose annotated name or last recorded hostname is name,
+	// ignoring case and a trailing dot.
+	FindHostname(name string) ([]string, error)
+	// GetChanges returns the changes since since, ordered by time, with the last recorded hostname
+	// of the device.
+	GetChanges(since time.Time) ([]Change, error)
+
+	// RollupEvents folds raw events older than retentionDays into per MAC/IP summaries, deletes
+	// the packet counters of that age and returns the number of removed raw events.
+	RollupEvents(retentionDays int) (int64, error)
+
+	// Health reports the inserts, failed inserts and unreadable rows of the store since it was
+	// opened.
+	Health() Health
+
+	Close() error
+}
+
+// SQLiteStore is the persistent Store, backed by the package level SQLite functions.
+type SQLiteStore struct {
+	DB     *sql.DB
+	health healthTracker
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// NewSQLiteStore opens the database at path and applies pending migrations.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := InitDB(path)
+	if err != nil {
+		if db != nil {
+			_ = db.Close()
+		}
+		return nil, err
+	}
+	return &SQLiteStore{DB: db}, nil
+}
+
+func (s *SQLiteStore) InsertARPEvent(ip, mac string) error {
+	err := InsertARPEvent(s.DB, ip, mac)
+	s.health.recordInsert(err)
+	return err
+}
+
+func (s *SQLiteStore) GetRecentEntries(days int) ([]ArpEntry, error) {
+	entries, err := GetRecentEntries(s.DB, days)
+	return entries, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) GetEntries(since, until time.Time) ([]ArpEntry, error) {
+	entries, err := GetEntries(s.DB, since, until)
+	return entries, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) GetEntry(mac string) (*ArpEntry, error) {
+	entry, err := GetEntry(s.DB, mac)
+	return entry, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) CountDevices(since time.Time) ([]DeviceCount, error) {
+	counts, err := CountDevices(s.DB, since)
+	return counts, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) RecordDHCPServer(server DHCPServer) error {
+	err := RecordDHCPServer(s.DB, server)
+	s.health.recordInsert(err)
+	return err
+}
+
+func (s *SQLiteStore) GetDHCPServers() ([]DHCPServer, error) {
+	servers, err := GetDHCPServers(s.DB)
+	return servers, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) AddPacketCounts(counts map[CounterKey]PacketCounts) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	err := AddPacketCounts(s.DB, counts)
+	s.health.recordInsert(err)
+	return err
+}
+
+func (s *SQLiteStore) GetPacketCounts(hours int) ([]MACCounters, error) {
+	counters, err := GetPacketCounts(s.DB, hours)
+	return counters, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) GetSessions(mac string, days int) ([]Session, error) {
+	sessions, err := GetSessions(s.DB, mac, days)
+	return sessions, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) GetIPHistory(prefix netip.Prefix) ([]IPBinding, error) {
+	bindings, err := GetIPHistory(s.DB, prefix)
+	return bindings, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) SetAnnotation(a Annotation) (Annotation, error) {
+	return SetAnnotation(s.DB, a)
+}
+
+func (s *SQLiteStore) GetAnnotation(mac string) (*Annotation, error) {
+	annotation, err := GetAnnotation(s.DB, mac)
+	return annotation, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) DeleteAnnotation(mac string) (bool, error) {
+	return DeleteAnnotation(s.DB, mac)
+}
+
+func (s *SQLiteStore) SetDeviceStatus(mac, status, comment string) (Device, error) {
+	return SetDeviceStatus(s.DB, mac, status, comment)
+}
+
+func (s *SQLiteStore) GetDevice(mac string) (*Device, error) {
+	device, err := GetDevice(s.DB, mac)
+	return device, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) GetDevices(status string) ([]Device, error) {
+	devices, err := GetDevices(s.DB, status)
+	return devices, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) ForgetDevice(mac, source, reason string) (*AuditEntry, error) {
+	return ForgetDevice(s.DB, mac, source, reason)
+}
+
+func (s *SQLiteStore) GetAuditLog() ([]AuditEntry, error) {
+	entries, err := GetAuditLog(s.DB)
+	return entries, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) RecordHostname(mac, hostname, source string) error {
+	return RecordHostname(s.DB, mac, hostname, source)
+}
+
+func (s *SQLiteStore) FindHostname(name string) ([]string, error) {
+	macs, err := FindHostname(s.DB, name)
+	return macs, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) GetChanges(since time.Time) ([]Change, error) {
+	changes, err := GetChanges(s.DB, since)
+	return changes, s.health.recordResult(err)
+}
+
+func (s *SQLiteStore) RollupEvents(retentionDays int) (int64, error) {
+	return RollupEvents(s.DB, retentionDays)
+}
+
+func (s *SQLiteStore) Health() Health {
+	return s.health.current()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.DB.Close()
+}
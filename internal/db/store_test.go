@@ -0,0 +1,783 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// storeUnderTest is a fresh Store plus a way to insert sightings at a given time.
+type storeUnderTest struct {
+	Store
+	insertAt func(ip, ipType, mac string, seenAt time.Time)
+}
+
+var storeFactories = map[string]func(t *testing.T) storeUnderTest{
+	"sqlite": func(t *testing.T) storeUnderTest {
+		s, err := NewSQLiteStore(":memory:")
+		if err != nil {
+			t.Fatalf("NewSQLiteStore failed: %v", err)
+		}
+		t.Cleanup(func() {
+			if err := s.Close(); err != nil {
+				log.Printf("failed to close store: %v", err)
+			}
+		})
+		return storeUnderTest{s, func(ip, ipType, mac string, seenAt time.Time) {
+			if err := insertEvent(s.DB, ip, ipType, mac, seenAt); err != nil {
+				t.Fatalf("insertEvent failed: %v", err)
+			}
+		}}
+	},
+	"memory": func(t *testing.T) storeUnderTest {
+		s := NewMemoryStore()
+		return storeUnderTest{s, s.insertEvent}
+	},
+}
+
+func forEachStore(t *testing.T, test func(t *testing.T, s storeUnderTest)) {
+	for name, factory := range storeFactories {
+		t.Run(name, func(t *testing.T) {
+			test(t, factory(t))
+		})
+	}
+}
+
+func TestStore_RecentEntries(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		if err := s.InsertARPEvent("192.168.1.11", "66:77:88:99:AA:BB"); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.Add(-3*time.Hour))
+		s.insertAt("fe80::1", "ipv6", "00:11:22:33:44:55", now.Add(-2*time.Hour))
+		s.insertAt("192.168.1.20", "ipv4", "00:11:22:33:44:55", now.Add(-time.Hour))
+		s.insertAt("10.0.0.1", "ipv4", "aa:aa:aa:aa:aa:aa", now.AddDate(0, 0, -10))
+
+		entries, err := s.GetRecentEntries(1)
+		if err != nil {
+			t.Fatalf("GetRecentEntries failed: %v", err)
+		}
+		if len(entries) != 2 {
+			t.Fatalf("expected 2 MACs, got %+v", entries)
+		}
+		e := entries[0]
+		if e.MAC != "00:11:22:33:44:55" || len(e.IPv4) != 2 || e.IPv4[0] != "192.168.1.20" || len(e.IPv6) != 1 {
+			t.Errorf("unexpected entry: %+v", e)
+		}
+		if !e.LastSeen.Equal(now.Add(-time.Hour)) || !e.FirstSeen.Equal(now.Add(-3*time.Hour)) {
+			t.Errorf("unexpected first/last seen: %v / %v", e.FirstSeen, e.LastSeen)
+		}
+		if len(e.Addresses) != 3 || e.Addresses[0].IP != "192.168.1.20" || e.Addresses[2].IP != "192.168.1.10" {
+			t.Errorf("unexpected addresses: %+v", e.Addresses)
+		}
+		if entries[1].MAC != "66:77:88:99:AA:BB" || len(entries[1].IPv4) != 1 || entries[1].Addresses[0].Count != 1 {
+			t.Errorf("unexpected entry: %+v", entries[1])
+		}
+
+		entries, err = s.GetRecentEntries(30)
+		if err != nil || len(entries) != 3 {
+			t.Errorf("expected 3 MACs in 30 days, got %+v, %v", entries, err)
+		}
+	})
+}
+
+func TestStore_RecordsInterface(t *testing.T) {
+	origIface := CaptureInterface
+	defer func() { CaptureInterface = origIface }()
+
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		CaptureInterface = "eth0"
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.Add(-2*time.Hour))
+		CaptureInterface = "eth1"
+		s.insertAt("192.168.1.20", "ipv4", "00:11:22:33:44:55", now.Add(-time.Hour))
+		// a late sighting does not replace the interface of a later one
+		CaptureInterface = "eth2"
+		s.insertAt("192.168.1.20", "ipv4", "00:11:22:33:44:55", now.Add(-90*time.Minute))
+
+		entry, err := s.GetEntry("00:11:22:33:44:55")
+		if err != nil || entry == nil {
+			t.Fatalf("GetEntry() = %v, %v", entry, err)
+		}
+		if entry.Interface != "eth1" || len(entry.Addresses) != 2 ||
+			entry.Addresses[0].Interface != "eth1" || entry.Addresses[1].Interface != "eth0" {
+			t.Errorf("unexpected interfaces: %+v", entry)
+		}
+		entries, err := s.GetEntries(now.Add(-3*time.Hour), time.Time{})
+		if err != nil || len(entries) != 1 || entries[0].Interface != "eth1" {
+			t.Errorf("GetEntries() = %+v, %v", entries, err)
+		}
+	})
+}
+
+func TestStore_CountDevices(t *testing.T) {
+	origIface := CaptureInterface
+	defer func() { CaptureInterface = origIface }()
+
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		CaptureInterface = "eth0"
+		// two addresses of one family count the device once
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.Add(-3*time.Hour))
+		s.insertAt("192.168.1.20", "ipv4", "00:11:22:33:44:55", now.Add(-time.Hour))
+		s.insertAt("fe80::1", "ipv6", "00:11:22:33:44:55", now.Add(-2*time.Hour))
+		s.insertAt("10.0.0.1", "ipv4", "aa:aa:aa:aa:aa:aa", now.AddDate(0, 0, -10))
+		CaptureInterface = "eth1"
+		s.insertAt("192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now.Add(-time.Minute))
+
+		for _, tt := range []struct {
+			since time.Time
+			want  map[string]int
+		}{
+			{now.Add(-5 * time.Minute), map[string]int{"eth1/ipv4": 1}},
+			{now.Add(-90 * time.Minute), map[string]int{"eth0/ipv4": 1, "eth1/ipv4": 1}},
+			{now.Add(-24 * time.Hour), map[string]int{"eth0/ipv4": 1, "eth0/ipv6": 1, "eth1/ipv4": 1}},
+			{now.AddDate(0, 0, -30), map[string]int{"eth0/ipv4": 2, "eth0/ipv6": 1, "eth1/ipv4": 1}},
+		} {
+			counts, err := s.CountDevices(tt.since)
+			got := make(map[string]int)
+			for _, c := range counts {
+				got[c.Interface+"/"+c.Family] = c.Devices
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CountDevices(%v) = %v, %v; want %v", now.Sub(tt.since), got, err, tt.want)
+			}
+		}
+	})
+}
+
+func TestStore_RollupEvents(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.AddDate(0, 0, -50))
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.AddDate(0, 0, -40))
+		s.insertAt("192.168.1.11", "ipv4", "66:77:88:99:AA:BB", now.Add(-time.Hour))
+		if err := s.AddPacketCounts(map[CounterKey]PacketCounts{
+			{MAC: "00:11:22:33:44:55", Hour: now.AddDate(0, 0, -40).Truncate(time.Hour)}: {ARPPackets: 1, Frames: 1, Bytes: 60},
+			{MAC: "66:77:88:99:aa:bb", Hour: now.Add(-time.Hour).Truncate(time.Hour)}:    {ARPPackets: 1, Frames: 1, Bytes: 60},
+		}); err != nil {
+			t.Fatalf("AddPacketCounts failed: %v", err)
+		}
+
+		deleted, err := s.RollupEvents(30)
+		if err != nil || deleted != 2 {
+			t.Fatalf("RollupEvents() = %d, %v; want 2", deleted, err)
+		}
+		if deleted, _ := s.RollupEvents(30); deleted != 0 {
+			t.Errorf("expected nothing left to roll up, got %d", deleted)
+		}
+
+		entries, err := s.GetRecentEntries(7)
+		if err != nil || len(entries) != 1 {
+			t.Errorf("expected 1 MAC in 7 days, got %+v, %v", entries, err)
+		}
+		entries, err = s.GetRecentEntries(60)
+		if err != nil || len(entries) != 2 {
+			t.Fatalf("expected 2 MACs in 60 days, got %+v, %v", entries, err)
+		}
+		old := entries[0]
+		if !old.LastSeen.Equal(now.AddDate(0, 0, -40)) || !old.FirstSeen.Equal(now.AddDate(0, 0, -50)) {
+			t.Errorf("unexpected rolled up entry: %+v", old)
+		}
+		if len(old.Addresses) != 1 || old.Addresses[0].Count != 2 {
+			t.Errorf("expected address history to survive the rollup, got %+v", old.Addresses)
+		}
+		counters, err := s.GetPacketCounts(60 * 24)
+		if err != nil || len(counters) != 1 || counters[0].MAC != "66:77:88:99:aa:bb" {
+			t.Errorf("expected only the recent packet counters, got %+v, %v", counters, err)
+		}
+	})
+}
+
+func TestStore_DHCPServers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		for _, server := range []DHCPServer{
+			{ServerID: "192.168.1.1", MAC: "00:11:22:33:44:55", DNS: []string{"192.168.1.1"}, Allowed: true},
+			{ServerID: "192.168.1.1", MAC: "00:11:22:33:44:55", Gateway: "192.168.1.254", Allowed: true},
+			{ServerID: "192.168.0.1", MAC: "66:77:88:99:aa:bb"},
+		} {
+			if err := s.RecordDHCPServer(server); err != nil {
+				t.Fatalf("RecordDHCPServer failed: %v", err)
+			}
+		}
+
+		servers, err := s.GetDHCPServers()
+		if err != nil || len(servers) != 2 {
+			t.Fatalf("GetDHCPServers() = %+v, %v", servers, err)
+		}
+		if servers[0].ServerID != "192.168.0.1" || servers[0].Count != 1 || servers[0].Allowed {
+			t.Errorf("unexpected server: %+v", servers[0])
+		}
+		known := servers[1]
+		if known.Count != 2 || known.Gateway != "192.168.1.254" || len(known.DNS) != 0 || known.FirstSeen.After(known.LastSeen) {
+			t.Errorf("unexpected server: %+v", known)
+		}
+	})
+}
+
+func TestStore_PacketCounts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		hour := time.Now().UTC().Truncate(time.Hour)
+		if err := s.AddPacketCounts(map[CounterKey]PacketCounts{
+			{MAC: "00:11:22:33:44:55", Hour: hour}:                      {ARPPackets: 1, Frames: 1, Bytes: 60},
+			{MAC: "66:77:88:99:aa:bb", Hour: hour}:                      {ARPPackets: 10, Frames: 10, Bytes: 600},
+			{MAC: "66:77:88:99:aa:bb", Hour: hour.Add(-time.Hour)}:      {NDPPackets: 5, Frames: 5, Bytes: 430},
+			{MAC: "66:77:88:99:aa:bb", Hour: hour.Add(-48 * time.Hour)}: {ARPPackets: 1000},
+		}); err != nil {
+			t.Fatalf("AddPacketCounts failed: %v", err)
+		}
+		if err := s.AddPacketCounts(map[CounterKey]PacketCounts{
+			{MAC: "66:77:88:99:aa:bb", Hour: hour.Add(5 * time.Minute)}: {ARPPackets: 2, Frames: 2, Bytes: 120},
+		}); err != nil {
+			t.Fatalf("AddPacketCounts failed: %v", err)
+		}
+
+		counters, err := s.GetPacketCounts(3)
+		if err != nil || len(counters) != 2 {
+			t.Fatalf("GetPacketCounts() = %+v, %v", counters, err)
+		}
+		busy := counters[0]
+		if busy.MAC != "66:77:88:99:aa:bb" || busy.ARPPackets != 12 || busy.NDPPackets != 5 || len(busy.Hours) != 2 ||
+			!busy.Hours[0].Hour.Equal(hour) {
+			t.Errorf("unexpected counters: %+v", busy)
+		}
+	})
+}
+
+func TestStore_WindowsAcrossTimeZones(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("load Europe/Berlin: %v", err)
+	}
+	zones := []*time.Location{time.UTC, time.FixedZone("UTC+14", 14*3600), time.FixedZone("UTC-12", -12*3600), berlin}
+
+	orig := time.Local
+	defer func() { time.Local = orig }()
+	for _, zone := range zones {
+		time.Local = zone
+		t.Run(zone.String(), func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, s storeUnderTest) {
+				now := time.Now().In(zone)
+				s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.Add(-23*time.Hour))
+				s.insertAt("192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now.Add(-25*time.Hour))
+
+				entries, err := s.GetRecentEntries(1)
+				if err != nil || len(entries) != 1 || entries[0].MAC != "00:11:22:33:44:55" {
+					t.Fatalf("GetRecentEntries(1) = %+v, %v", entries, err)
+				}
+				if !entries[0].LastSeen.Equal(now.Add(-23*time.Hour)) || entries[0].LastSeen.Location() != time.UTC {
+					t.Errorf("expected last seen %v in UTC, got %v", now.Add(-23*time.Hour), entries[0].LastSeen)
+				}
+
+				// only the sighting 25 hours ago is past a retention of one day
+				s.insertAt("192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now.Add(-23*time.Hour))
+				deleted, err := s.RollupEvents(1)
+				if err != nil || deleted != 1 {
+					t.Errorf("RollupEvents(1) = %d, %v; want 1", deleted, err)
+				}
+			})
+		})
+	}
+}
+
+func TestStore_Sessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		mac := "00:11:22:33:44:55"
+		s.insertAt("192.168.1.10", "ipv4", mac, now.Add(-5*time.Hour))
+		s.insertAt("192.168.1.10", "ipv4", mac, now.Add(-5*time.Hour+5*time.Minute))
+		// out of order: the last sighting bridges the gap between the first two
+		s.insertAt("192.168.1.10", "ipv4", mac, now.Add(-3*time.Hour))
+		s.insertAt("fe80::1", "ipv6", mac, now.Add(-3*time.Hour+16*time.Minute))
+		s.insertAt("192.168.1.10", "ipv4", mac, now.Add(-3*time.Hour+8*time.Minute))
+		s.insertAt("192.168.1.10", "ipv4", mac, now.Add(-2*time.Minute))
+		s.insertAt("192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now)
+		s.insertAt("192.168.1.10", "ipv4", mac, now.AddDate(0, 0, -3))
+
+		sessions, err := s.GetSessions(mac, 1)
+		if err != nil || len(sessions) != 3 {
+			t.Fatalf("GetSessions() = %+v, %v", sessions, err)
+		}
+		if current := sessions[0]; !current.Active || current.Sightings != 1 || current.DurationSeconds != 0 {
+			t.Errorf("unexpected current session: %+v", current)
+		}
+		if bridged := sessions[1]; bridged.Active || bridged.Sightings != 3 || bridged.DurationSeconds != 16*60 ||
+			!bridged.Start.Equal(now.Add(-3*time.Hour)) {
+			t.Errorf("unexpected bridged session: %+v", bridged)
+		}
+		if first := sessions[2]; first.Sightings != 2 || first.DurationSeconds != 5*60 || first.MAC != mac {
+			t.Errorf("unexpected first session: %+v", first)
+		}
+
+		if sessions, err := s.GetSessions("aa:aa:aa:aa:aa:aa", 1); err != nil || sessions == nil || len(sessions) != 0 {
+			t.Errorf("expected no sessions for an unknown MAC, got %+v, %v", sessions, err)
+		}
+	})
+}
+
+func TestStore_IPHistory(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		s.insertAt("192.168.1.57", "ipv4", "dd:dd:dd:dd:dd:dd", now.AddDate(0, 0, -40))
+		if _, err := s.RollupEvents(30); err != nil {
+			t.Fatalf("RollupEvents failed: %v", err)
+		}
+		s.insertAt("192.168.1.57", "ipv4", "aa:aa:aa:aa:aa:aa", now.Add(-5*time.Hour))
+		s.insertAt("192.168.1.57", "ipv4", "aa:aa:aa:aa:aa:aa", now.Add(-4*time.Hour))
+		s.insertAt("192.168.1.57", "ipv4", "bb:bb:bb:bb:bb:bb", now.Add(-3*time.Hour))
+		s.insertAt("192.168.1.57", "ipv4", "aa:aa:aa:aa:aa:aa", now.Add(-time.Hour))
+		s.insertAt("192.168.1.100", "ipv4", "cc:cc:cc:cc:cc:cc", now)
+		s.insertAt("fe80::1", "ipv6", "aa:aa:aa:aa:aa:aa", now)
+
+		bindings, err := s.GetIPHistory(netip.MustParsePrefix("192.168.1.57/32"))
+		if err != nil || len(bindings) != 4 {
+			t.Fatalf("GetIPHistory(/32) = %+v, %v", bindings, err)
+		}
+		if !bindings[0].RolledUp || bindings[0].MAC != "dd:dd:dd:dd:dd:dd" {
+			t.Errorf("expected the rolled up binding first, got %+v", bindings[0])
+		}
+		if b := bindings[1]; b.MAC != "aa:aa:aa:aa:aa:aa" || b.Sightings != 2 || b.RolledUp ||
+			!b.FirstSeen.Equal(now.Add(-5*time.Hour)) || !b.LastSeen.Equal(now.Add(-4*time.Hour)) {
+			t.Errorf("unexpected first binding of aa: %+v", b)
+		}
+		if bindings[2].MAC != "bb:bb:bb:bb:bb:bb" || bindings[3].MAC != "aa:aa:aa:aa:aa:aa" {
+			t.Errorf("expected bb and then aa again, got %+v", bindings[2:])
+		}
+
+		bindings, err = s.GetIPHistory(netip.MustParsePrefix("192.168.1.0/24"))
+		if err != nil || len(bindings) != 5 || bindings[4].IP != "192.168.1.100" {
+			t.Errorf("GetIPHistory(/24) = %+v, %v", bindings, err)
+		}
+		bindings, err = s.GetIPHistory(netip.MustParsePrefix("fe80::/64"))
+		if err != nil || len(bindings) != 1 || bindings[0].IP != "fe80::1" {
+			t.Errorf("GetIPHistory(fe80::/64) = %+v, %v", bindings, err)
+		}
+		bindings, err = s.GetIPHistory(netip.MustParsePrefix("10.0.0.0/8"))
+		if err != nil || bindings == nil || len(bindings) != 0 {
+			t.Errorf("expected no bindings, got %+v, %v", bindings, err)
+		}
+	})
+}
+
+func TestStore_EntriesInRange(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.AddDate(0, 0, -45))
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now.AddDate(0, 0, -35))
+		if _, err := s.RollupEvents(30); err != nil {
+			t.Fatalf("RollupEvents failed: %v", err)
+		}
+		s.insertAt("192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now.AddDate(0, 0, -5))
+		s.insertAt("192.168.1.12", "ipv4", "66:77:88:99:aa:bb", now.Add(-time.Hour))
+
+		entries, err := s.GetEntries(now.AddDate(0, 0, -6), now.AddDate(0, 0, -4))
+		if err != nil || len(entries) != 1 || entries[0].MAC != "66:77:88:99:aa:bb" {
+			t.Fatalf("GetEntries(-6d, -4d) = %+v, %v", entries, err)
+		}
+		if e := entries[0]; len(e.IPv4) != 1 || e.IPv4[0] != "192.168.1.11" || len(e.Addresses) != 1 ||
+			!e.LastSeen.Equal(now.AddDate(0, 0, -5)) {
+			t.Errorf("expected only the sighting in the range, got %+v", e)
+		}
+
+		// a rollup counts with its sighting inside the range
+		entries, err = s.GetEntries(now.AddDate(0, 0, -50), now.AddDate(0, 0, -40))
+		if err != nil || len(entries) != 1 || !entries[0].LastSeen.Equal(now.AddDate(0, 0, -45)) {
+			t.Errorf("GetEntries(-50d, -40d) = %+v, %v", entries, err)
+		}
+
+		entries, err = s.GetEntries(now.Add(-2*time.Hour), time.Time{})
+		if err != nil || len(entries) != 1 || entries[0].IPv4[0] != "192.168.1.12" {
+			t.Errorf("open ended GetEntries = %+v, %v", entries, err)
+		}
+	})
+}
+
+func TestStore_Annotations(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now)
+		s.insertAt("192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now)
+
+		stored, err := s.SetAnnotation(Annotation{MAC: "00-11-22-33-44-55", Name: " Living room TV ",
+			Owner: "alice", Notes: "wall mounted\nport 3", Tags: []string{"media", "iot", "media"}})
+		if err != nil {
+			t.Fatalf("SetAnnotation failed: %v", err)
+		}
+		if stored.MAC != "00:11:22:33:44:55" || stored.Name != "Living room TV" || len(stored.Tags) != 2 ||
+			stored.Tags[0] != "iot" || stored.UpdatedAt.IsZero() {
+			t.Errorf("unexpected stored annotation: %+v", stored)
+		}
+
+		got, err := s.GetAnnotation("00:11:22:33:44:55")
+		if err != nil || got == nil || got.Name != stored.Name || got.Notes != stored.Notes ||
+			fmt.Sprint(got.Tags) != fmt.Sprint(stored.Tags) || !got.UpdatedAt.Equal(stored.UpdatedAt) {
+			t.Fatalf("GetAnnotation() = %+v, %v, want %+v", got, err, stored)
+		}
+
+		entries, err := s.GetRecentEntries(1)
+		if err != nil || len(entries) != 2 {
+			t.Fatalf("GetRecentEntries() = %+v, %v", entries, err)
+		}
+		if a := entries[0].Annotation; a == nil || a.Owner != "alice" {
+			t.Errorf("expected the annotation inline, got %+v", a)
+		}
+		if entries[1].Annotation != nil {
+			t.Errorf("unexpected annotation of %s: %+v", entries[1].MAC, entries[1].Annotation)
+		}
+
+		// a replacement clears the fields left out
+		if _, err := s.SetAnnotation(Annotation{MAC: "00:11:22:33:44:55", Name: "tv"}); err != nil {
+			t.Fatalf("SetAnnotation failed: %v", err)
+		}
+		if got, err := s.GetAnnotation("00:11:22:33:44:55"); err != nil || got.Owner != "" || len(got.Tags) != 0 {
+			t.Errorf("expected a replaced annotation, got %+v, %v", got, err)
+		}
+
+		for _, invalid := range []Annotation{
+			{MAC: "nonsense"},
+			{MAC: "00:11:22:33:44:55", Name: "two\nlines"},
+			{MAC: "00:11:22:33:44:55", Tags: []string{" "}},
+			{MAC: "00:11:22:33:44:55", Owner: strings.Repeat("x", maxAnnotationField+1)},
+		} {
+			if _, err := s.SetAnnotation(invalid); err == nil {
+				t.Errorf("expected %+v to be rejected", invalid)
+			}
+		}
+
+		if deleted, err := s.DeleteAnnotation("00:11:22:33:44:55"); err != nil || !deleted {
+			t.Errorf("DeleteAnnotation() = %v, %v", deleted, err)
+		}
+		if deleted, err := s.DeleteAnnotation("00:11:22:33:44:55"); err != nil || deleted {
+			t.Errorf("second DeleteAnnotation() = %v, %v", deleted, err)
+		}
+		if got, err := s.GetAnnotation("00:11:22:33:44:55"); err != nil || got != nil {
+			t.Errorf("expected no annotation after delete, got %+v, %v", got, err)
+		}
+	})
+}
+
+func TestStore_Devices(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:33:44:55", now)
+		s.insertAt("192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now)
+
+		devices, err := s.GetDevices(DeviceUnknown)
+		if err != nil || len(devices) != 2 || devices[0].MAC != "00:11:22:33:44:55" || devices[0].DecidedAt != nil ||
+			devices[0].LastSeen == nil || !devices[0].LastSeen.Equal(now) {
+			t.Fatalf("GetDevices(unknown) = %+v, %v", devices, err)
+		}
+
+		approved, err := s.SetDeviceStatus("00-11-22-33-44-55", DeviceApproved, " alice ")
+		if err != nil || approved.MAC != "00:11:22:33:44:55" || approved.Status != DeviceApproved ||
+			approved.Comment != "alice" || approved.DecidedAt == nil || approved.FirstSeen == nil {
+			t.Fatalf("SetDeviceStatus() = %+v, %v", approved, err)
+		}
+		// devices may be decided on before they are seen
+		if _, err := s.SetDeviceStatus("aa:aa:aa:aa:aa:aa", DeviceRejected, ""); err != nil {
+			t.Fatalf("SetDeviceStatus failed: %v", err)
+		}
+		if _, err := s.SetDeviceStatus("aa:aa:aa:aa:aa:aa", DeviceUnknown, ""); err == nil {
+			t.Error("expected unknown to be rejected as decision")
+		}
+
+		for status, want := range map[string][]string{
+			"":             {"00:11:22:33:44:55", "66:77:88:99:aa:bb", "aa:aa:aa:aa:aa:aa"},
+			DeviceUnknown:  {"66:77:88:99:aa:bb"},
+			DeviceApproved: {"00:11:22:33:44:55"},
+			DeviceRejected: {"aa:aa:aa:aa:aa:aa"},
+		} {
+			devices, err := s.GetDevices(status)
+			var macs []string
+			for _, d := range devices {
+				macs = append(macs, d.MAC)
+			}
+			if err != nil || fmt.Sprint(macs) != fmt.Sprint(want) {
+				t.Errorf("GetDevices(%q) = %v, %v, want %v", status, macs, err, want)
+			}
+		}
+		if _, err := s.GetDevices("maybe"); err == nil {
+			t.Error("expected an invalid status filter to fail")
+		}
+
+		if d, err := s.GetDevice("aa:aa:aa:aa:aa:aa"); err != nil || d == nil || d.Status != DeviceRejected || d.FirstSeen != nil {
+			t.Errorf("GetDevice(unseen) = %+v, %v", d, err)
+		}
+		if d, err := s.GetDevice("bb:bb:bb:bb:bb:bb"); err != nil || d != nil {
+			t.Errorf("GetDevice(unknown MAC) = %+v, %v", d, err)
+		}
+	})
+}
+
+func TestStore_ForgetDevice(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		hour := now.UTC().Truncate(time.Hour)
+		for _, mac := range []string{"00:11:22:33:44:55", "66:77:88:99:aa:bb"} {
+			s.insertAt("192.168.1.10", "ipv4", mac, now.Add(-time.Minute))
+			s.insertAt("192.168.1.10", "ipv4", mac, now)
+			if err := s.AddPacketCounts(map[CounterKey]PacketCounts{{MAC: mac, Hour: hour}: {ARPPackets: 2}}); err != nil {
+				t.Fatalf("AddPacketCounts failed: %v", err)
+			}
+			if _, err := s.SetAnnotation(Annotation{MAC: mac, Name: "tv"}); err != nil {
+				t.Fatalf("SetAnnotation failed: %v", err)
+			}
+			if _, err := s.SetDeviceStatus(mac, DeviceApproved, ""); err != nil {
+				t.Fatalf("SetDeviceStatus failed: %v", err)
+			}
+		}
+		if err := s.RecordDHCPServer(DHCPServer{ServerID: "192.168.1.1", MAC: "00:11:22:33:44:55", FirstSeen: now, LastSeen: now}); err != nil {
+			t.Fatalf("RecordDHCPServer failed: %v", err)
+		}
+
+		if _, err := s.ForgetDevice("00:11:22:33:44:55", "test", "a\x00b"); err == nil {
+			t.Error("expected a reason with control characters to be rejected")
+		}
+		entry, err := s.ForgetDevice("00-11-22-33-44-55", "test", " ticket 42 ")
+		if err != nil || entry == nil {
+			t.Fatalf("ForgetDevice() = %+v, %v", entry, err)
+		}
+		if entry.Action != ActionForgetDevice || entry.Source != "test" || entry.Reason != "ticket 42" || entry.ID == 0 {
+			t.Errorf("unexpected audit entry: %+v", entry)
+		}
+		for _, table := range []string{"device_annotations", "device_approvals", "dhcp_servers", "sessions"} {
+			if entry.Deleted[table] != 1 {
+				t.Errorf("expected 1 deleted row in %s, got %v", table, entry.Deleted)
+			}
+		}
+
+		entries, err := s.GetRecentEntries(1)
+		if err != nil || len(entries) != 1 || entries[0].MAC != "66:77:88:99:aa:bb" || entries[0].Annotation == nil {
+			t.Errorf("expected only the other device to remain, got %+v, %v", entries, err)
+		}
+		if d, err := s.GetDevice("00:11:22:33:44:55"); err != nil || d != nil {
+			t.Errorf("GetDevice() after forget = %+v, %v", d, err)
+		}
+		if sessions, err := s.GetSessions("00:11:22:33:44:55", 1); err != nil || len(sessions) != 0 {
+			t.Errorf("GetSessions() after forget = %+v, %v", sessions, err)
+		}
+		counts, err := s.GetPacketCounts(24)
+		if err != nil || len(counts) != 1 || counts[0].MAC != "66:77:88:99:aa:bb" {
+			t.Errorf("GetPacketCounts() after forget = %+v, %v", counts, err)
+		}
+		if servers, err := s.GetDHCPServers(); err != nil || len(servers) != 0 {
+			t.Errorf("GetDHCPServers() after forget = %+v, %v", servers, err)
+		}
+
+		if again, err := s.ForgetDevice("00:11:22:33:44:55", "test", ""); err != nil || again != nil {
+			t.Errorf("second ForgetDevice() = %+v, %v", again, err)
+		}
+		log, err := s.GetAuditLog()
+		if err != nil || len(log) != 1 || log[0].ID != entry.ID || !log[0].At.Equal(entry.At) {
+			t.Fatalf("GetAuditLog() = %+v, %v", log, err)
+		}
+		if strings.Contains(fmt.Sprintf("%+v", log[0]), "44:55") {
+			t.Errorf("the audit log must not keep the MAC: %+v", log[0])
+		}
+	})
+}
+
+func TestMemoryStore_RollsUpBeyondEventLimit(t *testing.T) {
+	origLimit := MemoryEventLimit
+	defer func() { MemoryEventLimit = origLimit }()
+	MemoryEventLimit = 8
+
+	m := NewMemoryStore()
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 20; i++ {
+		m.insertEvent(fmt.Sprintf("192.168.1.%d", i%2+10), "ipv4", "00:11:22:33:44:55", start.Add(time.Duration(i)*time.Minute))
+	}
+	if m.events > MemoryEventLimit {
+		t.Errorf("kept %d raw events, limit %d", m.events, MemoryEventLimit)
+	}
+
+	bindings, err := m.GetIPHistory(netip.MustParsePrefix("192.168.1.0/24"))
+	if err != nil {
+		t.Fatalf("GetIPHistory failed: %v", err)
+	}
+	var sightings int64
+	rolledUp := false
+	for _, b := range bindings {
+		sightings += b.Sightings
+		rolledUp = rolledUp || b.RolledUp && b.FirstSeen.Equal(start.UTC())
+	}
+	if sightings != 20 || !rolledUp {
+		t.Errorf("expected all 20 sightings with the oldest rolled up, got %+v", bindings)
+	}
+	entries, err := m.GetEntries(time.Time{}, time.Time{})
+	if err != nil || len(entries) != 1 || len(entries[0].Addresses) != 2 || entries[0].Addresses[0].Count != 10 {
+		t.Errorf("GetEntries() = %+v, %v", entries, err)
+	}
+}
+
+func TestForgetDevice_LeavesNothingOnDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arpmonitor.db")
+	database, err := InitDB(path)
+	if err != nil {
+		t.Fatalf("InitDB failed: %v", err)
+	}
+	defer func() { _ = database.Close() }()
+	for i := 0; i < 50; i++ {
+		if err := InsertARPEvent(database, "192.168.77.77", "de:ad:be:ef:77:77"); err != nil {
+			t.Fatalf("InsertARPEvent failed: %v", err)
+		}
+	}
+	if err := InsertARPEvent(database, "192.168.1.10", "00:11:22:33:44:55"); err != nil {
+		t.Fatalf("InsertARPEvent failed: %v", err)
+	}
+
+	if entry, err := ForgetDevice(database, "de:ad:be:ef:77:77", "test", ""); err != nil || entry == nil {
+		t.Fatalf("ForgetDevice() = %v, %v", entry, err)
+	}
+	for _, file := range []string{path, path + "-wal"} {
+		data, err := os.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		for _, secret := range []string{"de:ad:be:ef:77:77", "192.168.77.77"} {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s still contains %s", filepath.Base(file), secret)
+			}
+		}
+	}
+}
+
+func TestMemoryStore_ForgetDeviceAnyCase(t *testing.T) {
+	m := NewMemoryStore()
+	// written by an older version that did not normalize every MAC
+	m.annotations["00:AA:BB:CC:DD:EE"] = Annotation{MAC: "00:AA:BB:CC:DD:EE", Name: "tv"}
+	m.approvals["00:AA:BB:CC:DD:EE"] = Device{MAC: "00:AA:BB:CC:DD:EE", Status: DeviceApproved}
+
+	entry, err := m.ForgetDevice("00-aa-bb-cc-dd-ee", "test", "")
+	if err != nil || entry == nil || entry.Deleted["device_annotations"] != 1 || entry.Deleted["device_approvals"] != 1 {
+		t.Fatalf("ForgetDevice() = %+v, %v", entry, err)
+	}
+	if len(m.annotations) != 0 || len(m.approvals) != 0 {
+		t.Errorf("expected nothing left, got %v and %v", m.annotations, m.approvals)
+	}
+}
+
+func TestStore_GetEntry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		s.insertAt("10.0.0.1", "ipv4", "00:11:22:AA:BB:CC", now.AddDate(0, 0, -30))
+		s.insertAt("192.168.1.10", "ipv4", "00:11:22:AA:BB:CC", now.Add(-time.Hour))
+		s.insertAt("fe80::1", "ipv6", "00:11:22:AA:BB:CC", now)
+		s.insertAt("192.168.1.11", "ipv4", "66:77:88:99:aa:bb", now)
+
+		entry, err := s.GetEntry("00:11:22:aa:bb:cc")
+		if err != nil || entry == nil {
+			t.Fatalf("GetEntry() = %+v, %v", entry, err)
+		}
+		if entry.MAC != "00:11:22:AA:BB:CC" || len(entry.IPv4) != 2 || entry.IPv4[0] != "192.168.1.10" || len(entry.IPv6) != 1 {
+			t.Errorf("unexpected entry: %+v", entry)
+		}
+		if len(entry.Addresses) != 3 || entry.Addresses[0].IP != "fe80::1" || entry.Addresses[2].IP != "10.0.0.1" {
+			t.Errorf("expected all addresses, newest first: %+v", entry.Addresses)
+		}
+		if !entry.LastSeen.Equal(now) || !entry.FirstSeen.Equal(now.AddDate(0, 0, -30)) {
+			t.Errorf("unexpected first/last seen: %v / %v", entry.FirstSeen, entry.LastSeen)
+		}
+
+		if _, err := s.SetAnnotation(Annotation{MAC: "66:77:88:99:aa:bb", Name: "printer"}); err != nil {
+			t.Fatalf("SetAnnotation failed: %v", err)
+		}
+		if entry, err := s.GetEntry("66:77:88:99:aa:bb"); err != nil || entry == nil || entry.Annotation == nil ||
+			entry.Annotation.Name != "printer" {
+			t.Errorf("expected the annotation, got %+v, %v", entry, err)
+		}
+		if entry, err := s.GetEntry("aa:aa:aa:aa:aa:aa"); err != nil || entry != nil {
+			t.Errorf("GetEntry(unknown MAC) = %+v, %v", entry, err)
+		}
+	})
+}
+
+func TestStore_FindHostname(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		if _, err := s.SetAnnotation(Annotation{MAC: "00:11:22:33:44:01", Name: "NAS"}); err != nil {
+			t.Fatalf("SetAnnotation failed: %v", err)
+		}
+		for _, h := range []struct{ mac, hostname string }{
+			{"00:11:22:33:44:02", "nas.lan."},
+			{"00:11:22:33:44:03", "printer.lan."},
+			{"00:11:22:33:44:04", "nas"},
+		} {
+			if err := s.RecordHostname(h.mac, h.hostname, "dns"); err != nil {
+				t.Fatalf("RecordHostname failed: %v", err)
+			}
+		}
+
+		for name, want := range map[string][]string{
+			"nas.":    {"00:11:22:33:44:01", "00:11:22:33:44:04"},
+			"NAS.lan": {"00:11:22:33:44:02"},
+			"tv":      {},
+		} {
+			got, err := s.FindHostname(name)
+			if err != nil || len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+				t.Errorf("FindHostname(%q) = %v, %v; want %v", name, got, err, want)
+			}
+		}
+	})
+}
+
+func TestStore_Changes(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s storeUnderTest) {
+		now := time.Now()
+		s.insertAt("10.0.0.1", "ipv4", "00:00:00:00:00:01", now.Add(-3*time.Hour))
+		s.insertAt("10.0.0.2", "ipv4", "00:00:00:00:00:01", now.Add(-30*time.Minute))
+		s.insertAt("10.0.0.2", "ipv4", "00:00:00:00:00:01", now.Add(-time.Minute))
+		s.insertAt("10.0.0.3", "ipv4", "00:00:00:00:00:02", now.Add(-30*time.Minute))
+		s.insertAt("10.0.0.3", "ipv4", "00:00:00:00:00:02", now.Add(-time.Minute))
+		s.insertAt("10.0.0.4", "ipv4", "00:00:00:00:00:03", now.Add(-3*time.Hour))
+		s.insertAt("10.0.0.4", "ipv4", "00:00:00:00:00:03", now.Add(-40*time.Minute))
+		s.insertAt("10.0.0.5", "ipv4", "00:00:00:00:00:04", now.Add(-2*time.Hour))
+		// moves from A to B and back to A, an address it used before
+		s.insertAt("10.0.0.6", "ipv4", "00:00:00:00:00:05", now.Add(-3*time.Hour))
+		s.insertAt("fe80::5", "ipv6", "00:00:00:00:00:05", now.Add(-20*time.Minute))
+		s.insertAt("10.0.0.7", "ipv4", "00:00:00:00:00:05", now.Add(-15*time.Minute))
+		s.insertAt("10.0.0.6", "ipv4", "00:00:00:00:00:05", now.Add(-5*time.Minute))
+		for _, h := range []struct{ mac, hostname string }{
+			{"00:00:00:00:00:01", "a.lan."}, {"00:00:00:00:00:02", "b.lan."}, {"00:00:00:00:00:01", "a2.lan."},
+		} {
+			if err := s.RecordHostname(h.mac, h.hostname, "dns"); err != nil {
+				t.Fatalf("RecordHostname failed: %v", err)
+			}
+		}
+
+		changes, err := s.GetChanges(now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("GetChanges failed: %v", err)
+		}
+		want := []Change{
+			{MAC: "00:00:00:00:00:01", Type: ChangeIP, At: now.Add(-30 * time.Minute), IP: "10.0.0.2", PreviousIP: "10.0.0.1", Hostname: "a2.lan."},
+			{MAC: "00:00:00:00:00:02", Type: ChangeNew, At: now.Add(-30 * time.Minute), IP: "10.0.0.3", Hostname: "b.lan."},
+			{MAC: "00:00:00:00:00:03", Type: ChangeSilent, At: now.Add(-40*time.Minute + SessionGap)},
+			{MAC: "00:00:00:00:00:05", Type: ChangeIP, At: now.Add(-15 * time.Minute), IP: "10.0.0.7", PreviousIP: "10.0.0.6"},
+			{MAC: "00:00:00:00:00:05", Type: ChangeIP, At: now.Add(-5 * time.Minute), IP: "10.0.0.6", PreviousIP: "10.0.0.7"},
+			{MAC: "00:00:00:00:00:01", Type: ChangeHostname, Hostname: "a2.lan.", PreviousHostname: "a.lan."},
+		}
+		if len(changes) != len(want) {
+			t.Fatalf("GetChanges() = %+v, want %+v", changes, want)
+		}
+		for i, c := range changes {
+			at := c.At
+			c.At = want[i].At
+			if c != want[i] || (!want[i].At.IsZero() && !at.Equal(want[i].At)) || at.Before(now.Add(-time.Hour)) {
+				t.Errorf("change %d = %+v at %v, want %+v", i, c, at, want[i])
+			}
+		}
+
+		if changes, err := s.GetChanges(now.Add(time.Minute)); err != nil || len(changes) != 0 {
+			t.Errorf("GetChanges(future) = %+v, %v", changes, err)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// timeFormat is how all timestamps are stored: UTC without offset and with fixed-width
+// nanoseconds, so stored values compare correctly as text, against each other as well as
+// against the output of SQLite's datetime().
+const timeFormat = "2006-01-02 15:04:05.000000000"
+
+// dbTime converts t into its stored form. Always bind timestamps through dbTime, the driver
+// would otherwise store them in the local time zone.
+func dbTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// parseDBTime parses a stored timestamp. Besides timeFormat it accepts everything the driver
+// used to write, values without offset are taken as UTC like SQLite's CURRENT_TIMESTAMP.
+func parseDBTime(s string) (time.Time, bool) {
+	if t, err := time.ParseInLocation(timeFormat, s, time.UTC); err == nil {
+		return t, true
+	}
+	s = strings.TrimSuffix(s, "Z") // like the driver, the formats have no Z
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, s, time.UTC); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// rangeEnd is the stored form of the end of a time range, a zero until is open ended.
+func rangeEnd(until time.Time) string {
+	if until.IsZero() {
+		return "9999-12-31 23:59:59.999999999"
+	}
+	return dbTime(until)
+}
+
+// daysAgo is the start of a window of the given number of days ending now. Days are plain
+// 24 hour periods, so the window is the same in every time zone and across DST changes.
+func daysAgo(days int) time.Time {
+	return time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+}
+
+// timestampColumns lists every timestamp column, converted to timeFormat by migration 4.
+var timestampColumns = map[string][]string{
+	"arp_events":       {"seen_at"},
+	"arp_rollups":      {"first_seen", "last_seen"},
+	"devices":          {"first_seen", "last_seen"},
+	"device_addresses": {"first_seen", "last_seen"},
+	"dhcp_servers":     {"first_seen", "last_seen"},
+	"mac_counters":     {"hour"},
+	"schema_version":   {"applied_at"},
+}
+
+// convertBatchSize bounds the rows held in memory while converting a table.
+const convertBatchSize = 5000
+
+// convertTimestamps rewrites all stored timestamps to timeFormat. Older versions stored them in
+// the local time zone of the host with its offset, which compares wrong against UTC windows.
+// Values that cannot be parsed are left as they are.
+func convertTimestamps(tx *sql.Tx) error {
+	for table, columns := range timestampColumns {
+		if err := convertTableTimestamps(tx, table, columns); err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func convertTableTimestamps(tx *sql.Tx, table string, columns []string) error {
+	selects := make([]string, len(columns))
+	sets := make([]string, len(columns))
+	for i, c := range columns {
+		selects[i] = "CAST(" + c + " AS TEXT)"
+		sets[i] = c + " = ?"
+	}
+	query := fmt.Sprintf(`SELECT rowid, %s FROM %s WHERE rowid > ? ORDER BY rowid LIMIT %d`,
+		strings.Join(selects, ", "), table, convertBatchSize)
+	update := fmt.Sprintf(`UPDATE %s SET %s WHERE rowid = ?`, table, strings.Join(sets, ", "))
+
+	type row struct {
+		id     int64
+		values []sql.NullString
+	}
+	var last int64
+	for {
+		// read a whole batch before updating, SQLite must not change rows under an open query
+		var batch []row
+		rows, err := tx.Query(query, last)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			r := row{values: make([]sql.NullString, len(columns))}
+			dest := []any{&r.id}
+			for i := range r.values {
+				dest = append(dest, &r.values[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				_ = rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		for _, r := range batch {
+			args := make([]any, 0, len(columns)+1)
+			for _, v := range r.values {
+				if t, ok := parseDBTime(v.String); v.Valid && ok {
+					args = append(args, dbTime(t))
+				} else {
+					args = append(args, v)
+				}
+			}
+			if _, err := tx.Exec(update, append(args, r.id)...); err != nil {
+				return err
+			}
+		}
+		last = batch[len(batch)-1].id
+	}
+}
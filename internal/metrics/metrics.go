@@ -0,0 +1,286 @@
+// Package metrics keeps counters and histograms and writes them in the Prometheus text exposition
+// format, without pulling in the Prometheus client.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Collector writes one metric family in the text exposition format.
+type Collector interface {
+	Write(w io.Writer) error
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []Collector
+)
+
+// Register adds c to the metrics written by WriteAll, in registration order.
+func Register(c Collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// WriteAll writes every registered metric.
+func WriteAll(w io.Writer) error {
+	registryMu.Lock()
+	collectors := append([]Collector(nil), registry...)
+	registryMu.Unlock()
+
+	for _, c := range collectors {
+		if err := c.Write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Counter is a monotonically increasing value per combination of label values.
+type Counter struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounter returns a registered counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]*counterValue)}
+	Register(c)
+	return c
+}
+
+// Inc adds one to the counter of labelValues, given in the order of the label names.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter of labelValues.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, exists := c.values[key]
+	if !exists {
+		cv = &counterValue{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+// Value returns the counter of labelValues, 0 if it was never increased.
+func (c *Counter) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cv, exists := c.values[strings.Join(labelValues, "\xff")]; exists {
+		return cv.value
+	}
+	return 0
+}
+
+func (c *Counter) Write(w io.Writer) error {
+	c.mu.Lock()
+	samples := make([]Sample, 0, len(c.values))
+	for _, cv := range c.values {
+		samples = append(samples, Sample{LabelValues: cv.labelValues, Value: cv.value})
+	}
+	c.mu.Unlock()
+	if len(c.labels) == 0 && len(samples) == 0 {
+		samples = append(samples, Sample{})
+	}
+
+	return writeFamily(w, c.name, c.help, "counter", c.labels, samples)
+}
+
+// DefaultLatencyBuckets are histogram buckets in seconds for operations between well below a
+// millisecond and a few seconds.
+var DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// Histogram counts observations in buckets per combination of label values.
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	counts      []uint64 // per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+// NewHistogram returns a registered histogram with the given upper bucket bounds, ascending.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	Register(h)
+	return h
+}
+
+// Observe records v for labelValues.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, exists := h.values[key]
+	if !exists {
+		hv = &histogramValue{labelValues: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hv.counts[i]++
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) Write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := writeHeader(w, h.name, h.help, "histogram"); err != nil {
+		return err
+	}
+	for _, hv := range sortedHistogramValues(h.values) {
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hv.counts[i]
+			if err := writeSample(w, h.name+"_bucket", withLabel(h.labels, "le"),
+				withLabel(hv.labelValues, formatFloat(bound)), float64(cumulative)); err != nil {
+				return err
+			}
+		}
+		if err := writeSample(w, h.name+"_bucket", withLabel(h.labels, "le"),
+			withLabel(hv.labelValues, "+Inf"), float64(hv.count)); err != nil {
+			return err
+		}
+		if err := writeSample(w, h.name+"_sum", h.labels, hv.labelValues, hv.sum); err != nil {
+			return err
+		}
+		if err := writeSample(w, h.name+"_count", h.labels, hv.labelValues, float64(hv.count)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withLabel returns a copy of labels with one more appended, never sharing the backing array.
+func withLabel(labels []string, label string) []string {
+	return append(labels[:len(labels):len(labels)], label)
+}
+
+func sortedHistogramValues(values map[string]*histogramValue) []*histogramValue {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sorted := make([]*histogramValue, len(keys))
+	for i, key := range keys {
+		sorted[i] = values[key]
+	}
+	return sorted
+}
+
+// Sample is one value of a Func.
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// Func is a metric whose samples are collected when it is written, e.g. from a database or from
+// libpcap statistics. Type is "counter" or "gauge".
+type Func struct {
+	Name, Help, Type string
+	Labels           []string
+	Collect          func() []Sample
+}
+
+func (f *Func) Write(w io.Writer) error {
+	return writeFamily(w, f.Name, f.Help, f.Type, f.Labels, f.Collect())
+}
+
+// writeFamily writes the header and the samples ordered by their label values.
+func writeFamily(w io.Writer, name, help, typ string, labels []string, samples []Sample) error {
+	if err := writeHeader(w, name, help, typ); err != nil {
+		return err
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].LabelValues, "\xff") < strings.Join(samples[j].LabelValues, "\xff")
+	})
+	for _, s := range samples {
+		if err := writeSample(w, name, labels, s.LabelValues, s.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeHeader(w io.Writer, name, help, typ string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+	return err
+}
+
+func writeSample(w io.Writer, name string, labels, labelValues []string, value float64) error {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			v := ""
+			if i < len(labelValues) {
+				v = labelValues[i]
+			}
+			b.WriteString(label)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(v))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
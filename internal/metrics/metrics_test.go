@@ -0,0 +1,81 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func write(t *testing.T, c Collector) string {
+	t.Helper()
+	var b strings.Builder
+	if err := c.Write(&b); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	return b.String()
+}
+
+func TestCounter_Write(t *testing.T) {
+	c := &Counter{name: "test_total", help: "Test\\counter\nwith two lines.", labels: []string{"type"}, values: map[string]*counterValue{}}
+	c.Inc("b")
+	c.Add(2.5, "a")
+	c.Inc(`say "hi"`)
+	c.Inc("b")
+
+	want := `# HELP test_total Test\\counter\nwith two lines.
+# TYPE test_total counter
+test_total{type="a"} 2.5
+test_total{type="b"} 2
+test_total{type="say \"hi\""} 1
+`
+	if got := write(t, c); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if v := c.Value("b"); v != 2 {
+		t.Errorf("Value(b) = %v, want 2", v)
+	}
+	if v := c.Value("c"); v != 0 {
+		t.Errorf("Value(c) = %v, want 0", v)
+	}
+
+	unlabeled := &Counter{name: "events_total", help: "Events.", values: map[string]*counterValue{}}
+	if got := write(t, unlabeled); !strings.HasSuffix(got, "\nevents_total 0\n") {
+		t.Errorf("expected an unlabeled counter to start at 0, got\n%s", got)
+	}
+}
+
+func TestHistogram_Write(t *testing.T) {
+	h := &Histogram{name: "latency_seconds", help: "Latency.", labels: []string{"kind"}, buckets: []float64{.1, 1},
+		values: map[string]*histogramValue{}}
+	h.Observe(.05, "db")
+	h.Observe(.1, "db")
+	h.Observe(.5, "db")
+	h.Observe(3, "db")
+
+	want := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{kind="db",le="0.1"} 2
+latency_seconds_bucket{kind="db",le="1"} 3
+latency_seconds_bucket{kind="db",le="+Inf"} 4
+latency_seconds_sum{kind="db"} 3.65
+latency_seconds_count{kind="db"} 4
+`
+	if got := write(t, h); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFunc_Write(t *testing.T) {
+	f := &Func{Name: "devices", Help: "Devices.", Type: "gauge", Labels: []string{"family"},
+		Collect: func() []Sample {
+			return []Sample{{LabelValues: []string{"ipv6"}, Value: 1}, {LabelValues: []string{"ipv4"}, Value: 3}}
+		}}
+
+	want := `# HELP devices Devices.
+# TYPE devices gauge
+devices{family="ipv4"} 3
+devices{family="ipv6"} 1
+`
+	if got := write(t, f); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
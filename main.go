@@ -6,40 +6,401 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/vgropp/arpmonitor/api"
+	"github.com/vgropp/arpmonitor/internal/alert"
 	"github.com/vgropp/arpmonitor/internal/arp"
 	"github.com/vgropp/arpmonitor/internal/db"
 )
 
 const IPV4_PREFERED = "192.168."
 
+const defaultDBFile = "/var/lib/arpmonitor/arpmonitor.db"
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backup":
+			os.Exit(runBackup(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "devices":
+			os.Exit(runDevices(os.Args[2:]))
+		case "approve":
+			os.Exit(runDecision(db.DeviceApproved, os.Args[2:]))
+		case "reject":
+			os.Exit(runDecision(db.DeviceRejected, os.Args[2:]))
+		case "forget":
+			os.Exit(runForget(os.Args[2:]))
+		}
+	}
+
 	iface := flag.String("iface", "eth0", "interface for ARP/NDP Monitoring")
-	dbfile := flag.String("db", "/var/lib/arpmonitor/arpmonitor.db", "path to database file")
+	dbfile := flag.String("db", defaultDBFile, "path to database file")
+	storage := flag.String("storage", "sqlite", "storage backend: sqlite (persistent, see -db) or memory (lost on restart)")
 	resolveIpv6 := flag.Bool("resolve-ipv6", false, "resolve IPv6 addresses")
 	resolveKeaLeases := flag.Bool("resolve-kea-leases", true, "resolve kea leases for hostnames")
 	filterZeroIps := flag.Bool("filter-zero-ips", true, "filter out 0.0.0.0 IP addresses (default: true)")
 	preferIpv4Net := flag.String("prefer-ipv4-net", IPV4_PREFERED, "network prefix for IPv4-Adressen, which will be prefered if multiple addresses are available (default: 192.168.)")
 	port := flag.Int("port", 8567, "HTTP API Port")
+	dhcpAllowlist := flag.String("dhcp-allowlist", "", "comma separated DHCP server IPs or MACs allowed to hand out leases, others raise an alert (empty: no alerts)")
+	countAllFrames := flag.Bool("count-all-frames", false, "capture all frames to count frames/bytes per MAC, not only ARP/NDP/DHCP")
+	defaultCapture := arp.DefaultCaptureOptions()
+	promisc := flag.Bool("promisc", defaultCapture.Promiscuous, "capture in promiscuous mode")
+	snaplen := flag.Int("snaplen", defaultCapture.Snaplen, "maximum number of bytes captured per packet")
+	bufferSize := flag.Int("buffer-size", 0, "kernel capture buffer size in bytes (0: libpcap default)")
+	immediateMode := flag.Bool("immediate-mode", false, "deliver packets immediately instead of buffering them")
+	bpfFilter := flag.String("bpf-filter", "", "additional BPF expression AND-ed with the built-in filter, e.g. \"vlan 20\"")
+	retentionDays := flag.Int("retention-days", 0, "roll up raw events older than this many days into per MAC/IP summaries (0: keep forever)")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "print pending database migrations and exit without applying them")
+	alertWebhook := flag.String("alert-webhook", "", "URL to POST alerts to as JSON (alerts are always logged)")
+	sessionGap := flag.Duration("session-gap", db.SessionGap, "a device silent for longer than this starts a new presence session")
+	adminToken := flag.String("admin-token", "", "bearer token for /api/admin and the endpoints that change data (empty: those endpoints are disabled)")
+	hostnameInterval := flag.Duration("hostname-interval", 5*time.Minute, "how often the hostnames of recently seen devices are resolved to detect renames for /api/changes (0: never)")
+	alertUnknownDevices := flag.Bool("alert-unknown-devices", false, "alert hourly on each device that was not approved, including rejected devices")
 	flag.Parse()
 
-	database, err := db.InitDB(*dbfile)
+	if *migrateDryRun {
+		pending, err := db.DryRunMigrations(*dbfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+			os.Exit(1)
+		}
+		if len(pending) == 0 {
+			fmt.Printf("database %s is up to date (schema version %d)\n", *dbfile, db.LatestSchemaVersion())
+		}
+		for _, m := range pending {
+			fmt.Printf("pending migration %d: %s\n", m.Version, m.Description)
+		}
+		return
+	}
+
+	alert.WebhookURL = *alertWebhook
+	api.AdminToken = *adminToken
+	db.CaptureInterface = *iface
+	if *sessionGap <= 0 {
+		fmt.Fprintf(os.Stderr, "arpmonitor: -session-gap must be positive\n")
+		os.Exit(2)
+	}
+	db.SessionGap = *sessionGap
+	if *dhcpAllowlist != "" {
+		arp.SetDHCPAllowlist(strings.Split(*dhcpAllowlist, ","))
+	}
+	arp.SetUnknownDeviceAlerts(*alertUnknownDevices)
+	api.ForgetSniffer = arp.ForgetDevice
+
+	captureOpts := arp.CaptureOptions{
+		Promiscuous:    *promisc,
+		Snaplen:        *snaplen,
+		BufferSize:     *bufferSize,
+		ImmediateMode:  *immediateMode,
+		ExtraFilter:    *bpfFilter,
+		CountAllFrames: *countAllFrames,
+	}
+	handle, err := arp.OpenCapture(*iface, captureOpts)
 	if err != nil {
-		log.Fatalf("DB Fehler: %v", err)
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		os.Exit(1)
+	}
+
+	var store db.Store
+	switch *storage {
+	case "sqlite":
+		store, err = db.NewSQLiteStore(*dbfile)
+		if err != nil {
+			log.Fatalf("DB Fehler: %v", err)
+		}
+	case "memory":
+		store = db.NewMemoryStore()
+	default:
+		fmt.Fprintf(os.Stderr, "arpmonitor: unknown storage %q, use sqlite or memory\n", *storage)
+		os.Exit(2)
 	}
 	defer func() {
-		closeErr := database.Close()
+		closeErr := store.Close()
 		if closeErr != nil && err == nil {
-			err = fmt.Errorf("database.Close: %w", closeErr)
+			err = fmt.Errorf("store.Close: %w", closeErr)
 		}
 	}()
 
-	go arp.StartSniffer(*iface, database)
-	go api.StartAPI(*port, database, *resolveIpv6, *preferIpv4Net, *filterZeroIps, *resolveKeaLeases)
+	go arp.StartSniffer(handle, store)
+	go db.RunRetention(store, *retentionDays, time.Hour)
+	go api.RunHostnameRecorder(store, *hostnameInterval, *resolveIpv6, *preferIpv4Net, *resolveKeaLeases)
+	go api.StartAPI(*port, store, *resolveIpv6, *preferIpv4Net, *filterZeroIps, *resolveKeaLeases)
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	<-sig
+	arp.FlushCounters(store)
+}
+
+// runBackup implements "arpmonitor backup [-db path] [-gzip] <file>", writing a consistent snapshot
+// of the database while a running arpmonitor keeps writing to it. A file of "-" writes to stdout.
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dbfile := fs.String("db", defaultDBFile, "path to database file")
+	compress := fs.Bool("gzip", false, "gzip compress the snapshot")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: arpmonitor backup [-db path] [-gzip] <file|->\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	out, err := createOutput(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		return 1
+	}
+	if err := closeOutput(out, db.BackupFile(*dbfile, out, *compress)); err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: backup failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runExport implements "arpmonitor export [-db path] [-format jsonl|csv] <file>", dumping all
+// devices, addresses, rollups, events, annotations and approvals. A file of "-" writes to stdout.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dbfile := fs.String("db", defaultDBFile, "path to database file")
+	format := fs.String("format", db.FormatJSONL, "dump format: jsonl or csv")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: arpmonitor export [-db path] [-format jsonl|csv] <file|->\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	database, err := db.OpenReadOnly(*dbfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		return 1
+	}
+	defer func() { _ = database.Close() }()
+
+	out, err := createOutput(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		return 1
+	}
+	if err := closeOutput(out, db.Export(database, out, *format)); err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: export failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// runImport implements "arpmonitor import [-db path] [-format jsonl|csv] <file>", merging a dump
+// written by export into the database. A file of "-" reads from stdin.
+func runImport(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dbfile := fs.String("db", defaultDBFile, "path to database file, created if missing")
+	format := fs.String("format", db.FormatJSONL, "dump format: jsonl or csv")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: arpmonitor import [-db path] [-format jsonl|csv] <file|->\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	in := os.Stdin
+	if src := fs.Arg(0); src != "-" {
+		f, err := os.Open(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+			return 1
+		}
+		defer func() { _ = f.Close() }()
+		in = f
+	}
+	database, err := db.InitDB(*dbfile)
+	if database != nil {
+		defer func() { _ = database.Close() }()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		return 1
+	}
+
+	stats, err := db.Import(database, in, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: import failed, nothing imported: %v\n", err)
+		return 1
+	}
+	fmt.Printf("imported %d devices, %d addresses, %d rollups, %d events, %d annotations and %d approvals, skipped %d duplicates\n",
+		stats.Devices, stats.Addresses, stats.Rollups, stats.Events, stats.Annotations, stats.Approvals, stats.Duplicates)
+	return 0
+}
+
+// runDevices implements "arpmonitor devices [-db path] [-status unknown|approved|rejected]",
+// listing the devices for review.
+func runDevices(args []string) int {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	dbfile := fs.String("db", defaultDBFile, "path to database file")
+	status := fs.String("status", "", "only list devices with this status: unknown, approved or rejected")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: arpmonitor devices [-db path] [-status unknown|approved|rejected]\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := db.CheckDeviceStatus(*status); err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		return 2
+	}
+
+	database, err := db.OpenReadOnly(*dbfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		return 1
+	}
+	defer func() { _ = database.Close() }()
+
+	devices, err := db.GetDevices(database, *status)
+	if err != nil && !db.IsPartial(err) {
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		return 1
+	}
+	fmt.Printf("# %-18s %-9s %-25s %s\n", "MAC-Address", "Status", "Last seen", "Comment")
+	for _, d := range devices {
+		lastSeen := "never"
+		if d.LastSeen != nil {
+			lastSeen = d.LastSeen.Format(time.RFC3339)
+		}
+		fmt.Printf("%-20s %-9s %-25s %s\n", d.MAC, d.Status, lastSeen, d.Comment)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: warning: %v\n", err)
+	}
+	return 0
+}
+
+// runDecision implements "arpmonitor approve|reject [-db path] [-comment text] <mac>...".
+func runDecision(status string, args []string) int {
+	name := map[string]string{db.DeviceApproved: "approve", db.DeviceRejected: "reject"}[status]
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	dbfile := fs.String("db", defaultDBFile, "path to database file, created if missing")
+	comment := fs.String("comment", "", "reason for the decision, e.g. the owner of the device")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: arpmonitor %s [-db path] [-comment text] <mac>...\n", name)
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	for _, mac := range fs.Args() {
+		if _, _, err := db.NormalizeDecision(mac, status, *comment); err != nil {
+			fmt.Fprintf(os.Stderr, "arpmonitor: %s: %v\n", mac, err)
+			return 2
+		}
+	}
+
+	database, err := db.InitDB(*dbfile)
+	if database != nil {
+		defer func() { _ = database.Close() }()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		return 1
+	}
+	for _, mac := range fs.Args() {
+		device, err := db.SetDeviceStatus(database, mac, status, *comment)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "arpmonitor: %s: %v\n", mac, err)
+			return 1
+		}
+		fmt.Printf("%s %s\n", device.MAC, device.Status)
+	}
+	return 0
+}
+
+// runForget implements "arpmonitor forget [-db path] [-reason text] <mac>...", deleting everything
+// stored about the MACs. Only the number of deleted rows is kept, in the audit log. A running monitor
+// is not told and must be stopped first, or it writes back the counters it still holds.
+func runForget(args []string) int {
+	fs := flag.NewFlagSet("forget", flag.ExitOnError)
+	dbfile := fs.String("db", defaultDBFile, "path to database file")
+	reason := fs.String("reason", "", "reason kept in the audit log, e.g. the reference of the deletion request")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: arpmonitor forget [-db path] [-reason text] <mac>...\n")
+		fmt.Fprintf(fs.Output(), "Stop a running monitor first, it would write the counters it still holds for the MACs;\n")
+		fmt.Fprintf(fs.Output(), "while it runs, use DELETE /api/devices/{mac} instead.\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	for _, mac := range fs.Args() {
+		if _, _, err := db.NormalizeForget(mac, *reason); err != nil {
+			fmt.Fprintf(os.Stderr, "arpmonitor: %s: %v\n", mac, err)
+			return 2
+		}
+	}
+
+	database, err := db.InitDB(*dbfile)
+	if database != nil {
+		defer func() { _ = database.Close() }()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "arpmonitor: %v\n", err)
+		return 1
+	}
+	for _, mac := range fs.Args() {
+		entry, err := db.ForgetDevice(database, mac, "cli", *reason)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "arpmonitor: %s: %v\n", mac, err)
+			return 1
+		}
+		if entry == nil {
+			fmt.Printf("%s: nothing stored\n", mac)
+			continue
+		}
+		var rows int64
+		for _, n := range entry.Deleted {
+			rows += n
+		}
+		fmt.Printf("%s: deleted %d rows (audit entry %d)\n", mac, rows, entry.ID)
+	}
+	return 0
+}
+
+// createOutput opens dest for a subcommand's output, "-" is stdout. Existing files are never overwritten.
+func createOutput(dest string) (*os.File, error) {
+	if dest == "-" {
+		return os.Stdout, nil
+	}
+	return os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+}
+
+// closeOutput closes out and removes it again if writing failed with err.
+func closeOutput(out *os.File, err error) error {
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil && out != os.Stdout {
+		_ = os.Remove(out.Name())
+	}
+	return err
 }